@@ -213,29 +213,12 @@ export class AvatarManager {
 
     async loadVoices() {
         try {
-            const response = await fetch('/data/voices.csv');
-            const csvText = await response.text();
-            
-            // Parse CSV
-            const lines = csvText.split('\n').filter(line => line.trim());
-            const headers = lines[0].split(',').map(h => h.trim());
-            
-            this.voices = lines.slice(1).map(line => {
-                const values = line.split(',').map(v => v.trim());
-                const voice = headers.reduce((obj, header, index) => {
-                    // Don't include empty values
-                    if (values[index] && values[index] !== '') {
-                        obj[header] = values[index];
-                    }
-                    return obj;
-                }, {});
-                
-                // Only include if it has required fields
-                if (voice.provider && voice.voice_id) {
-                    return voice;
-                }
-                return null;
-            }).filter(Boolean); // Remove null entries
+            const response = await fetch('/api/tts/voices');
+            if (!response.ok) {
+                throw new Error('Failed to fetch voices');
+            }
+            const data = await response.json();
+            this.voices = data.voices;
 
             if (this.onVoicesChange) {
                 this.onVoicesChange(this.voices);
@@ -307,7 +290,41 @@ export class AvatarManager {
                     this.showToast('Failed to play voice sample', 'error');
                 }
             });
+            return;
+        }
+
+        // No recorded sample, synthesize a preview instead
+        const audio = new Audio();
+        this.currentAudio = audio;
+        this.fetchVoicePreview(voice)
+            .then(data => {
+                if (this.currentAudio !== audio) return; // stopped meanwhile
+                audio.src = `data:${data.mime_type};base64,${data.audio}`;
+                return audio.play();
+            })
+            .catch(error => {
+                console.error('Error playing voice preview:', error);
+                if (this.showToast) {
+                    this.showToast('Failed to play voice sample', 'error');
+                }
+                if (audio.onended) audio.onended();
+            });
+    }
+
+    async fetchVoicePreview(voice, text = '') {
+        const response = await fetch('/api/tts/preview', {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify({
+                voice_id: voice.voice_id,
+                voice_provider: voice.provider,
+                text
+            })
+        });
+        if (!response.ok) {
+            throw new Error(await response.text());
         }
+        return response.json();
     }
 
     stopVoiceSample() {
@@ -14,6 +14,8 @@ import (
 type Update struct {
 	Type string     `json:"type"`
 	Data interface{} `json:"data"`
+	Priority *int   `json:"priority,omitempty"` // TTS queue lane, overrides the computed one
+	AvatarID string `json:"avatar_id,omitempty"` // TTS avatar, for the explicit selection strategy
 
 }
 
@@ -72,17 +74,27 @@ func (b *Broadcaster) HandleSSE(w http.ResponseWriter, r *http.Request) {
 
 // Broadcast sends an update to all connected clients
 func (b *Broadcaster) Broadcast(update Update) error {
+	_, err := b.Submit(update)
+	return err
+}
+
+// Submit broadcasts an update like Broadcast. For tts updates, which go to
+// the TTS queue instead, it returns the ID of the TTS job.
+func (b *Broadcaster) Submit(update Update) (string, error) {
 	log.Println("Starting broadcast...")
 
 	// Use TTS middleware to check if we should broadcast
-	if b.ttsMiddleware != nil && !b.ttsMiddleware.InterceptTTS(update.Type, update.Data) {
-		return nil
+	if b.ttsMiddleware != nil {
+		jobID, forward := b.ttsMiddleware.InterceptTTS(update.Type, update.Data, update.Priority, update.AvatarID)
+		if !forward {
+			return jobID, nil
+		}
 	}
 
 	message, err := json.Marshal(update)
 	if err != nil {
 		log.Printf("JSON marshal error: %v", err)
-		return err
+		return "", err
 	}
 	
 	log.Printf("Broadcasting message: %s", string(message))
@@ -93,7 +105,7 @@ func (b *Broadcaster) Broadcast(update Update) error {
 		client <- string(message)
 	}
 	log.Printf("Broadcast completed to %d clients", len(b.clients))
-	return nil
+	return "", nil
 }
 
 func (b *Broadcaster) addClient(client SSEClient) {
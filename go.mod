@@ -8,6 +8,7 @@ require (
 	fyne.io/fyne/v2 v2.5.3
 	github.com/gorilla/websocket v1.5.3
 	go.etcd.io/bbolt v1.3.11
+	golang.org/x/text v0.16.0
 )
 
 require (
@@ -38,6 +39,5 @@ require (
 	golang.org/x/mobile v0.0.0-20231127183840-76ac6878050a // indirect
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/sys v0.20.0 // indirect
-	golang.org/x/text v0.16.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
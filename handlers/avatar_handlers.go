@@ -13,6 +13,7 @@ import (
 
 	"github.com/oristarium/orionchat/avatar" // Update with your actual module name
 	"github.com/oristarium/orionchat/broadcast"
+	"github.com/oristarium/orionchat/tts"
 	"github.com/oristarium/orionchat/types"
 )
 
@@ -471,6 +472,13 @@ func (h *AvatarHandler) handleSetAvatarVoices(w http.ResponseWriter, r *http.Req
 		return
 	}
 
+	for _, voice := range request.Voices {
+		if err := tts.ValidateVoiceChain(voice); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Get existing avatar
 	existingAvatar, err := h.avatarManager.Storage.GetAvatar(id)
 	if err != nil {
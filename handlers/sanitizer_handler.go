@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/oristarium/orionchat/tts"
+)
+
+// SanitizerHandler handles HTTP requests that edit the replacement, slang
+// and blocked word lists of the TTS sanitizer
+type SanitizerHandler struct {
+	rules *tts.SanitizerRules
+}
+
+// NewSanitizerHandler creates a new sanitizer handler
+func NewSanitizerHandler(rules *tts.SanitizerRules) *SanitizerHandler {
+	return &SanitizerHandler{
+		rules: rules,
+	}
+}
+
+// HandleSanitizer handles /api/sanitizer and /api/sanitizer/...
+// GET lists the stored lists,
+// GET|PUT|DELETE {kind}/{lang} reads a list, saves a rule or deletes one,
+// POST {kind}/{lang}/import and GET {kind}/{lang}/export read and write CSV
+func (h *SanitizerHandler) HandleSanitizer(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 5)
+	// parts: ["api", "sanitizer", "{kind}", "{lang}", "import|export"]
+	args := parts[2:]
+
+	switch {
+	case len(args) == 0 && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.rules.Lists())
+	case len(args) == 0:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case len(args) < 2:
+		http.Error(w, "Not found", http.StatusNotFound)
+	default:
+		kind, lang := args[0], args[1]
+		if err := tts.ValidateRuleList(kind, lang); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		switch {
+		case len(args) == 2:
+			h.handleList(w, r, kind, lang)
+		case len(args) == 3 && args[2] == "import":
+			h.handleImport(w, r, kind, lang)
+		case len(args) == 3 && args[2] == "export":
+			h.handleExport(w, r, kind, lang)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+	}
+}
+
+// handleList handles the rules of one list
+func (h *SanitizerHandler) handleList(w http.ResponseWriter, r *http.Request, kind, lang string) {
+	switch r.Method {
+	case http.MethodGet:
+		rules, err := h.rules.List(kind, lang)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rules)
+	case http.MethodPut:
+		var rule tts.SanitizerRule
+		if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		saved, err := h.rules.Save(kind, lang, rule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+	case http.MethodDelete:
+		err := h.rules.Delete(kind, lang, r.URL.Query().Get("match"))
+		if errors.Is(err, tts.ErrRuleNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleImport handles POST {kind}/{lang}/import with a CSV body. With
+// ?replace=true the list is replaced instead of added to.
+func (h *SanitizerHandler) handleImport(w http.ResponseWriter, r *http.Request, kind, lang string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	count, err := h.rules.Import(kind, lang, r.Body, r.URL.Query().Get("replace") == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"imported": count})
+}
+
+// handleExport handles GET {kind}/{lang}/export, the list as a CSV file
+func (h *SanitizerHandler) handleExport(w http.ResponseWriter, r *http.Request, kind, lang string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", kind+"_"+lang+".csv"))
+	if err := h.rules.Export(kind, lang, w); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/oristarium/orionchat/avatar"
+	"github.com/oristarium/orionchat/tts"
+)
+
+// TTSChatterHandler handles HTTP requests that inspect and override which
+// avatar and voice each chatter is heard with
+type TTSChatterHandler struct {
+	chatters      *tts.ChatterMap
+	avatarManager *avatar.Manager
+}
+
+// NewTTSChatterHandler creates a new TTS chatter handler
+func NewTTSChatterHandler(chatters *tts.ChatterMap, avatarManager *avatar.Manager) *TTSChatterHandler {
+	return &TTSChatterHandler{
+		chatters:      chatters,
+		avatarManager: avatarManager,
+	}
+}
+
+// HandleChatters handles /api/tts/chatters and /api/tts/chatters/...
+// GET lists every assignment and DELETE resets them all,
+// GET|PUT|DELETE {platform}/{author_id} reads, overrides or resets one
+func (h *TTSChatterHandler) HandleChatters(w http.ResponseWriter, r *http.Request) {
+	parts := strings.SplitN(strings.Trim(r.URL.Path, "/"), "/", 5)
+	// parts: ["api", "tts", "chatters", "{platform}", "{author_id}"]
+	args := parts[3:]
+
+	switch {
+	case len(args) == 0 && r.Method == http.MethodGet:
+		assignments, err := h.chatters.List()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignments)
+	case len(args) == 0 && r.Method == http.MethodDelete:
+		count, err := h.chatters.Reset()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"deleted": count})
+	case len(args) == 0:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	case len(args) == 2 && args[0] != "" && args[1] != "":
+		h.handleChatter(w, r, args[0], args[1])
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// handleChatter handles the assignment of one chatter
+func (h *TTSChatterHandler) handleChatter(w http.ResponseWriter, r *http.Request, platform, authorID string) {
+	switch r.Method {
+	case http.MethodGet:
+		assignment, ok := h.chatters.Get(platform, authorID)
+		if !ok {
+			http.Error(w, tts.ErrChatterNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(assignment)
+	case http.MethodPut:
+		var assignment tts.ChatterAssignment
+		if err := json.NewDecoder(r.Body).Decode(&assignment); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		assignment.Platform, assignment.AuthorID, assignment.Pinned = platform, authorID, true
+		if previous, ok := h.chatters.Get(platform, authorID); ok && assignment.Name == "" {
+			assignment.Name = previous.Name
+		}
+		if err := h.validateVoice(assignment); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved, err := h.chatters.Save(assignment)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved)
+	case http.MethodDelete:
+		err := h.chatters.Delete(platform, authorID)
+		if errors.Is(err, tts.ErrChatterNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validateVoice checks that the avatar exists and, when a voice is given,
+// that the avatar has it
+func (h *TTSChatterHandler) validateVoice(assignment tts.ChatterAssignment) error {
+	if assignment.AvatarID == "" {
+		return fmt.Errorf("avatar_id is required")
+	}
+	avatar, err := h.avatarManager.Storage.GetAvatar(assignment.AvatarID)
+	if err != nil {
+		return fmt.Errorf("avatar %s: %v", assignment.AvatarID, err)
+	}
+	if assignment.VoiceID == "" && assignment.Provider == "" {
+		return nil
+	}
+	for _, voice := range avatar.TTSVoices {
+		if voice.VoiceID == assignment.VoiceID && voice.Provider == assignment.Provider {
+			return nil
+		}
+	}
+	return fmt.Errorf("avatar %s has no voice %s (%s)", assignment.AvatarID, assignment.VoiceID, assignment.Provider)
+}
@@ -1,12 +1,17 @@
 package handlers
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"sort"
+	"strings"
 
 	"github.com/oristarium/orionchat/tts"
+	"github.com/oristarium/orionchat/types"
 )
 
 // TTSHandler handles HTTP requests for TTS operations
@@ -31,7 +36,11 @@ func (h *TTSHandler) HandleTTS(w http.ResponseWriter, r *http.Request) {
 	var request struct {
 		Text          string `json:"text"`
 		VoiceID       string `json:"voice_id"`
-		VoiceProvider string `json:"voice_provider"`
+		VoiceProvider string           `json:"voice_provider"`
+		Speed         float64          `json:"speed"`
+		Pitch         float64          `json:"pitch"`
+		Gain          float64          `json:"gain"`
+		Fallbacks     []types.TTSVoice `json:"fallbacks"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -41,13 +50,30 @@ func (h *TTSHandler) HandleTTS(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("TTS Request - Text: %q, VoiceID: %q, Provider: %q", request.Text, request.VoiceID, request.VoiceProvider)
 
-	// Get the requested provider
-	provider, err := tts.GetProvider(request.VoiceProvider)
-	if err != nil {
-		log.Printf("Provider error: %v", err)
-		http.Error(w, fmt.Sprintf("TTS provider error: %v", err), http.StatusBadRequest)
+	// Build the fallback chain, starting with the requested voice
+	primary := types.TTSVoice{
+		VoiceID:   request.VoiceID,
+		Provider:  request.VoiceProvider,
+		Speed:     request.Speed,
+		Pitch:     request.Pitch,
+		Gain:      request.Gain,
+		Fallbacks: request.Fallbacks,
+	}
+	if err := tts.ValidateVoiceChain(primary); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	voices := tts.VoiceChain(primary)
+
+	// Make sure every provider in the chain exists. Disabled ones are
+	// skipped when the chain runs.
+	for _, voice := range voices {
+		if _, exists := h.service.Providers().Config(voice.Provider); !exists {
+			log.Printf("Provider error: provider %s not found", voice.Provider)
+			http.Error(w, fmt.Sprintf("TTS provider error: provider %s not found", voice.Provider), http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Split long text into chunks if needed
 	chunks, err := h.service.SplitLongText(request.Text, "")
@@ -64,27 +90,32 @@ func (h *TTSHandler) HandleTTS(w http.ResponseWriter, r *http.Request) {
 
 	log.Printf("Split into %d chunks: %v", len(chunks), chunks)
 
-	// Get audio for all chunks and combine them
-	var combinedAudio string
-	for _, chunk := range chunks {
-		audio, err := h.service.GetAudioBase64WithProvider(chunk, request.VoiceID, provider, false)
-		if err != nil {
-			log.Printf("TTS error for chunk %q: %v", chunk, err)
-			http.Error(w, fmt.Sprintf("TTS error: %v", err), http.StatusInternalServerError)
+	result, err := h.service.SynthesizeWithFallback(r.Context(), chunks, voices)
+	if err != nil {
+		log.Printf("TTS error: %v", err)
+		var formatErr *tts.AudioFormatError
+		if errors.As(err, &formatErr) {
+			http.Error(w, fmt.Sprintf("TTS error: %v", formatErr), http.StatusBadGateway)
 			return
 		}
-		if combinedAudio == "" {
-			combinedAudio = audio
-		} else {
-			combinedAudio += audio
+		var blockedErr *tts.BlockedWordError
+		if errors.As(err, &blockedErr) {
+			http.Error(w, fmt.Sprintf("TTS error: %v", blockedErr), http.StatusBadRequest)
+			return
 		}
-		log.Printf("Successfully processed chunk: %q", chunk)
+		http.Error(w, fmt.Sprintf("TTS error: %v", err), http.StatusInternalServerError)
+		return
 	}
 
-	log.Printf("Successfully combined %d audio chunks", len(chunks))
+	log.Printf("Successfully combined %d audio chunks (voice: %s, provider: %s, duration: %v)",
+		len(chunks), result.Voice.VoiceID, result.Voice.Provider, result.Duration)
 
-	response := map[string]string{
-		"audio": combinedAudio,
+	response := map[string]interface{}{
+		"audio":          base64.StdEncoding.EncodeToString(result.Audio),
+		"mime_type":      result.MIMEType,
+		"duration_ms":    result.Duration.Milliseconds(),
+		"voice_id":       result.Voice.VoiceID,
+		"voice_provider": result.Voice.Provider,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -93,5 +124,379 @@ func (h *TTSHandler) HandleTTS(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error encoding response", http.StatusInternalServerError)
 		return
 	}
-	log.Printf("Successfully sent response with audio length: %d", len(combinedAudio))
-} 
\ No newline at end of file
+	log.Printf("Successfully sent response with audio length: %d", len(result.Audio))
+}
+
+// HandlePreview handles POST /api/tts/preview
+// Synthesizes the voice's sample sentence, or the given text, with a single
+// voice and no fallbacks
+func (h *TTSHandler) HandlePreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		VoiceID       string  `json:"voice_id"`
+		VoiceProvider string  `json:"voice_provider"`
+		Speed         float64 `json:"speed"`
+		Pitch         float64 `json:"pitch"`
+		Gain          float64 `json:"gain"`
+		Text          string  `json:"text"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if request.VoiceID == "" || request.VoiceProvider == "" {
+		http.Error(w, "voice_id and voice_provider are required", http.StatusBadRequest)
+		return
+	}
+	if len(request.Text) > tts.MaxTextLength {
+		http.Error(w, fmt.Sprintf("Preview text is limited to %d characters", tts.MaxTextLength), http.StatusBadRequest)
+		return
+	}
+	config, exists := h.service.Providers().Config(request.VoiceProvider)
+	if !exists {
+		http.Error(w, fmt.Sprintf("Provider %s not found", request.VoiceProvider), http.StatusBadRequest)
+		return
+	}
+	if !config.Enabled {
+		http.Error(w, fmt.Sprintf("Provider %s is disabled", request.VoiceProvider), http.StatusBadRequest)
+		return
+	}
+
+	voice := types.TTSVoice{
+		VoiceID:  request.VoiceID,
+		Provider: request.VoiceProvider,
+		Speed:    request.Speed,
+		Pitch:    request.Pitch,
+		Gain:     request.Gain,
+	}
+	if err := tts.ValidateVoiceChain(voice); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result, text, err := h.service.Preview(r.Context(), voice, request.Text)
+	if err != nil {
+		log.Printf("TTS preview error: %v", err)
+		var blockedErr *tts.BlockedWordError
+		switch {
+		case errors.Is(err, tts.ErrInvalidVoice), errors.As(err, &blockedErr):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, fmt.Sprintf("TTS error: %v", err), http.StatusBadGateway)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"audio":          base64.StdEncoding.EncodeToString(result.Audio),
+		"mime_type":      result.MIMEType,
+		"duration_ms":    result.Duration.Milliseconds(),
+		"text":           text,
+		"voice_id":       result.Voice.VoiceID,
+		"voice_provider": result.Voice.Provider,
+	})
+}
+
+// HandleCache handles /api/tts/cache
+// GET returns cache statistics, DELETE purges every cached clip
+func (h *TTSHandler) HandleCache(w http.ResponseWriter, r *http.Request) {
+	cache := h.service.Cache()
+	if cache == nil {
+		http.Error(w, "Cache not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(cache.Stats())
+	case http.MethodDelete:
+		if err := cache.Purge(); err != nil {
+			log.Printf("Error purging TTS cache: %v", err)
+			http.Error(w, fmt.Sprintf("Failed to purge cache: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleEmoji handles /api/tts/emoji
+// GET returns how emoji are read, PUT changes it
+func (h *TTSHandler) HandleEmoji(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var settings tts.EmojiSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := h.service.SetEmojiSettings(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.service.EmojiSettings())
+}
+
+// HandleVoices handles /api/tts/voices
+// GET lists catalog voices (filters: provider, lang, gender, q, custom),
+// POST adds or replaces a custom entry, DELETE removes one
+func (h *TTSHandler) HandleVoices(w http.ResponseWriter, r *http.Request) {
+	catalog := h.service.Providers().Catalog()
+	if catalog == nil {
+		http.Error(w, "Voice catalog not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		query := r.URL.Query()
+		filter := tts.VoiceFilter{
+			Provider: query.Get("provider"),
+			Language: query.Get("lang"),
+			Gender:   query.Get("gender"),
+			Query:    query.Get("q"),
+		}
+		if custom := query.Get("custom"); custom != "" {
+			value := custom == "true"
+			filter.Custom = &value
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"voices": catalog.List(filter),
+		})
+	case http.MethodPost:
+		var voice tts.CatalogVoice
+		if err := json.NewDecoder(r.Body).Decode(&voice); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, exists := h.service.Providers().Config(voice.Provider); !exists {
+			http.Error(w, fmt.Sprintf("Provider %s not found", voice.Provider), http.StatusBadRequest)
+			return
+		}
+		saved, err := catalog.SaveCustom(voice)
+		if err != nil {
+			log.Printf("Error saving custom voice: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(saved)
+	case http.MethodDelete:
+		query := r.URL.Query()
+		if err := catalog.DeleteCustom(query.Get("provider"), query.Get("voice_id")); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleProviders handles /api/tts/providers
+// GET lists every provider config, POST registers a new provider
+func (h *TTSHandler) HandleProviders(w http.ResponseWriter, r *http.Request) {
+	registry := h.service.Providers()
+
+	switch r.Method {
+	case http.MethodGet:
+		configs := registry.List()
+		for i := range configs {
+			configs[i] = configs[i].Masked()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"providers": configs,
+			"types":     providerTypes(),
+		})
+	case http.MethodPost:
+		var config tts.ProviderConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if _, exists := registry.Config(config.Name); exists {
+			http.Error(w, fmt.Sprintf("Provider %s already exists", config.Name), http.StatusConflict)
+			return
+		}
+		if err := registry.Save(config); err != nil {
+			log.Printf("Error creating provider %s: %v", config.Name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved, _ := registry.Config(config.Name)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(saved.Masked())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleProviderDetail handles /api/tts/providers/{name}
+// GET returns a provider config, PUT replaces it, DELETE removes it
+func (h *TTSHandler) HandleProviderDetail(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(segments) != 4 { // api/tts/providers/{name}
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+	name := segments[3]
+	registry := h.service.Providers()
+
+	existing, exists := registry.Config(name)
+	if !exists {
+		http.Error(w, "Provider not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(existing.Masked())
+	case http.MethodPut:
+		var config tts.ProviderConfig
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		config.Name = name
+		if config.Type == "" {
+			config.Type = existing.Type
+		}
+		if err := registry.Save(config); err != nil {
+			log.Printf("Error updating provider %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		saved, _ := registry.Config(name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(saved.Masked())
+	case http.MethodDelete:
+		if err := registry.Delete(name); err != nil {
+			log.Printf("Error deleting provider %s: %v", name, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleTikTokSessions handles /api/tts/tiktok/sessions
+// GET lists stored session IDs, POST adds one
+func (h *TTSHandler) HandleTikTokSessions(w http.ResponseWriter, r *http.Request) {
+	pool := h.service.Providers().TikTokSessions()
+
+	switch r.Method {
+	case http.MethodGet:
+		sessions := pool.List()
+		for i := range sessions {
+			sessions[i] = sessions[i].Masked()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sessions": sessions,
+		})
+	case http.MethodPost:
+		var request struct {
+			SessionID string `json:"session_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		session, err := pool.Add(request.SessionID)
+		if err != nil {
+			log.Printf("Error adding TikTok session: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(session.Masked())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HandleTikTokSessionDetail handles /api/tts/tiktok/sessions/validate and
+// /api/tts/tiktok/sessions/{key}(/validate)
+// POST .../validate checks every session ID against TikTok, DELETE on a key
+// removes a session ID and POST on its /validate checks only that one
+func (h *TTSHandler) HandleTikTokSessionDetail(w http.ResponseWriter, r *http.Request) {
+	segments := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	pool := h.service.Providers().TikTokSessions()
+
+	switch {
+	case len(segments) == 5 && segments[4] == "validate": // api/tts/tiktok/sessions/validate
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		config, _ := h.service.Providers().Config(tts.ProviderTikTok)
+		checks := tts.ValidateTikTokSessions(r.Context(), pool, config)
+		for i := range checks {
+			checks[i].TikTokSession = checks[i].TikTokSession.Masked()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"sessions": checks,
+		})
+	case len(segments) == 5: // api/tts/tiktok/sessions/{key}
+		if r.Method != http.MethodDelete {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := pool.Remove(segments[4]); err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case len(segments) == 6 && segments[5] == "validate":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if _, exists := pool.Get(segments[4]); !exists {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		config, _ := h.service.Providers().Config(tts.ProviderTikTok)
+		session, err := tts.ValidateTikTokSession(r.Context(), pool, config, segments[4])
+		if err != nil {
+			log.Printf("Error validating TikTok session: %v", err)
+			http.Error(w, fmt.Sprintf("Could not reach TikTok: %v", err), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session.Masked())
+	default:
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+	}
+}
+
+// providerTypes returns the provider types that can be registered
+func providerTypes() []string {
+	names := make([]string, 0, len(tts.ProviderFactory))
+	for name := range tts.ProviderFactory {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
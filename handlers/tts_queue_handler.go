@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/oristarium/orionchat/tts"
+)
+
+// TTSQueueHandler handles HTTP requests that inspect and control the TTS
+// queue
+type TTSQueueHandler struct {
+	middleware *tts.TTSMiddleware
+}
+
+// NewTTSQueueHandler creates a new TTS queue handler
+func NewTTSQueueHandler(middleware *tts.TTSMiddleware) *TTSQueueHandler {
+	return &TTSQueueHandler{
+		middleware: middleware,
+	}
+}
+
+// HandleQueue handles /api/tts/queue and /api/tts/queue/...
+// GET lists the queue, POST skip|pause|resume controls it,
+// GET|PUT playback reads or sets the playback mode,
+// GET|PUT selection reads or sets the avatar selection strategy,
+// GET|PUT moderation reads or sets the per-chatter limits,
+// DELETE {id} removes an item and POST {id}/move reorders one
+func (h *TTSQueueHandler) HandleQueue(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	// parts: ["api", "tts", "queue", ...]
+	args := parts[3:]
+
+	var err error
+	switch {
+	case len(args) == 0 && r.Method == http.MethodGet:
+		// Listing only
+	case len(args) == 1 && args[0] == "skip" && r.Method == http.MethodPost:
+		err = h.middleware.SkipCurrent(r.URL.Query().Get("avatar_id"))
+	case len(args) == 1 && args[0] == "pause" && r.Method == http.MethodPost:
+		h.middleware.SetPaused(true)
+	case len(args) == 1 && args[0] == "resume" && r.Method == http.MethodPost:
+		h.middleware.SetPaused(false)
+	case len(args) == 1 && args[0] == "playback" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.middleware.Playback())
+		return
+	case len(args) == 1 && args[0] == "playback" && r.Method == http.MethodPut:
+		var settings tts.PlaybackSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := h.middleware.SetPlayback(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	case len(args) == 1 && args[0] == "selection" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.middleware.AvatarSelection())
+		return
+	case len(args) == 1 && args[0] == "selection" && r.Method == http.MethodPut:
+		var settings tts.AvatarSelectionSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := h.middleware.SetAvatarSelection(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.middleware.AvatarSelection())
+		return
+	case len(args) == 1 && args[0] == "moderation" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.middleware.Moderation())
+		return
+	case len(args) == 1 && args[0] == "moderation" && r.Method == http.MethodPut:
+		var settings tts.ModerationSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := h.middleware.SetModeration(settings); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.middleware.Moderation())
+		return
+	case len(args) == 1 && r.Method == http.MethodDelete:
+		err = h.middleware.RemoveQueueItem(args[0])
+	case len(args) == 2 && args[1] == "move" && r.Method == http.MethodPost:
+		var request struct {
+			Position int `json:"position"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.Position < 1 {
+			http.Error(w, "Invalid request body, position must be 1 or more", http.StatusBadRequest)
+			return
+		}
+		err = h.middleware.MoveQueueItem(args[0], request.Position)
+	case len(args) <= 2:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	switch {
+	case errors.Is(err, tts.ErrQueueItemNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, tts.ErrNotSpeaking):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.middleware.QueueSnapshot())
+}
+
+// HandleJob handles GET /api/tts/jobs/{id}, the status of a tts update
+func (h *TTSQueueHandler) HandleJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	// parts: ["api", "tts", "jobs", "{id}"]
+	if len(parts) != 4 || parts[3] == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	job, err := h.middleware.Job(parts[3])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
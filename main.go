@@ -34,6 +34,9 @@ type Server struct {
 	config  types.Config
 	fileHandler *handlers.FileHandler
 	ttsHandler *handlers.TTSHandler
+	ttsQueueHandler *handlers.TTSQueueHandler
+	ttsChatterHandler *handlers.TTSChatterHandler
+	sanitizerHandler *handlers.SanitizerHandler
 	avatarManager *avatar.Manager
 	avatarHandler *handlers.AvatarHandler
 	broadcaster *broadcast.Broadcaster
@@ -55,8 +58,36 @@ func NewServer() *Server {
 
 	fileHandler := handlers.NewFileHandler(store)
 
-	ttsService := tts.NewTTSService()
-	ttsMiddleware := tts.NewTTSMiddleware()
+	ttsCache, err := tts.NewAudioCache(tts.CacheDir, tts.DefaultCacheMaxBytes)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	voiceCatalog, err := tts.NewVoiceCatalog(store.GetDB(), tts.VoicesCSVPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ttsProviders, err := tts.NewProviderRegistry(store.GetDB(), voiceCatalog)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sanitizerRules, err := tts.NewSanitizerRules(store.GetDB(), tts.SanitizerDataDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tts.UseSanitizerRules(sanitizerRules)
+
+	ttsService := tts.NewTTSService(ttsProviders, ttsCache)
+	blobDir, err := tts.QueueBlobPath(store.GetDB())
+	if err != nil {
+		log.Fatal(err)
+	}
+	ttsMiddleware, err := tts.NewTTSMiddleware(ttsService, store.GetDB(), blobDir, queueMaxAge(store))
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	server := &Server{
 		config: types.Config{
@@ -70,6 +101,9 @@ func NewServer() *Server {
 		},
 		fileHandler:   fileHandler,
 		ttsHandler:    handlers.NewTTSHandler(ttsService),
+		ttsQueueHandler: handlers.NewTTSQueueHandler(ttsMiddleware),
+		ttsChatterHandler: handlers.NewTTSChatterHandler(ttsMiddleware.Chatters(), avatarManager),
+		sanitizerHandler: handlers.NewSanitizerHandler(sanitizerRules),
 		avatarManager: avatarManager,
 		broadcaster:   broadcast.New(),
 		ttsMiddleware: ttsMiddleware,
@@ -84,9 +118,37 @@ func NewServer() *Server {
 	// Connect the TTS middleware to the broadcaster
 	server.broadcaster.SetTTSMiddleware(server.ttsMiddleware)
 
+	// Push every queue change to SSE clients
+	server.ttsMiddleware.OnQueueChange(func(snapshot tts.TTSQueueSnapshot) {
+		if err := server.broadcaster.Broadcast(broadcast.Update{Type: "tts_queue", Data: snapshot}); err != nil {
+			log.Printf("Error broadcasting TTS queue: %v", err)
+		}
+	})
+	server.ttsMiddleware.OnWatchdog(func(event tts.TTSWatchdogEvent) {
+		if err := server.broadcaster.Broadcast(broadcast.Update{Type: "tts_watchdog", Data: event}); err != nil {
+			log.Printf("Error broadcasting TTS watchdog event: %v", err)
+		}
+	})
+
 	return server
 }
 
+// queueMaxAge returns how old a stored TTS queue item may be and still be
+// restored, as set under tts.QueueMaxAgeKey
+func queueMaxAge(store *storage.BBoltStorage) time.Duration {
+	value, err := store.Get(tts.QueueMaxAgeKey, storage.GeneralBucket)
+	if err != nil || value == "" {
+		return tts.DefaultQueueMaxAge
+	}
+	// Accept the value as plain text or as a JSON string
+	maxAge, err := time.ParseDuration(strings.Trim(strings.TrimSpace(value), `"`))
+	if err != nil || maxAge < 0 {
+		log.Printf("Invalid %s %q, using %v", tts.QueueMaxAgeKey, value, tts.DefaultQueueMaxAge)
+		return tts.DefaultQueueMaxAge
+	}
+	return maxAge
+}
+
 // Start initializes and starts the server
 func (s *Server) Start() error {
 	s.setupRoutes()
@@ -135,6 +197,21 @@ func (s *Server) setupRoutes() {
 	http.HandleFunc("/api/avatar-images/delete", s.avatarHandler.HandleAvatarImageDelete)
 	http.HandleFunc("/api/avatar/upload", s.avatarHandler.HandleAvatarUpload)
 	http.HandleFunc("/tts-service", s.ttsHandler.HandleTTS)
+	http.HandleFunc("/api/tts/cache", s.ttsHandler.HandleCache)
+	http.HandleFunc("/api/tts/emoji", s.ttsHandler.HandleEmoji)
+	http.HandleFunc("/api/tts/voices", s.ttsHandler.HandleVoices)
+	http.HandleFunc("/api/tts/preview", s.ttsHandler.HandlePreview)
+	http.HandleFunc("/api/tts/providers", s.ttsHandler.HandleProviders)
+	http.HandleFunc("/api/tts/providers/", s.ttsHandler.HandleProviderDetail)
+	http.HandleFunc("/api/tts/tiktok/sessions", s.ttsHandler.HandleTikTokSessions)
+	http.HandleFunc("/api/tts/tiktok/sessions/", s.ttsHandler.HandleTikTokSessionDetail)
+	http.HandleFunc("/api/tts/queue", s.ttsQueueHandler.HandleQueue)
+	http.HandleFunc("/api/tts/queue/", s.ttsQueueHandler.HandleQueue)
+	http.HandleFunc("/api/tts/jobs/", s.ttsQueueHandler.HandleJob)
+	http.HandleFunc("/api/tts/chatters", s.ttsChatterHandler.HandleChatters)
+	http.HandleFunc("/api/tts/chatters/", s.ttsChatterHandler.HandleChatters)
+	http.HandleFunc("/api/sanitizer", s.sanitizerHandler.HandleSanitizer)
+	http.HandleFunc("/api/sanitizer/", s.sanitizerHandler.HandleSanitizer)
 	http.HandleFunc("/api/kv/", s.handleKeyValue)
 
 	// Add WebSocket endpoint for TTS
@@ -154,7 +231,7 @@ func (s *Server) setupRoutes() {
 	// Handle TTS audio blobs
 	http.HandleFunc("/tts-blob/", func(w http.ResponseWriter, r *http.Request) {
 		blobName := filepath.Base(r.URL.Path)
-		blobPath := filepath.Join(os.TempDir(), "tts_blobs", blobName)
+		blobPath := filepath.Join(s.ttsMiddleware.BlobDir(), blobName)
 
 		// Check if file exists
 		if _, err := os.Stat(blobPath); os.IsNotExist(err) {
@@ -171,7 +248,7 @@ func (s *Server) setupRoutes() {
 		defer file.Close()
 
 		// Set proper headers
-		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Type", tts.MIMETypeForExt(filepath.Ext(blobName)))
 		w.Header().Set("Accept-Ranges", "bytes")
 		w.Header().Set("Cache-Control", "no-cache")
 		w.Header().Set("Access-Control-Allow-Origin", "*")
@@ -193,11 +270,19 @@ func (s *Server) handleUpdate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := s.broadcaster.Broadcast(update); err != nil {
+	jobID, err := s.broadcaster.Submit(update)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	// tts updates report the job to follow them by
+	if jobID != "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"job_id": jobID})
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 }
 
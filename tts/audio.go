@@ -0,0 +1,92 @@
+package tts
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MIMETypeWAV is the format most self-hosted engines produce
+const MIMETypeWAV = "audio/wav"
+
+// audioExtensions maps the audio formats we know how to handle to a file
+// extension
+var audioExtensions = map[string]string{
+	MIMETypeMP3:  ".mp3",
+	MIMETypeWAV:  ".wav",
+	"audio/ogg":  ".ogg",
+	"audio/opus": ".opus",
+	"audio/flac": ".flac",
+	"audio/aac":  ".aac",
+}
+
+// NormalizeMIMEType strips parameters and maps common aliases, so
+// "audio/x-wav; codecs=1" becomes "audio/wav"
+func NormalizeMIMEType(mimeType string) string {
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(mimeType))
+	}
+	switch mediaType {
+	case "audio/mp3", "audio/mpeg3", "audio/x-mpeg", "audio/x-mp3":
+		return MIMETypeMP3
+	case "audio/x-wav", "audio/wave", "audio/vnd.wave":
+		return MIMETypeWAV
+	case "application/ogg":
+		return "audio/ogg"
+	}
+	return mediaType
+}
+
+// DetectAudioMIME guesses the format of audio from its leading bytes
+func DetectAudioMIME(data []byte) string {
+	if _, err := parseWAV(data); err == nil {
+		return MIMETypeWAV
+	}
+	if _, err := MP3Duration(data); err == nil {
+		return MIMETypeMP3
+	}
+	return NormalizeMIMEType(http.DetectContentType(data))
+}
+
+// AudioFileExt returns the file extension for an audio MIME type
+func AudioFileExt(mimeType string) string {
+	if ext, ok := audioExtensions[NormalizeMIMEType(mimeType)]; ok {
+		return ext
+	}
+	return ".bin"
+}
+
+// MIMETypeForExt returns the audio MIME type for a file extension
+func MIMETypeForExt(ext string) string {
+	for mimeType, e := range audioExtensions {
+		if e == strings.ToLower(ext) {
+			return mimeType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// AudioDuration measures audio of a format we can parse
+func AudioDuration(mimeType string, data []byte) (time.Duration, error) {
+	switch NormalizeMIMEType(mimeType) {
+	case MIMETypeMP3:
+		return MP3Duration(data)
+	case MIMETypeWAV:
+		return WAVDuration(data)
+	}
+	return 0, fmt.Errorf("cannot measure %s audio", mimeType)
+}
+
+// StitchAudio joins several clips of the same format into one
+func StitchAudio(mimeType string, chunks [][]byte) ([]byte, time.Duration, error) {
+	switch NormalizeMIMEType(mimeType) {
+	case MIMETypeMP3:
+		return StitchMP3(chunks)
+	case MIMETypeWAV:
+		return StitchWAV(chunks)
+	}
+	return nil, 0, fmt.Errorf("stitching %s audio is not supported", mimeType)
+}
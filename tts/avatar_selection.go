@@ -0,0 +1,332 @@
+package tts
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Avatar selection strategies
+const (
+	SelectWeighted   = "weighted"    // random, recently used avatars less likely
+	SelectRoundRobin = "round_robin" // each connected avatar in turn
+	SelectLRU        = "lru"         // the avatar that went longest without a message
+	SelectPlatform   = "platform"    // a fixed avatar per chat platform
+	SelectExplicit   = "explicit"    // the avatar named by the update's avatar_id
+)
+
+// avatarSelectionKey is where AvatarSelectionSettings are kept in
+// QueueSettingsBucket
+const avatarSelectionKey = "avatar_selection"
+
+// AvatarSelectionSettings choose which avatar speaks a message. The platform
+// and explicit strategies only place the messages they have an avatar for;
+// the rest are placed by Fallback, which is weighted, round_robin or lru.
+type AvatarSelectionSettings struct {
+	Strategy  string            `json:"strategy"`
+	Fallback  string            `json:"fallback"`
+	Recent    int               `json:"recent"`              // weighted: recently used avatars picked less often
+	Platforms map[string]string `json:"platforms,omitempty"` // platform: avatar ID by platform
+}
+
+// DefaultAvatarSelection keeps to weighted random selection
+var DefaultAvatarSelection = AvatarSelectionSettings{
+	Strategy: SelectWeighted,
+	Fallback: SelectWeighted,
+	Recent:   DefaultRecentAvatars,
+}
+
+// Validate checks the strategies and their options
+func (s AvatarSelectionSettings) Validate() error {
+	switch s.Strategy {
+	case SelectWeighted, SelectRoundRobin, SelectLRU, SelectPlatform, SelectExplicit:
+	default:
+		return fmt.Errorf("unknown strategy %q (use %s, %s, %s, %s or %s)", s.Strategy,
+			SelectWeighted, SelectRoundRobin, SelectLRU, SelectPlatform, SelectExplicit)
+	}
+	switch s.Fallback {
+	case SelectWeighted, SelectRoundRobin, SelectLRU:
+	default:
+		return fmt.Errorf("unknown fallback %q (use %s, %s or %s)", s.Fallback,
+			SelectWeighted, SelectRoundRobin, SelectLRU)
+	}
+	if s.Recent < 0 || s.Recent > MaxRecentAvatars {
+		return fmt.Errorf("recent must be between 0 and %d", MaxRecentAvatars)
+	}
+	for platform, avatarID := range s.Platforms {
+		if platform == "" || avatarID == "" {
+			return fmt.Errorf("platforms needs a platform and avatar ID in every entry")
+		}
+	}
+	return nil
+}
+
+// AvatarRequest is what a selector knows about a message
+type AvatarRequest struct {
+	Data      map[string]interface{} // the chat message
+	AvatarID  string                 // the update's avatar_id, if any
+	Connected []string               // connected avatar IDs, sorted
+}
+
+// AvatarSelector picks which connected avatar speaks a message. Select
+// returns "" when it has no avatar for the message. Selectors are called
+// from many goroutines at once.
+type AvatarSelector interface {
+	Select(req AvatarRequest) string
+}
+
+// avatarHistory remembers the order avatars were given messages in
+type avatarHistory struct {
+	mu    sync.Mutex
+	count uint64
+	used  map[string]uint64 // avatar ID to when it was last given one
+}
+
+func newAvatarHistory() *avatarHistory {
+	return &avatarHistory{used: make(map[string]uint64)}
+}
+
+// record notes that avatarID was given a message
+func (h *avatarHistory) record(avatarID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.count++
+	h.used[avatarID] = h.count
+}
+
+// recent returns up to n avatars, most recently used first
+func (h *avatarHistory) recent(n int) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ids := make([]string, 0, len(h.used))
+	for id := range h.used {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return h.used[ids[i]] > h.used[ids[j]] })
+	if len(ids) > n {
+		ids = ids[:n]
+	}
+	return ids
+}
+
+// lastUsed returns when avatarID was last given a message, 0 if never
+func (h *avatarHistory) lastUsed(avatarID string) uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.used[avatarID]
+}
+
+// weightedSelector picks at random, giving the recent most recently used
+// avatars less weight the more recently they were used
+type weightedSelector struct {
+	history *avatarHistory
+	recent  int
+}
+
+func (s weightedSelector) Select(req AvatarRequest) string {
+	if len(req.Connected) == 1 {
+		return req.Connected[0]
+	}
+
+	weights := make(map[string]int, len(req.Connected))
+	for _, avatarID := range req.Connected {
+		weights[avatarID] = 100 // Base weight
+	}
+	recent := s.history.recent(s.recent)
+	for i, avatarID := range recent {
+		if _, ok := weights[avatarID]; ok {
+			// More recent avatars get bigger weight reduction
+			weights[avatarID] = max(10, weights[avatarID]-30*(len(recent)-i)) // Minimum weight of 10
+		}
+	}
+
+	total := 0
+	for _, weight := range weights {
+		total += weight
+	}
+	r := rand.Intn(total)
+	for _, avatarID := range req.Connected {
+		r -= weights[avatarID]
+		if r < 0 {
+			return avatarID
+		}
+	}
+	return req.Connected[0]
+}
+
+// roundRobinSelector picks the connected avatar after the last one used
+type roundRobinSelector struct {
+	history *avatarHistory
+}
+
+func (s roundRobinSelector) Select(req AvatarRequest) string {
+	last := ""
+	if recent := s.history.recent(1); len(recent) > 0 {
+		last = recent[0]
+	}
+	// The last avatar may have left, so continue from where it would be
+	i := sort.SearchStrings(req.Connected, last)
+	if i < len(req.Connected) && req.Connected[i] == last {
+		i++
+	}
+	return req.Connected[i%len(req.Connected)]
+}
+
+// lruSelector picks the connected avatar that went longest without a
+// message, the first by ID among those never used
+type lruSelector struct {
+	history *avatarHistory
+}
+
+func (s lruSelector) Select(req AvatarRequest) string {
+	chosen := req.Connected[0]
+	for _, avatarID := range req.Connected[1:] {
+		if s.history.lastUsed(avatarID) < s.history.lastUsed(chosen) {
+			chosen = avatarID
+		}
+	}
+	return chosen
+}
+
+// platformSelector picks the avatar configured for the message's platform
+type platformSelector struct {
+	platforms map[string]string
+}
+
+func (s platformSelector) Select(req AvatarRequest) string {
+	platform, _ := ChatterOf(req.Data)
+	return connectedAvatar(s.platforms[platform], req.Connected)
+}
+
+// explicitSelector picks the avatar the update names
+type explicitSelector struct{}
+
+func (explicitSelector) Select(req AvatarRequest) string {
+	return connectedAvatar(req.AvatarID, req.Connected)
+}
+
+// connectedAvatar returns avatarID if it is connected, "" otherwise
+func connectedAvatar(avatarID string, connected []string) string {
+	i := sort.SearchStrings(connected, avatarID)
+	if avatarID != "" && i < len(connected) && connected[i] == avatarID {
+		return avatarID
+	}
+	return ""
+}
+
+// avatarSelection is the active strategy. target, if set, places the
+// messages it has an avatar for; spread places everything else.
+type avatarSelection struct {
+	settings AvatarSelectionSettings
+	target   AvatarSelector
+	spread   AvatarSelector
+}
+
+func newAvatarSelection(settings AvatarSelectionSettings, history *avatarHistory) avatarSelection {
+	spreadBy := settings.Strategy
+	selection := avatarSelection{settings: settings}
+	switch settings.Strategy {
+	case SelectPlatform:
+		selection.target = platformSelector{platforms: settings.Platforms}
+		spreadBy = settings.Fallback
+	case SelectExplicit:
+		selection.target = explicitSelector{}
+		spreadBy = settings.Fallback
+	}
+	switch spreadBy {
+	case SelectRoundRobin:
+		selection.spread = roundRobinSelector{history: history}
+	case SelectLRU:
+		selection.spread = lruSelector{history: history}
+	default:
+		selection.spread = weightedSelector{history: history, recent: settings.Recent}
+	}
+	return selection
+}
+
+// selectAvatar picks the avatar that speaks a message: the one the strategy
+// targets, else the chatter's own avatar, else one spread by the strategy.
+// It returns "" when no avatar is connected.
+func (tm *TTSMiddleware) selectAvatar(data map[string]interface{}, avatarID string, connected []string) string {
+	if len(connected) == 0 {
+		return ""
+	}
+	sorted := append([]string(nil), connected...)
+	sort.Strings(sorted)
+	req := AvatarRequest{Data: data, AvatarID: avatarID, Connected: sorted}
+
+	// Selecting and recording together keeps concurrent messages from
+	// being given the same avatar by round_robin and lru
+	tm.selectMux.Lock()
+	defer tm.selectMux.Unlock()
+
+	chosen, by := "", tm.selection.settings.Strategy
+	if tm.selection.target != nil {
+		chosen = tm.selection.target.Select(req)
+	}
+	if chosen == "" {
+		if id, ok := tm.chatterAvatar(data, sorted); ok {
+			chosen, by = id, "chatter"
+		}
+	}
+	if chosen == "" {
+		chosen = tm.selection.spread.Select(req)
+		if tm.selection.target != nil {
+			by = tm.selection.settings.Fallback
+		}
+	}
+	tm.avatarHistory.record(chosen)
+	log.Printf("Queue: Selected avatar %s by %s from %d connected avatars", chosen, by, len(sorted))
+	return chosen
+}
+
+// AvatarSelection returns the current avatar selection settings
+func (tm *TTSMiddleware) AvatarSelection() AvatarSelectionSettings {
+	tm.selectMux.Lock()
+	defer tm.selectMux.Unlock()
+	return tm.selection.settings
+}
+
+// SetAvatarSelection validates, stores and applies avatar selection
+// settings. Messages already queued keep their avatar. An empty fallback
+// means weighted.
+func (tm *TTSMiddleware) SetAvatarSelection(settings AvatarSelectionSettings) error {
+	if settings.Fallback == "" {
+		settings.Fallback = SelectWeighted
+	}
+	settings.Platforms = maps.Clone(settings.Platforms)
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if err := tm.storeQueueSetting(avatarSelectionKey, settings); err != nil {
+		return err
+	}
+
+	tm.selectMux.Lock()
+	tm.selection = newAvatarSelection(settings, tm.avatarHistory)
+	tm.selectMux.Unlock()
+
+	log.Printf("Queue: Avatar selection %s (fallback %s)", settings.Strategy, settings.Fallback)
+	return nil
+}
+
+// loadAvatarSelection reads the stored avatar selection settings, keeping
+// the defaults when none are stored
+func (tm *TTSMiddleware) loadAvatarSelection() error {
+	tm.avatarHistory = newAvatarHistory()
+	tm.selection = newAvatarSelection(DefaultAvatarSelection, tm.avatarHistory)
+	var settings AvatarSelectionSettings
+	found, err := tm.loadQueueSetting(avatarSelectionKey, &settings)
+	if err != nil || !found {
+		return err
+	}
+	if err := settings.Validate(); err != nil {
+		log.Printf("Queue: Ignoring invalid stored avatar selection - %v", err)
+		return nil
+	}
+	tm.selection = newAvatarSelection(settings, tm.avatarHistory)
+	return nil
+}
@@ -0,0 +1,150 @@
+package tts
+
+import (
+	"strings"
+	"sync"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func newTestSelection(t *testing.T, settings AvatarSelectionSettings) *TTSMiddleware {
+	t.Helper()
+	tm := newTestQueue(t)
+	if err := tm.loadAvatarSelection(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.SetAvatarSelection(settings); err != nil {
+		t.Fatal(err)
+	}
+	return tm
+}
+
+func TestAvatarSelectionStrategies(t *testing.T) {
+	connected := []string{"c", "a", "b"}
+	pick := func(tm *TTSMiddleware, data map[string]interface{}, avatarID string, n int) string {
+		var picks []string
+		for i := 0; i < n; i++ {
+			picks = append(picks, tm.selectAvatar(data, avatarID, connected))
+		}
+		return strings.Join(picks, ",")
+	}
+
+	tm := newTestSelection(t, AvatarSelectionSettings{Strategy: SelectRoundRobin})
+	if got := pick(tm, nil, "", 5); got != "a,b,c,a,b" {
+		t.Errorf("round_robin picked %s", got)
+	}
+	// An avatar leaving does not restart the round
+	if got := tm.selectAvatar(nil, "", []string{"a", "b"}); got != "a" {
+		t.Errorf("round_robin after c left picked %s, want a", got)
+	}
+
+	tm = newTestSelection(t, AvatarSelectionSettings{Strategy: SelectLRU})
+	tm.avatarHistory.record("a")
+	tm.avatarHistory.record("c")
+	if got := pick(tm, nil, "", 4); got != "b,a,c,b" {
+		t.Errorf("lru picked %s", got)
+	}
+
+	tm = newTestSelection(t, AvatarSelectionSettings{Strategy: SelectExplicit, Fallback: SelectRoundRobin})
+	if got := pick(tm, nil, "c", 2); got != "c,c" {
+		t.Errorf("explicit picked %s", got)
+	}
+	if got := pick(tm, nil, "gone", 2); got != "a,b" {
+		t.Errorf("explicit fallback for a missing avatar picked %s", got)
+	}
+
+	tm = newTestSelection(t, AvatarSelectionSettings{
+		Strategy:  SelectPlatform,
+		Fallback:  SelectLRU,
+		Platforms: map[string]string{"youtube": "b", "tiktok": "gone"},
+	})
+	if got := pick(tm, chatMessage("youtube-1", ""), "", 2); got != "b,b" {
+		t.Errorf("platform picked %s", got)
+	}
+	if got := pick(tm, chatMessage("tiktok-1", ""), "", 2); got != "a,c" {
+		t.Errorf("platform fallback for a missing avatar picked %s", got)
+	}
+
+	tm = newTestSelection(t, AvatarSelectionSettings{Strategy: SelectWeighted, Recent: 3})
+	for i := 0; i < 50; i++ {
+		if got := tm.selectAvatar(nil, "", connected); got != "a" && got != "b" && got != "c" {
+			t.Fatalf("weighted picked %q", got)
+		}
+	}
+	if got := tm.selectAvatar(nil, "", nil); got != "" {
+		t.Errorf("picked %q with no avatar connected", got)
+	}
+}
+
+func TestAvatarSelectionConcurrent(t *testing.T) {
+	tm := newTestSelection(t, AvatarSelectionSettings{Strategy: SelectRoundRobin})
+	connected := []string{"a", "b", "c", "d"}
+
+	var mu sync.Mutex
+	counts := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				avatarID := tm.selectAvatar(nil, "", connected)
+				mu.Lock()
+				counts[avatarID]++
+				mu.Unlock()
+			}
+		}()
+	}
+	// Changing the strategy while messages are placed is safe too
+	tm.SetAvatarSelection(AvatarSelectionSettings{Strategy: SelectRoundRobin})
+	wg.Wait()
+
+	for _, avatarID := range connected {
+		if counts[avatarID] != 100 {
+			t.Errorf("round_robin gave %v, want 100 each", counts)
+			break
+		}
+	}
+}
+
+func TestAvatarSelectionValidateAndPersist(t *testing.T) {
+	invalid := []AvatarSelectionSettings{
+		{Strategy: "sticky", Fallback: SelectWeighted},
+		{Strategy: SelectPlatform, Fallback: SelectExplicit},
+		{Strategy: SelectWeighted, Fallback: SelectWeighted, Recent: MaxRecentAvatars + 1},
+		{Strategy: SelectPlatform, Fallback: SelectWeighted, Platforms: map[string]string{"youtube": ""}},
+	}
+	for _, settings := range invalid {
+		if err := settings.Validate(); err == nil {
+			t.Errorf("%+v was accepted", settings)
+		}
+	}
+
+	db, path := openTestDB(t)
+	tm, err := NewTTSMiddleware(nil, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := tm.AvatarSelection(); got.Strategy != DefaultAvatarSelection.Strategy {
+		t.Errorf("default strategy = %s", got.Strategy)
+	}
+	settings := AvatarSelectionSettings{Strategy: SelectPlatform, Platforms: map[string]string{"twitch": "a"}}
+	if err := tm.SetAvatarSelection(settings); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if db, err = bbolt.Open(path, 0600, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	tm, err = NewTTSMiddleware(nil, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := tm.AvatarSelection()
+	if got.Strategy != SelectPlatform || got.Fallback != SelectWeighted || got.Platforms["twitch"] != "a" {
+		t.Errorf("restored selection = %+v", got)
+	}
+}
@@ -0,0 +1,316 @@
+package tts
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Ways a blocked word is matched, see SanitizerRule.Mode
+const (
+	BlockWholeWord = "word"      // only as a word of its own, so "tai" does not block "pantai"
+	BlockSubstring = "substring" // anywhere, also inside other words
+)
+
+var blockModes = []string{BlockWholeWord, BlockSubstring}
+
+// confusables maps letters of other scripts to the Latin letters they look
+// like, such as the Cyrillic "а" of "аnjing"
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'һ': 'h', 'н': 'h', 'і': 'i', 'ї': 'i', 'ј': 'j',
+	'к': 'k', 'ӏ': 'l', 'м': 'm', 'п': 'n', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'г': 'r', 'ѕ': 's',
+	'т': 't', 'у': 'y', 'ү': 'y', 'ԝ': 'w', 'х': 'x', 'ԁ': 'd', 'з': '3',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'ω': 'w', 'γ': 'y',
+	// Latin letters without a decomposition
+	'ı': 'i', 'ȷ': 'j', 'ł': 'l', 'ø': 'o', 'đ': 'd', 'ħ': 'h', 'ɡ': 'g', 'ɑ': 'a',
+}
+
+// leetspeak maps digits and symbols written for letters, as in "b4nc1", to
+// the letters. They are only read as letters in words with other letters,
+// so "2024" stays a number.
+var leetspeak = map[rune]rune{
+	'0': 'o', '1': 'i', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b', '9': 'g',
+	'@': 'a', '!': 'i', '$': 's', '+': 't', '|': 'l',
+}
+
+// spacedLetterRun is how many single letters separated by spaces or
+// punctuation, as in "b a n c i", are joined into a word
+const spacedLetterRun = 3
+
+// foldRune returns the lowercase Latin letter r looks like, without
+// diacritics, or r itself
+func foldRune(r rune) rune {
+	r = unicode.ToLower(r)
+	if r < utf8.RuneSelf {
+		return r
+	}
+	if folded, ok := confusables[r]; ok {
+		return folded
+	}
+	if decomposed := []rune(norm.NFD.String(string(r))); len(decomposed) > 1 {
+		if base := decomposed[0]; base != r {
+			return foldRune(base)
+		}
+	}
+	return r
+}
+
+// blockToken is a word of text being checked for blocked words
+type blockToken struct {
+	runes []rune
+	glued bool // separated from the previous word by punctuation only
+}
+
+// isBlockRune reports letters and digits, and the leetspeak symbols that
+// come before them, so "b@nci" is a word but "banci!" ends in punctuation
+func isBlockRune(runes []rune, i int) bool {
+	for ; i < len(runes); i++ {
+		r := runes[i]
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return true
+		}
+		if _, leet := leetspeak[r]; !leet {
+			return false
+		}
+	}
+	return false
+}
+
+// blockTokens splits text into words after NFKC normalization and folding
+// look-alike letters. Spaced out letters are joined, and leetspeak is read
+// as letters.
+func blockTokens(text string) []blockToken {
+	var runes []rune
+	for _, r := range norm.NFKC.String(text) {
+		// Invisible characters, such as zero width spaces, and leftover
+		// marks would split words
+		if unicode.In(r, unicode.Cf, unicode.Mn) {
+			continue
+		}
+		runes = append(runes, foldRune(r))
+	}
+
+	var tokens []blockToken
+	var current []rune
+	glued, spaced := false, false
+	for i, r := range runes {
+		if isBlockRune(runes, i) {
+			if current == nil {
+				glued = len(tokens) > 0 && !spaced
+			}
+			current = append(current, r)
+			continue
+		}
+		if current != nil {
+			tokens = append(tokens, blockToken{runes: current, glued: glued})
+			current, spaced = nil, false
+		}
+		if unicode.IsSpace(r) {
+			spaced = true
+		}
+	}
+	if current != nil {
+		tokens = append(tokens, blockToken{runes: current, glued: glued})
+	}
+
+	// Join runs of single letters
+	var joined []blockToken
+	for i := 0; i < len(tokens); {
+		end := i
+		for end < len(tokens) && len(tokens[end].runes) == 1 {
+			end++
+		}
+		if end-i >= spacedLetterRun {
+			word := blockToken{glued: tokens[i].glued}
+			for _, token := range tokens[i:end] {
+				word.runes = append(word.runes, token.runes...)
+			}
+			joined = append(joined, word)
+			i = end
+			continue
+		}
+		joined = append(joined, tokens[i])
+		i++
+	}
+
+	for _, token := range joined {
+		if !strings.ContainsFunc(string(token.runes), unicode.IsLetter) {
+			continue
+		}
+		for i, r := range token.runes {
+			if letter, ok := leetspeak[r]; ok {
+				token.runes[i] = letter
+			}
+		}
+	}
+	return joined
+}
+
+// collapseRepeats drops letters repeating the one before, so "anjiiing"
+// reads as "anjing"
+func collapseRepeats(text string) string {
+	var b strings.Builder
+	var last rune = -1
+	for _, r := range text {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+	return b.String()
+}
+
+// blockedForm normalizes text for matching blocked words: its words
+// separated by spaces, repeated letters collapsed. With glued set, words
+// joined by punctuation, as in "anj-ing", follow joined, after a NUL byte
+// no blocked word spans.
+func blockedForm(text string, glued bool) string {
+	tokens := blockTokens(text)
+	words := make([]string, len(tokens))
+	for i, token := range tokens {
+		words[i] = string(token.runes)
+	}
+	form := strings.Join(words, " ")
+
+	if glued {
+		for start := 0; start < len(tokens); {
+			end := start + 1
+			for end < len(tokens) && tokens[end].glued {
+				end++
+			}
+			if end-start > 1 {
+				form += "\x00" + strings.Join(words[start:end], "")
+			}
+			// Pairs too, so one word glued to the next is not lost in a
+			// longer run
+			for i := start; end-start > 2 && i+1 < end; i++ {
+				form += "\x00" + words[i] + words[i+1]
+			}
+			start = end
+		}
+	}
+	return collapseRepeats(form)
+}
+
+// blockMatcher finds blocked words in text in a single pass with an
+// Aho-Corasick automaton over their normalized forms
+type blockMatcher struct {
+	nodes    []blockNode
+	rules    []SanitizerRule
+	patterns []string
+}
+
+type blockNode struct {
+	next map[byte]int
+	fail int
+	out  []int // patterns ending here, including those of fail links
+}
+
+// newBlockMatcher builds a matcher for the rules of lists, nil when there
+// are none
+func newBlockMatcher(lists ...map[string]SanitizerRule) *blockMatcher {
+	m := &blockMatcher{nodes: []blockNode{{next: make(map[byte]int)}}}
+	for _, list := range lists {
+		for _, rule := range list {
+			pattern := blockedForm(rule.Match, false)
+			if pattern == "" {
+				continue
+			}
+			node := 0
+			for i := 0; i < len(pattern); i++ {
+				child, ok := m.nodes[node].next[pattern[i]]
+				if !ok {
+					child = len(m.nodes)
+					m.nodes = append(m.nodes, blockNode{next: make(map[byte]int)})
+					m.nodes[node].next[pattern[i]] = child
+				}
+				node = child
+			}
+			m.nodes[node].out = append(m.nodes[node].out, len(m.rules))
+			m.rules = append(m.rules, rule)
+			m.patterns = append(m.patterns, pattern)
+		}
+	}
+	if len(m.rules) == 0 {
+		return nil
+	}
+
+	// Fail links, breadth first so shorter suffixes are linked first
+	queue := []int{0}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for c, child := range m.nodes[node].next {
+			queue = append(queue, child)
+			if node == 0 {
+				continue
+			}
+			fail := m.nodes[node].fail
+			for {
+				if next, ok := m.nodes[fail].next[c]; ok {
+					m.nodes[child].fail = next
+					break
+				}
+				if fail == 0 {
+					break
+				}
+				fail = m.nodes[fail].fail
+			}
+			m.nodes[child].out = append(m.nodes[child].out, m.nodes[m.nodes[child].fail].out...)
+		}
+	}
+	return m
+}
+
+// isWordBoundary reports whether position i of a blocked form is outside a
+// word
+func isWordBoundary(form string, i int) bool {
+	return i < 0 || i >= len(form) || form[i] == ' ' || form[i] == 0
+}
+
+// find returns the first rule matching in a blocked form
+func (m *blockMatcher) find(form string) (SanitizerRule, bool) {
+	node := 0
+	for i := 0; i < len(form); i++ {
+		for {
+			if next, ok := m.nodes[node].next[form[i]]; ok {
+				node = next
+				break
+			}
+			if node == 0 {
+				break
+			}
+			node = m.nodes[node].fail
+		}
+		for _, p := range m.nodes[node].out {
+			start := i + 1 - len(m.patterns[p])
+			if m.rules[p].Mode == BlockSubstring || (isWordBoundary(form, start-1) && isWordBoundary(form, i+1)) {
+				return m.rules[p], true
+			}
+		}
+	}
+	return SanitizerRule{}, false
+}
+
+// blocker returns the matcher for the blocked words of lang and those for
+// every language, building it on first use. It is nil when there are none.
+func (set *ruleSet) blocker(lang string) *blockMatcher {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	m, built := set.blockers[lang]
+	if !built {
+		all := set.lists.list(RuleBlocked, RulesAllLanguages)
+		if lang == RulesAllLanguages {
+			m = newBlockMatcher(all)
+		} else {
+			m = newBlockMatcher(set.lists.list(RuleBlocked, lang), all)
+		}
+		set.blockers[lang] = m
+	}
+	return m
+}
@@ -0,0 +1,96 @@
+package tts
+
+import "testing"
+
+func TestBlockedForm(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"B4NC1", "banci"},
+		{"b a n c i", "banci"},
+		{"b.a.n.c.i", "banci"},
+		{"аnjing", "anjing"},           // Cyrillic а
+		{"ａｎｊｉｎｇ", "anjing"},           // fullwidth, by NFKC
+		{"anj\u200bing", "anjing"},     // zero width space
+		{"ànjíng", "anjing"},           // diacritics
+		{"anjiiiing!!", "anjing"},      // repeats, trailing punctuation
+		{"b@nci $etan", "banci setan"}, // leetspeak symbols
+		{"rp 2024 ok", "rp 2024 ok"},   // numbers stay numbers
+		{"anj-ing", "anj ing\x00anjing"},
+	}
+	for _, tt := range tests {
+		if got := blockedForm(tt.text, true); got != tt.want {
+			t.Errorf("blockedForm(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestContainsBlockedWords(t *testing.T) {
+	s := NewTextSanitizer()
+	s.shippedOnce.Do(func() {})
+	s.shipped = newRuleSet(ruleLists{RuleBlocked: {
+		LangIndonesian: {
+			"banci":       {Match: "banci", Mode: BlockWholeWord},
+			"tai":         {Match: "tai", Mode: BlockWholeWord},
+			"ayam kampus": {Match: "ayam kampus", Mode: BlockWholeWord},
+			"kontol":      {Match: "kontol", Mode: BlockSubstring},
+		},
+		RulesAllLanguages: {
+			"dweeb": {Match: "dweeb", Mode: BlockWholeWord},
+		},
+	}})
+
+	tests := []struct {
+		voiceID, text, want string
+	}{
+		{"id_male_darma", "dasar b4nc1", "banci"},
+		{"id_male_darma", "dasar b a n c i!", "banci"},
+		{"id_male_darma", "dasar bаnci", "banci"}, // Cyrillic а
+		{"id_male_darma", "BANCIIII", "banci"},
+		{"id_male_darma", "ban.ci", "banci"},
+		{"id_male_darma", "ayam  kampus", "ayam kampus"},
+		{"id_male_darma", "dasarkontolbesar", "kontol"},
+		{"id_male_darma", "what a dweeb", "dweeb"},
+		// Whole words do not match inside others
+		{"id_male_darma", "ke pantai yuk", ""},
+		{"id_male_darma", "pertaian", ""},
+		{"id_male_darma", "halo semua", ""},
+		// Other languages only get the lists for every language
+		{"en_us_001", "banci", ""},
+		{"en_us_001", "d w e e b", "dweeb"},
+		{"", "dweeb", "dweeb"},
+	}
+	for _, tt := range tests {
+		blocked, word := s.ContainsBlockedWords(tt.text, tt.voiceID)
+		if blocked != (tt.want != "") || word != tt.want {
+			t.Errorf("ContainsBlockedWords(%q, %q) = %v, %q, want %q", tt.text, tt.voiceID, blocked, word, tt.want)
+		}
+	}
+}
+
+func TestBlockMatcherOverlaps(t *testing.T) {
+	// Patterns that are suffixes of each other need the fail links
+	m := newBlockMatcher(map[string]SanitizerRule{
+		"he":    {Match: "he", Mode: BlockSubstring},
+		"she":   {Match: "she", Mode: BlockWholeWord},
+		"hers":  {Match: "hers", Mode: BlockWholeWord},
+		"ushes": {Match: "ushes", Mode: BlockWholeWord},
+	})
+	tests := []struct {
+		form, want string
+	}{
+		{"ushers", "he"},
+		{"a she", "she"},
+		{"hers", "he"},
+		{"xyz", ""},
+	}
+	for _, tt := range tests {
+		rule, _ := m.find(tt.form)
+		if rule.Match != tt.want {
+			t.Errorf("find(%q) = %q, want %q", tt.form, rule.Match, tt.want)
+		}
+	}
+	if newBlockMatcher(map[string]SanitizerRule{}) != nil {
+		t.Error("matcher built without patterns")
+	}
+}
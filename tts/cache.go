@@ -0,0 +1,318 @@
+package tts
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AudioCache is a content-addressed on-disk cache for synthesized audio.
+// Entries keep the extension of their audio format, and are evicted
+// least-recently-used first once the size cap is reached.
+type AudioCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // front is most recently used
+	size    int64
+	hits    uint64
+	misses  uint64
+}
+
+// cacheEntry tracks a single cached file
+type cacheEntry struct {
+	key  string
+	ext  string // file extension of the audio format
+	size int64
+}
+
+// CacheStats reports the current state of the cache
+type CacheStats struct {
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"max_bytes"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+// NewAudioCache creates a cache in dir, picking up any files left by a
+// previous run in their last-used order
+func NewAudioCache(dir string, maxBytes int64) (*AudioCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create cache dir: %w", err)
+	}
+
+	c := &AudioCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read cache dir: %w", err)
+	}
+
+	type existing struct {
+		key     string
+		ext     string
+		size    int64
+		modTime time.Time
+	}
+	var found []existing
+	for _, file := range files {
+		ext := filepath.Ext(file.Name())
+		if !file.IsDir() && ext == ".tmp" {
+			// Left behind by a write that never completed
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		if file.IsDir() || !isCacheExt(ext) {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			continue
+		}
+		if info.Size() == 0 {
+			os.Remove(filepath.Join(dir, file.Name()))
+			continue
+		}
+		found = append(found, existing{
+			key:     strings.TrimSuffix(file.Name(), ext),
+			ext:     ext,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+	}
+
+	// Oldest first so the most recently used ends up at the front
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].modTime.Before(found[j].modTime)
+	})
+	for _, f := range found {
+		if elem, ok := c.entries[f.key]; ok {
+			// The same audio in an older format
+			c.removeLocked(elem)
+		}
+		c.entries[f.key] = c.order.PushFront(&cacheEntry{key: f.key, ext: f.ext, size: f.size})
+		c.size += f.size
+	}
+
+	c.mu.Lock()
+	c.evictLocked()
+	c.mu.Unlock()
+
+	log.Printf("TTS cache: Loaded %d entries (%d bytes) from %s", len(c.entries), c.size, dir)
+	return c, nil
+}
+
+// isCacheExt reports extensions of the audio formats entries are stored in
+func isCacheExt(ext string) bool {
+	for _, e := range audioExtensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// CacheKey derives the cache key for a synthesis request. config is the
+// fingerprint of the provider's config, see ProviderConfig.Fingerprint, so
+// editing a provider does not keep serving audio made before.
+func CacheKey(provider, config, voiceID, text string, options map[string]interface{}) string {
+	// json.Marshal sorts map keys, so equal options always hash the same
+	opts, _ := json.Marshal(options)
+
+	h := sha256.New()
+	for _, part := range []string{provider, config, voiceID, text, string(opts)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Get returns the cached audio for key and its MIME type, if present
+func (c *AudioCache) Get(key string) ([]byte, string, bool) {
+	// Look up and bump recency under the lock, but read from disk outside it
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		c.mu.Unlock()
+		return nil, "", false
+	}
+	c.order.MoveToFront(elem)
+	ext := elem.Value.(*cacheEntry).ext
+	c.mu.Unlock()
+
+	path := c.path(key, ext)
+	mimeType := MIMETypeForExt(ext)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = checkCachedAudio(mimeType, data)
+	}
+	if err != nil {
+		log.Printf("TTS cache: Dropping unreadable entry %s - %v", key, err)
+		c.mu.Lock()
+		if current, ok := c.entries[key]; ok && current == elem {
+			c.removeLocked(elem)
+		}
+		c.misses++
+		c.mu.Unlock()
+		return nil, "", false
+	}
+
+	c.mu.Lock()
+	c.hits++
+	c.mu.Unlock()
+
+	// Persist recency so LRU order survives a restart
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		log.Printf("TTS cache: Failed to update access time of %s - %v", key, err)
+	}
+
+	return data, mimeType, true
+}
+
+// checkCachedAudio rejects empty entries, and ones of a format we can parse
+// that no longer parse, such as a file cut short
+func checkCachedAudio(mimeType string, data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("empty file")
+	}
+	switch mimeType {
+	case MIMETypeMP3, MIMETypeWAV:
+		_, err := AudioDuration(mimeType, data)
+		return err
+	}
+	return nil
+}
+
+// Put stores audio of a MIME type under key, evicting old entries if over
+// the size cap
+func (c *AudioCache) Put(key string, data []byte, mimeType string) error {
+	if int64(len(data)) > c.maxBytes {
+		return fmt.Errorf("audio (%d bytes) exceeds cache size %d", len(data), c.maxBytes)
+	}
+	ext := AudioFileExt(mimeType)
+	if !isCacheExt(ext) {
+		return fmt.Errorf("cannot cache audio of type %q", mimeType)
+	}
+
+	// Write to a temp file and rename it into place, so a crash mid-write
+	// never leaves a truncated entry behind for the next startup to load
+	tmp, err := os.CreateTemp(c.dir, key+"-*.tmp")
+	if err != nil {
+		return fmt.Errorf("create cache temp file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("write cache entry: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := os.Rename(tmp.Name(), c.path(key, ext)); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("store cache entry: %w", err)
+	}
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if entry.ext != ext {
+			os.Remove(c.path(key, entry.ext))
+		}
+		c.size += int64(len(data)) - entry.size
+		entry.ext, entry.size = ext, int64(len(data))
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[key] = c.order.PushFront(&cacheEntry{key: key, ext: ext, size: int64(len(data))})
+		c.size += int64(len(data))
+	}
+
+	c.evictLocked()
+	return nil
+}
+
+// Purge removes every cached entry and resets the counters
+func (c *AudioCache) Purge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var firstErr error
+	for _, elem := range c.entries {
+		entry := elem.Value.(*cacheEntry)
+		if err := os.Remove(c.path(entry.key, entry.ext)); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	c.entries = make(map[string]*list.Element)
+	c.order.Init()
+	c.size = 0
+	c.hits = 0
+	c.misses = 0
+
+	log.Printf("TTS cache: Purged")
+	return firstErr
+}
+
+// Stats returns a snapshot of the cache counters
+func (c *AudioCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Entries:  len(c.entries),
+		Bytes:    c.size,
+		MaxBytes: c.maxBytes,
+		Hits:     c.hits,
+		Misses:   c.misses,
+	}
+}
+
+func (c *AudioCache) path(key, ext string) string {
+	return filepath.Join(c.dir, key+ext)
+}
+
+// evictLocked drops least-recently-used entries until under the size cap
+func (c *AudioCache) evictLocked() {
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		if oldest == nil {
+			return
+		}
+		log.Printf("TTS cache: Evicting %s", oldest.Value.(*cacheEntry).key)
+		c.removeLocked(oldest)
+	}
+}
+
+func (c *AudioCache) removeLocked(elem *list.Element) {
+	entry := elem.Value.(*cacheEntry)
+	if err := os.Remove(c.path(entry.key, entry.ext)); err != nil && !os.IsNotExist(err) {
+		log.Printf("TTS cache: Error removing %s - %v", entry.key, err)
+	}
+	c.order.Remove(elem)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
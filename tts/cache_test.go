@@ -0,0 +1,174 @@
+package tts
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testMP3(t *testing.T, frames int) []byte {
+	t.Helper()
+	var data [][]byte
+	for i := 0; i < frames; i++ {
+		data = append(data, mp3Frame(t, headerMPEG1))
+	}
+	return mp3File(data...)
+}
+
+func TestCacheEvictsLeastRecentlyUsedBySize(t *testing.T) {
+	clip := testMP3(t, 2)
+	c, err := NewAudioCache(t.TempDir(), int64(3*len(clip)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := c.Put(key, clip, MIMETypeMP3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Using "a" makes "b" the least recently used
+	if _, _, ok := c.Get("a"); !ok {
+		t.Fatal("a missing")
+	}
+	if err := c.Put("d", clip, MIMETypeMP3); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, err := os.Stat(filepath.Join(c.dir, key+".mp3")); (err == nil) != want {
+			t.Errorf("file of %s exists = %v, want %v", key, err == nil, want)
+		}
+	}
+	if stats := c.Stats(); stats.Entries != 3 || stats.Bytes != int64(3*len(clip)) {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if err := c.Put("huge", make([]byte, 4*len(clip)), MIMETypeMP3); err == nil {
+		t.Error("entry larger than the cache accepted")
+	}
+}
+
+func TestCacheCountsAndPurges(t *testing.T) {
+	c, err := NewAudioCache(t.TempDir(), DefaultCacheMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clip := testMP3(t, 1)
+	c.Put("a", clip, MIMETypeMP3)
+	c.Get("a")
+	c.Get("a")
+	c.Get("missing")
+	if stats := c.Stats(); stats.Hits != 2 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	if err := c.Purge(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := c.Stats(); stats != (CacheStats{MaxBytes: DefaultCacheMaxBytes}) {
+		t.Errorf("after purge, stats = %+v", stats)
+	}
+	if files, _ := os.ReadDir(c.dir); len(files) != 0 {
+		t.Errorf("purge left %d file(s)", len(files))
+	}
+}
+
+func TestCacheKeepsAudioFormat(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewAudioCache(dir, DefaultCacheMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wav := testWAV(1600)
+	if err := c.Put("w", wav, "audio/x-wav"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "w.wav")); err != nil {
+		t.Errorf("WAV not stored as .wav: %v", err)
+	}
+	if err := c.Put("x", wav, "application/octet-stream"); err == nil {
+		t.Error("audio of unknown type cached")
+	}
+
+	// Reopened, the entry still reads as WAV
+	c, err = NewAudioCache(dir, DefaultCacheMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, mimeType, ok := c.Get("w")
+	if !ok || mimeType != MIMETypeWAV || !bytes.Equal(data, wav) {
+		t.Errorf("Get = %d bytes, %q, %v", len(data), mimeType, ok)
+	}
+
+	// Storing the key again in another format replaces the file
+	if err := c.Put("w", testMP3(t, 1), MIMETypeMP3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "w.wav")); !os.IsNotExist(err) {
+		t.Error("old .wav left behind")
+	}
+}
+
+func TestCacheRecoversFromBrokenFiles(t *testing.T) {
+	dir := t.TempDir()
+	clip := testMP3(t, 2)
+	old := time.Now().Add(-time.Hour)
+	for name, data := range map[string][]byte{
+		"good.mp3":       clip,
+		"garbage.mp3":    []byte("not audio at all"),
+		"empty.mp3":      nil,
+		"half-123.tmp":   clip[:100],
+		"notes.txt":      []byte("ignored"),
+		"truncated.wav":  testWAV(1600)[:30],
+		"unrelated.json": []byte("{}"),
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, old, old)
+	}
+
+	c, err := NewAudioCache(dir, DefaultCacheMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"empty.mp3", "half-123.tmp"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s not removed on load", name)
+		}
+	}
+	if _, _, ok := c.Get("good"); !ok {
+		t.Error("good entry lost")
+	}
+	for _, key := range []string{"garbage", "truncated"} {
+		if _, _, ok := c.Get(key); ok {
+			t.Errorf("broken entry %s served", key)
+		}
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != int64(len(clip)) || stats.Misses != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Error("unrelated file removed")
+	}
+}
+
+func TestCacheKeyChangesWithProviderConfig(t *testing.T) {
+	before := ProviderConfig{Name: "piper", Type: ProviderHTTP, HTTP: &HTTPProviderConfig{URL: "http://localhost:5000/a"}}
+	after := before
+	after.HTTP = &HTTPProviderConfig{URL: "http://localhost:5000/b"}
+	disabled := before
+	disabled.Enabled, disabled.TimeoutMS = false, 500
+
+	key := func(config ProviderConfig) string {
+		return CacheKey("piper", config.Fingerprint(), "v", "halo", nil)
+	}
+	if key(before) == key(after) {
+		t.Error("editing the HTTP URL kept the cache key")
+	}
+	if key(before) != key(disabled) {
+		t.Error("settings that do not change the audio changed the cache key")
+	}
+}
@@ -0,0 +1,338 @@
+package tts
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"go.etcd.io/bbolt"
+)
+
+// CatalogVoice is a voice that can be assigned to an avatar. JSON names
+// match the columns of voices.csv.
+type CatalogVoice struct {
+	Provider       string `json:"provider"`
+	LangLabel      string `json:"lang_label"`
+	Name           string `json:"voice_name"`
+	Gender         string `json:"voice_gender"`
+	VoiceID        string `json:"voice_id"`
+	SampleSentence string `json:"sample_sentence,omitempty"`
+	SampleVoice    string `json:"sample_voice,omitempty"`
+	Custom         bool   `json:"custom"`
+}
+
+// key identifies a voice within the catalog
+func (v CatalogVoice) key() string {
+	return v.Provider + "/" + v.VoiceID
+}
+
+// VoiceFilter narrows a catalog listing. Empty fields match everything.
+type VoiceFilter struct {
+	Provider string
+	Language string // matches lang_label, case-insensitive
+	Gender   string
+	Query    string // substring of name, voice ID or language
+	Custom   *bool
+}
+
+func (f VoiceFilter) match(v CatalogVoice) bool {
+	if f.Provider != "" && v.Provider != f.Provider {
+		return false
+	}
+	if f.Language != "" && !strings.EqualFold(v.LangLabel, f.Language) {
+		return false
+	}
+	if f.Gender != "" && !strings.EqualFold(v.Gender, f.Gender) {
+		return false
+	}
+	if f.Custom != nil && v.Custom != *f.Custom {
+		return false
+	}
+	if f.Query != "" {
+		q := strings.ToLower(f.Query)
+		if !strings.Contains(strings.ToLower(v.Name), q) &&
+			!strings.Contains(strings.ToLower(v.VoiceID), q) &&
+			!strings.Contains(strings.ToLower(v.LangLabel), q) {
+			return false
+		}
+	}
+	return true
+}
+
+// VoiceCatalog holds every known voice. Entries come from Google's language
+// list, voices.csv and custom entries stored in bbolt, each overriding the
+// one before it.
+type VoiceCatalog struct {
+	db *bbolt.DB
+
+	mu      sync.RWMutex
+	voices  map[string]CatalogVoice
+	builtin map[string]CatalogVoice // entries not from the database
+}
+
+// NewVoiceCatalog loads the catalog from csvPath and the custom entries in db
+func NewVoiceCatalog(db *bbolt.DB, csvPath string) (*VoiceCatalog, error) {
+	c := &VoiceCatalog{
+		db:      db,
+		voices:  make(map[string]CatalogVoice),
+		builtin: make(map[string]CatalogVoice),
+	}
+
+	for _, code := range sortedKeys(googleLanguages) {
+		c.addBuiltin(CatalogVoice{
+			Provider:  ProviderGoogle,
+			LangLabel: googleLanguages[code],
+			Name:      "Google " + googleLanguages[code],
+			Gender:    "neutral",
+			VoiceID:   code,
+		})
+	}
+
+	// Without voices.csv, the Google languages and custom entries still work
+	voices, err := loadVoiceCSV(csvPath)
+	if err != nil {
+		log.Printf("Voice catalog: Cannot load %s - %v", csvPath, err)
+	}
+	for _, voice := range voices {
+		c.addBuiltin(voice)
+	}
+
+	custom := 0
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(VoicesBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var voice CatalogVoice
+			if err := json.Unmarshal(v, &voice); err != nil {
+				log.Printf("Error unmarshaling custom voice %q: %v", string(k), err)
+				return nil
+			}
+			voice.Custom = true
+			c.voices[voice.key()] = voice
+			custom++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Voice catalog: Loaded %d voices (%d custom)", len(c.voices), custom)
+	return c, nil
+}
+
+// loadVoiceCSV reads the voices.csv file at path
+func loadVoiceCSV(path string) ([]CatalogVoice, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ParseVoiceCSV(file)
+}
+
+// ParseVoiceCSV reads voices in the voices.csv format. Columns are matched
+// by header name, and rows without a provider or voice ID are skipped.
+func ParseVoiceCSV(r io.Reader) ([]CatalogVoice, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.TrimSpace(name)] = i
+	}
+
+	var voices []CatalogVoice
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		voice := CatalogVoice{
+			Provider:       field("provider"),
+			LangLabel:      field("lang_label"),
+			Name:           field("voice_name"),
+			Gender:         field("voice_gender"),
+			VoiceID:        field("voice_id"),
+			SampleSentence: field("sample_sentence"),
+			SampleVoice:    field("sample_voice"),
+		}
+		if voice.Provider == "" || voice.VoiceID == "" {
+			continue
+		}
+		voices = append(voices, voice)
+	}
+	return voices, nil
+}
+
+func (c *VoiceCatalog) addBuiltin(voice CatalogVoice) {
+	c.voices[voice.key()] = voice
+	c.builtin[voice.key()] = voice
+}
+
+// List returns the voices matching filter, sorted by provider, language and
+// name
+func (c *VoiceCatalog) List(filter VoiceFilter) []CatalogVoice {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	voices := make([]CatalogVoice, 0, len(c.voices))
+	for _, voice := range c.voices {
+		if filter.match(voice) {
+			voices = append(voices, voice)
+		}
+	}
+	sort.Slice(voices, func(i, j int) bool {
+		a, b := voices[i], voices[j]
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.LangLabel != b.LangLabel {
+			return a.LangLabel < b.LangLabel
+		}
+		return a.Name < b.Name
+	})
+	return voices
+}
+
+// Get returns a voice by provider and voice ID. Old Google voice IDs find
+// the voice they were renamed to, see googleVoiceAliases.
+func (c *VoiceCatalog) Get(provider, voiceID string) (CatalogVoice, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	voice, ok := c.voices[CatalogVoice{Provider: provider, VoiceID: voiceID}.key()]
+	if code, aliased := googleVoiceAliases[voiceID]; !ok && aliased && provider == ProviderGoogle {
+		voice, ok = c.voices[CatalogVoice{Provider: provider, VoiceID: code}.key()]
+	}
+	return voice, ok
+}
+
+// Has reports whether voiceID is listed under any of the given providers
+func (c *VoiceCatalog) Has(voiceID string, providers ...string) bool {
+	for _, provider := range providers {
+		if _, ok := c.Get(provider, voiceID); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// VoiceIDs returns the voice IDs listed under any of the given providers
+func (c *VoiceCatalog) VoiceIDs(providers ...string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	var ids []string
+	for _, voice := range c.voices {
+		for _, provider := range providers {
+			if voice.Provider == provider && !seen[voice.VoiceID] {
+				seen[voice.VoiceID] = true
+				ids = append(ids, voice.VoiceID)
+			}
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// SaveCustom stores a custom entry, replacing any entry with the same
+// provider and voice ID
+func (c *VoiceCatalog) SaveCustom(voice CatalogVoice) (CatalogVoice, error) {
+	voice.Provider = strings.TrimSpace(voice.Provider)
+	voice.VoiceID = strings.TrimSpace(voice.VoiceID)
+	if voice.Provider == "" || voice.VoiceID == "" {
+		return voice, fmt.Errorf("provider and voice_id are required")
+	}
+	if voice.Name == "" {
+		voice.Name = voice.VoiceID
+	}
+	voice.Custom = true
+
+	data, err := json.Marshal(voice)
+	if err != nil {
+		return voice, fmt.Errorf("marshal voice: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err = c.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(VoicesBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.Put([]byte(voice.key()), data)
+	})
+	if err != nil {
+		return voice, err
+	}
+
+	c.voices[voice.key()] = voice
+	log.Printf("Voice catalog: Saved custom voice %s", voice.key())
+	return voice, nil
+}
+
+// DeleteCustom removes a custom entry. Entries from voices.csv cannot be
+// deleted, but a custom entry overriding one falls back to it.
+func (c *VoiceCatalog) DeleteCustom(provider, voiceID string) error {
+	key := CatalogVoice{Provider: provider, VoiceID: voiceID}.key()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if voice, ok := c.voices[key]; !ok || !voice.Custom {
+		return fmt.Errorf("custom voice %s not found", key)
+	}
+
+	err := c.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(VoicesBucket))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		return b.Delete([]byte(key))
+	})
+	if err != nil {
+		return err
+	}
+
+	delete(c.voices, key)
+	if original, ok := c.builtin[key]; ok {
+		c.voices[key] = original
+	}
+	log.Printf("Voice catalog: Deleted custom voice %s", key)
+	return nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,158 @@
+package tts
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testVoicesCSV = `provider,lang_label,voice_name,voice_gender,voice_id,sample_sentence,sample_voice
+tiktok,Indonesian,Darma,male,id_male_darma,Ini adalah kalimat contoh.,/audio/id_male_darma.mp3
+tiktok,English,Jessie,female,en_us_002,This is a sample sentence.,/audio/en_us_002.mp3
+google,Indonesian,Google Indonesian,male,id,,
+,English,No provider,male,missing,,
+`
+
+func newTestCatalog(t *testing.T) *VoiceCatalog {
+	t.Helper()
+	csvPath := filepath.Join(t.TempDir(), "voices.csv")
+	if err := os.WriteFile(csvPath, []byte(testVoicesCSV), 0644); err != nil {
+		t.Fatal(err)
+	}
+	db, _ := openTestDB(t)
+	t.Cleanup(func() { db.Close() })
+
+	catalog, err := NewVoiceCatalog(db, csvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return catalog
+}
+
+func TestParseVoiceCSVSkipsIncompleteRows(t *testing.T) {
+	voices, err := ParseVoiceCSV(strings.NewReader(testVoicesCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(voices) != 3 {
+		t.Fatalf("got %d voices, want 3", len(voices))
+	}
+	darma := voices[0]
+	if darma.Name != "Darma" || darma.Gender != "male" || darma.SampleVoice != "/audio/id_male_darma.mp3" {
+		t.Errorf("unexpected first voice %+v", darma)
+	}
+}
+
+func TestVoiceCatalogFilters(t *testing.T) {
+	catalog := newTestCatalog(t)
+
+	tests := []struct {
+		name   string
+		filter VoiceFilter
+		want   []string
+	}{
+		{"provider", VoiceFilter{Provider: ProviderTikTok}, []string{"en_us_002", "id_male_darma"}},
+		{"language", VoiceFilter{Language: "indonesian"}, []string{"id", "id_male_darma"}},
+		{"gender", VoiceFilter{Provider: ProviderTikTok, Gender: "female"}, []string{"en_us_002"}},
+		{"query", VoiceFilter{Query: "darm"}, []string{"id_male_darma"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, voice := range catalog.List(tt.filter) {
+				got = append(got, voice.VoiceID)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Google's own language list is part of the catalog
+	if !catalog.Has("ja", ProviderGoogle) {
+		t.Errorf("built-in Google language missing")
+	}
+	if voice, _ := catalog.Get(ProviderGoogle, "id"); voice.Name != "Google Indonesian" {
+		t.Errorf("voices.csv did not override the built-in entry: %+v", voice)
+	}
+}
+
+func TestVoiceCatalogCustomEntries(t *testing.T) {
+	catalog := newTestCatalog(t)
+
+	provider, err := NewTikTokProvider(ProviderConfig{Name: ProviderTikTok},
+		&ProviderEnv{TikTokSessions: &TikTokSessionPool{}, Catalog: catalog})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if provider.ValidateVoiceID("en_us_rocket") {
+		t.Fatalf("voice outside the catalog was accepted")
+	}
+
+	if _, err := catalog.SaveCustom(CatalogVoice{Provider: ProviderTikTok, VoiceID: "en_us_rocket", LangLabel: "English"}); err != nil {
+		t.Fatal(err)
+	}
+	if !provider.ValidateVoiceID("en_us_rocket") {
+		t.Errorf("custom voice was rejected")
+	}
+
+	// A custom entry can override a shipped one, and deleting it restores it
+	if _, err := catalog.SaveCustom(CatalogVoice{Provider: ProviderTikTok, VoiceID: "en_us_002", Name: "Jess"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.DeleteCustom(ProviderTikTok, "en_us_002"); err != nil {
+		t.Fatal(err)
+	}
+	if voice, _ := catalog.Get(ProviderTikTok, "en_us_002"); voice.Name != "Jessie" || voice.Custom {
+		t.Errorf("shipped entry not restored: %+v", voice)
+	}
+	if err := catalog.DeleteCustom(ProviderTikTok, "id_male_darma"); err == nil {
+		t.Errorf("shipped entry was deleted")
+	}
+}
+
+func TestVoiceCatalogWithoutCSV(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+	catalog, err := NewVoiceCatalog(db, filepath.Join(t.TempDir(), "missing.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := catalog.SaveCustom(CatalogVoice{Provider: ProviderTikTok, VoiceID: "en_us_002"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopened without voices.csv, Google and the custom entries are kept
+	catalog, err = NewVoiceCatalog(db, filepath.Join(t.TempDir(), "missing.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !catalog.Has("id", ProviderGoogle) || !catalog.Has("en_us_002", ProviderTikTok) {
+		t.Errorf("catalog without voices.csv = %v", catalog.VoiceIDs(ProviderGoogle, ProviderTikTok))
+	}
+}
+
+func TestGoogleVoiceAliases(t *testing.T) {
+	catalog := newTestCatalog(t)
+	provider, err := NewGoogleTranslateProvider(ProviderConfig{Name: ProviderGoogle}, &ProviderEnv{Catalog: catalog})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Voices stored before the rename keep working
+	for old, code := range map[string]string{"kr": "ko", "jp": "ja"} {
+		if !provider.ValidateVoiceID(old) {
+			t.Errorf("old voice ID %q rejected", old)
+		}
+		if voice, _ := catalog.Get(ProviderGoogle, old); voice.VoiceID != code {
+			t.Errorf("Get(%q) = %+v", old, voice)
+		}
+		if googleVoiceID(old) != code {
+			t.Errorf("%q sent to Google as %q", old, googleVoiceID(old))
+		}
+	}
+	if _, ok := catalog.Get(ProviderTikTok, "kr"); ok {
+		t.Error("Google alias applied to TikTok")
+	}
+}
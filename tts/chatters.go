@@ -0,0 +1,300 @@
+package tts
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/oristarium/orionchat/types"
+	"go.etcd.io/bbolt"
+)
+
+// ErrChatterNotFound is returned for chatters without an assignment
+var ErrChatterNotFound = errors.New("chatter not found")
+
+// ChatterAssignment is the avatar and voice a chatter is heard with. Voice
+// fields are empty until the chatter's first message is synthesized.
+type ChatterAssignment struct {
+	Platform  string    `json:"platform"`
+	AuthorID  string    `json:"author_id"`
+	Name      string    `json:"name,omitempty"` // display name when assigned
+	AvatarID  string    `json:"avatar_id"`
+	VoiceID   string    `json:"voice_id,omitempty"`
+	Provider  string    `json:"provider,omitempty"`
+	Pinned    bool      `json:"pinned"` // set through the API rather than assigned
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// key identifies a chatter within the map
+func (a ChatterAssignment) key() string {
+	return chatterKey(a.Platform, a.AuthorID)
+}
+
+func chatterKey(platform, authorID string) string {
+	return platform + "/" + authorID
+}
+
+// ChatterOf returns the platform and author ID of a chat message. Both are
+// empty for messages without an author, such as custom TTS text. Chat
+// author IDs have the form {platform}-{id}, which is used when the author
+// carries no platform of its own.
+func ChatterOf(data map[string]interface{}) (platform, authorID string) {
+	author, _ := data["author"].(map[string]interface{})
+	authorID, _ = author["id"].(string)
+	if authorID == "" {
+		return "", ""
+	}
+	platform, _ = author["platform"].(string)
+	if platform == "" {
+		if i := strings.Index(authorID, "-"); i > 0 {
+			platform = authorID[:i]
+		}
+	}
+	return platform, authorID
+}
+
+// messageChatter returns the {platform}/{author_id} key of a message's
+// author, "" for messages without one
+func messageChatter(data map[string]interface{}) string {
+	platform, authorID := ChatterOf(data)
+	if authorID == "" {
+		return ""
+	}
+	return chatterKey(platform, authorID)
+}
+
+// pickFor maps key onto one of n choices, the same one every time
+func pickFor(key string, n int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// ChatterMap persists which avatar and voice each chatter is heard with
+type ChatterMap struct {
+	db *bbolt.DB
+}
+
+// NewChatterMap opens the chatter assignments stored in db
+func NewChatterMap(db *bbolt.DB) (*ChatterMap, error) {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(ChattersBucket))
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create bucket: %w", err)
+	}
+	return &ChatterMap{db: db}, nil
+}
+
+// Get returns the assignment of a chatter
+func (m *ChatterMap) Get(platform, authorID string) (ChatterAssignment, bool) {
+	var assignment ChatterAssignment
+	found := false
+	m.db.View(func(tx *bbolt.Tx) error {
+		data := tx.Bucket([]byte(ChattersBucket)).Get([]byte(chatterKey(platform, authorID)))
+		if data == nil {
+			return nil
+		}
+		if err := json.Unmarshal(data, &assignment); err != nil {
+			log.Printf("Error unmarshaling chatter %s: %v", chatterKey(platform, authorID), err)
+			return nil
+		}
+		found = true
+		return nil
+	})
+	return assignment, found
+}
+
+// List returns every assignment, sorted by platform and author ID
+func (m *ChatterMap) List() ([]ChatterAssignment, error) {
+	assignments := []ChatterAssignment{}
+	err := m.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(ChattersBucket)).ForEach(func(k, v []byte) error {
+			var assignment ChatterAssignment
+			if err := json.Unmarshal(v, &assignment); err != nil {
+				log.Printf("Error unmarshaling chatter %q: %v", string(k), err)
+				return nil
+			}
+			assignments = append(assignments, assignment)
+			return nil
+		})
+	})
+	sort.Slice(assignments, func(i, j int) bool {
+		return assignments[i].key() < assignments[j].key()
+	})
+	return assignments, err
+}
+
+// Save stores an assignment, replacing the chatter's previous one
+func (m *ChatterMap) Save(assignment ChatterAssignment) (ChatterAssignment, error) {
+	if assignment.Platform == "" || assignment.AuthorID == "" || assignment.AvatarID == "" {
+		return assignment, fmt.Errorf("platform, author_id and avatar_id are required")
+	}
+	if (assignment.VoiceID == "") != (assignment.Provider == "") {
+		return assignment, fmt.Errorf("voice_id and provider must be set together")
+	}
+	assignment.UpdatedAt = time.Now()
+
+	data, err := json.Marshal(assignment)
+	if err != nil {
+		return assignment, fmt.Errorf("marshal chatter: %w", err)
+	}
+
+	err = m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(ChattersBucket)).Put([]byte(assignment.key()), data)
+	})
+	return assignment, err
+}
+
+// assignIfNew stores assignment unless the chatter already has one, and
+// returns whichever is stored
+func (m *ChatterMap) assignIfNew(assignment ChatterAssignment) (ChatterAssignment, error) {
+	assignment.UpdatedAt = time.Now()
+	data, err := json.Marshal(assignment)
+	if err != nil {
+		return assignment, fmt.Errorf("marshal chatter: %w", err)
+	}
+
+	stored := assignment
+	err = m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ChattersBucket))
+		if existing := b.Get([]byte(assignment.key())); existing != nil {
+			return json.Unmarshal(existing, &stored)
+		}
+		return b.Put([]byte(assignment.key()), data)
+	})
+	return stored, err
+}
+
+// Delete resets a chatter, who is assigned afresh on their next message
+func (m *ChatterMap) Delete(platform, authorID string) error {
+	return m.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ChattersBucket))
+		key := []byte(chatterKey(platform, authorID))
+		if b.Get(key) == nil {
+			return ErrChatterNotFound
+		}
+		return b.Delete(key)
+	})
+}
+
+// Reset deletes every assignment and returns how many there were
+func (m *ChatterMap) Reset() (int, error) {
+	count := 0
+	err := m.db.Update(func(tx *bbolt.Tx) error {
+		count = tx.Bucket([]byte(ChattersBucket)).Stats().KeyN
+		if err := tx.DeleteBucket([]byte(ChattersBucket)); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucket([]byte(ChattersBucket))
+		return err
+	})
+	return count, err
+}
+
+// Chatters returns the chatter assignments, nil when the queue has no
+// database
+func (tm *TTSMiddleware) Chatters() *ChatterMap {
+	return tm.chatters
+}
+
+// chatterAvatar returns the avatar the author of a message speaks through.
+// New chatters are assigned one of the connected avatars, picked from
+// their ID so the outcome does not depend on timing. It reports false for
+// messages without an author and for chatters whose avatar is not
+// connected, who are then placed like anyone else without being reassigned.
+func (tm *TTSMiddleware) chatterAvatar(data map[string]interface{}, connected []string) (string, bool) {
+	platform, authorID := ChatterOf(data)
+	if tm.chatters == nil || authorID == "" || len(connected) == 0 {
+		return "", false
+	}
+
+	assignment, ok := tm.chatters.Get(platform, authorID)
+	if !ok {
+		sorted := append([]string(nil), connected...)
+		sort.Strings(sorted)
+		name := ""
+		if author, ok := data["author"].(map[string]interface{}); ok {
+			name, _ = author["display_name"].(string)
+		}
+		var err error
+		assignment, err = tm.chatters.assignIfNew(ChatterAssignment{
+			Platform: platform,
+			AuthorID: authorID,
+			Name:     name,
+			AvatarID: sorted[pickFor(chatterKey(platform, authorID), len(sorted))],
+		})
+		if err != nil {
+			log.Printf("Queue: Failed to assign chatter %s - %v", chatterKey(platform, authorID), err)
+			return "", false
+		}
+		log.Printf("Queue: Assigned chatter %s to avatar %s", assignment.key(), assignment.AvatarID)
+	}
+
+	for _, avatarID := range connected {
+		if avatarID == assignment.AvatarID {
+			return avatarID, true
+		}
+	}
+	return "", false
+}
+
+// chatterVoice picks the voice a message is spoken with from the voices of
+// its avatar. matching are the voices that speak the message's language, if
+// it is known. A chatter on their own avatar keeps their voice unless it
+// does not speak the message's language; one without a voice yet, or whose
+// voice was removed from the avatar, is given one picked from their ID.
+// Anyone else gets a random matching voice, or the avatar's first voice.
+func (tm *TTSMiddleware) chatterVoice(data map[string]interface{}, avatarID string, voices, matching []types.TTSVoice) (types.TTSVoice, error) {
+	if len(voices) == 0 {
+		return types.TTSVoice{}, fmt.Errorf("avatar has no TTS voices")
+	}
+
+	platform, authorID := ChatterOf(data)
+	assignment, ok := ChatterAssignment{}, false
+	if tm.chatters != nil && authorID != "" {
+		assignment, ok = tm.chatters.Get(platform, authorID)
+	}
+	if !ok || assignment.AvatarID != avatarID {
+		if len(matching) > 0 {
+			return matching[rand.Intn(len(matching))], nil
+		}
+		return voices[0], nil
+	}
+
+	if own, found := findVoice(voices, assignment.VoiceID, assignment.Provider); found {
+		if _, speaks := findVoice(matching, own.VoiceID, own.Provider); speaks || len(matching) == 0 {
+			return own, nil
+		}
+		// Another language than usual, in a voice of the chatter's own
+		return matching[pickFor(assignment.key(), len(matching))], nil
+	}
+
+	candidates := voices
+	if len(matching) > 0 {
+		candidates = matching
+	}
+	voice := candidates[pickFor(assignment.key(), len(candidates))]
+	assignment.VoiceID, assignment.Provider = voice.VoiceID, voice.Provider
+	if _, err := tm.chatters.Save(assignment); err != nil {
+		log.Printf("Queue: Failed to store voice of chatter %s - %v", assignment.key(), err)
+	}
+	return voice, nil
+}
+
+// findVoice returns the voice with voiceID and provider among voices
+func findVoice(voices []types.TTSVoice, voiceID, provider string) (types.TTSVoice, bool) {
+	for _, voice := range voices {
+		if voice.VoiceID == voiceID && voice.Provider == provider {
+			return voice, true
+		}
+	}
+	return types.TTSVoice{}, false
+}
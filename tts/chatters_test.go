@@ -0,0 +1,154 @@
+package tts
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/oristarium/orionchat/types"
+	"go.etcd.io/bbolt"
+)
+
+func chatMessage(authorID, platform string) map[string]interface{} {
+	author := map[string]interface{}{"id": authorID, "display_name": "Budi"}
+	if platform != "" {
+		author["platform"] = platform
+	}
+	return map[string]interface{}{"author": author}
+}
+
+func TestChatterOf(t *testing.T) {
+	tests := []struct {
+		data               map[string]interface{}
+		platform, authorID string
+	}{
+		{chatMessage("youtube-UC123", ""), "youtube", "youtube-UC123"},
+		{chatMessage("UC123", "youtube"), "youtube", "UC123"},
+		{chatMessage("anonymous", ""), "", "anonymous"},
+		{map[string]interface{}{"content": map[string]interface{}{}}, "", ""},
+	}
+	for _, tt := range tests {
+		platform, authorID := ChatterOf(tt.data)
+		if platform != tt.platform || authorID != tt.authorID {
+			t.Errorf("ChatterOf(%v) = %q, %q, want %q, %q", tt.data, platform, authorID, tt.platform, tt.authorID)
+		}
+	}
+}
+
+func TestChatterKeepsAvatarAndVoice(t *testing.T) {
+	db, path := openTestDB(t)
+	chatters, err := NewChatterMap(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm := newTestQueue(t)
+	tm.chatters = chatters
+
+	msg := chatMessage("twitch-42", "")
+	connected := []string{"c", "a", "b"}
+	avatarID, ok := tm.chatterAvatar(msg, connected)
+	if !ok {
+		t.Fatal("new chatter was not assigned")
+	}
+	// Assignment depends on the chatter, not on the order avatars connected
+	for i := 0; i < 5; i++ {
+		if again, _ := tm.chatterAvatar(msg, []string{"b", "c", "a"}); again != avatarID {
+			t.Fatalf("chatter moved from %s to %s", avatarID, again)
+		}
+	}
+
+	voices := []types.TTSVoice{
+		{VoiceID: "id_001", Provider: "tiktok"},
+		{VoiceID: "en_us_001", Provider: "tiktok"},
+		{VoiceID: "id-ID-Standard-A", Provider: "google"},
+	}
+	voice, err := tm.chatterVoice(msg, avatarID, voices, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if again, _ := tm.chatterVoice(msg, avatarID, voices, nil); again.VoiceID != voice.VoiceID {
+			t.Fatalf("chatter voice changed from %v to %v", voice, again)
+		}
+	}
+
+	// The assignment survives a restart
+	db.Close()
+	if db, err = bbolt.Open(path, 0600, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if tm.chatters, err = NewChatterMap(db); err != nil {
+		t.Fatal(err)
+	}
+	assignment, ok := tm.chatters.Get("twitch", "twitch-42")
+	if !ok || assignment.AvatarID != avatarID || assignment.VoiceID != voice.VoiceID || assignment.Name != "Budi" {
+		t.Fatalf("stored assignment = %+v", assignment)
+	}
+
+	// A chatter whose voice was removed from the avatar is given another
+	var remaining []types.TTSVoice
+	for _, v := range voices {
+		if v.VoiceID != voice.VoiceID {
+			remaining = append(remaining, v)
+		}
+	}
+	replacement, err := tm.chatterVoice(msg, avatarID, remaining, nil)
+	if err != nil || replacement.VoiceID == voice.VoiceID {
+		t.Fatalf("replacement voice = %v, %v", replacement, err)
+	}
+	if assignment, _ := tm.chatters.Get("twitch", "twitch-42"); assignment.VoiceID != replacement.VoiceID {
+		t.Errorf("replacement voice was not stored")
+	}
+
+	// While their avatar is away the chatter is placed like anyone else,
+	// and keeps the assignment for when it is back
+	if _, ok := tm.chatterAvatar(msg, []string{"elsewhere"}); ok {
+		t.Errorf("chatter was placed on a disconnected avatar")
+	}
+	if again, _ := tm.chatterAvatar(msg, connected); again != avatarID {
+		t.Errorf("chatter was reassigned while their avatar was away")
+	}
+
+	if _, err := tm.chatterVoice(msg, avatarID, nil, nil); err == nil {
+		t.Errorf("avatar without voices was accepted")
+	}
+}
+
+func TestChatterOverrideAndReset(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+	chatters, err := NewChatterMap(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := chatters.Save(ChatterAssignment{Platform: "youtube", AuthorID: "youtube-1", AvatarID: "a", VoiceID: "x"}); err == nil {
+		t.Errorf("voice without provider was accepted")
+	}
+	pinned, err := chatters.Save(ChatterAssignment{Platform: "youtube", AuthorID: "youtube-1", AvatarID: "a", Pinned: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An override is never replaced by an automatic assignment
+	stored, err := chatters.assignIfNew(ChatterAssignment{Platform: "youtube", AuthorID: "youtube-1", AvatarID: "b"})
+	if err != nil || stored.AvatarID != "a" || !stored.Pinned {
+		t.Fatalf("assignIfNew replaced %+v with %+v (%v)", pinned, stored, err)
+	}
+	chatters.assignIfNew(ChatterAssignment{Platform: "tiktok", AuthorID: "tiktok-2", AvatarID: "b"})
+
+	if list, _ := chatters.List(); len(list) != 2 || list[0].Platform != "tiktok" {
+		t.Errorf("List() = %+v", list)
+	}
+	if err := chatters.Delete("youtube", "youtube-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := chatters.Delete("youtube", "youtube-1"); !errors.Is(err, ErrChatterNotFound) {
+		t.Errorf("Delete of a missing chatter returned %v", err)
+	}
+	if count, err := chatters.Reset(); err != nil || count != 1 {
+		t.Errorf("Reset() = %d, %v", count, err)
+	}
+	if list, _ := chatters.List(); len(list) != 0 {
+		t.Errorf("chatters left after reset: %+v", list)
+	}
+}
@@ -1,9 +1,112 @@
 package tts
 
+import "time"
+
 // Common constants for TTS providers
 const (
 	MaxTextLength = 200 // Maximum length of text that can be processed at once
 	ProviderGoogle = "google"
 	ProviderTikTok = "tiktok"
-	TikTokSessionID = "c673246e12e407380845a488af057da9"
+	ProviderHTTP = "http"
+
+	// bbolt buckets holding provider configs, TikTok session IDs, custom
+	// voice catalog entries, the queue and chatter assignments
+	ProvidersBucket      = "tts_providers"
+	TikTokSessionsBucket = "tiktok_sessions"
+	VoicesBucket         = "tts_voices"
+	QueueBucket          = "tts_queue"
+	ChattersBucket       = "tts_chatters"
+
+	// Voice catalog shipped with the app
+	VoicesCSVPath = "assets/data/voices.csv"
+
+	// Slang dictionaries, blocked words and emoji names shipped with the
+	// app, one CSV per language code under slang_dict, blocked and emoji
+	SanitizerDataDir = "assets/data"
+
+	// bbolt bucket holding sanitizer settings changed through the API
+	SanitizerSettingsBucket = "tts_sanitizer_settings"
+
+	// bbolt bucket holding the replacement, slang and blocked word lists,
+	// seeded from SanitizerDataDir on first start
+	SanitizerRulesBucket = "tts_sanitizer_rules"
+
+	// Spoken by voice previews when the catalog has no sample sentence
+	PreviewDefaultText = "Hello! This is what my voice sounds like."
+
+	// Accepted range of per-voice settings. Speed is a playback rate, pitch
+	// is in semitones and gain in dB.
+	MinVoiceSpeed = 0.5
+	MaxVoiceSpeed = 2.0
+	MaxVoicePitch = 12
+	MaxVoiceGain  = 20
+
+	// TTS queue lanes, served highest first. A message's lane comes from
+	// its donation and author roles unless the update names one.
+	PriorityNormal     = 0
+	PrioritySubscriber = 1
+	PriorityModerator  = 2 // moderators and the broadcaster
+	PriorityDonation   = 3
+
+	// Waiting this long raises a queued message by one lane, so lower lanes
+	// are never starved
+	PriorityAgingInterval = 30 * time.Second
+
+	// Messages are synthesized ahead of playback by up to SynthesisWorkers
+	// at once, and at most SynthesisPerProvider per provider. Messages
+	// arriving while MaxPendingSynthesis are still waiting are dropped.
+	SynthesisWorkers     = 4
+	SynthesisPerProvider = 2
+	MaxPendingSynthesis  = 50
+
+	// An avatar that has not reported a clip finished this long after its
+	// length is given up on, and the queue moves on. Clips of unknown length
+	// are assumed to take UnknownClipDuration.
+	SpeakingGracePeriod = 5 * time.Second
+	UnknownClipDuration = 30 * time.Second
+
+	// In concurrent playback mode, up to this many avatars speak at once
+	DefaultMaxConcurrentSpeakers = 3
+	MaxConcurrentSpeakers        = 10
+
+	// Weighted avatar selection picks the DefaultRecentAvatars most recently
+	// used avatars less often; up to MaxRecentAvatars can be set
+	DefaultRecentAvatars = 3
+	MaxRecentAvatars     = 10
+
+	// An avatar speaks a message in a voice of the message's language when
+	// DetectLanguage is at least this confident, else in its first voice
+	LanguageMinConfidence = 0.6
+
+	// bbolt bucket holding queue settings changed through the API
+	QueueSettingsBucket = "tts_queue_settings"
+
+	// Status of this many recent tts updates can be looked up by job ID
+	MaxTrackedJobs = 1000
+
+	// Audio of queued messages, kept next to the database until the message
+	// is spoken, see QueueBlobPath
+	QueueBlobDir = "tts_blobs"
+
+	// Queued messages older than this are dropped when the server starts.
+	// The GeneralBucket key QueueMaxAgeKey overrides it with a Go duration
+	// such as "10m"; "0" keeps every message.
+	DefaultQueueMaxAge = 15 * time.Minute
+	QueueMaxAgeKey     = "tts_queue_max_age"
+
+	// Audio cache
+	CacheDir             = "tts_cache"
+	DefaultCacheMaxBytes = 200 << 20 // 200 MB
+
+	// Retry policy for transient provider failures. RetryMaxAttempts counts
+	// the first try, so 3 attempts means at most 2 retries per chunk.
+	RetryMaxAttempts    = 3
+	RetryInitialBackoff = 500 * time.Millisecond
+	RetryMaxBackoff     = 4 * time.Second
+
+	// Upper bound on the time a whole fallback chain may take
+	FallbackChainTimeout = 20 * time.Second
+
+	// Per-request timeout for provider HTTP clients
+	ProviderRequestTimeout = 10 * time.Second
 ) 
\ No newline at end of file
@@ -0,0 +1,286 @@
+package tts
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.etcd.io/bbolt"
+)
+
+// Ways Sanitize reads emoji, see EmojiSettings
+const (
+	EmojiDescribe = "describe" // read each emoji's name
+	EmojiCollapse = "collapse" // read repeats of an emoji once, as "fire times 5"
+	EmojiStrip    = "strip"    // drop emoji
+)
+
+var emojiModes = []string{EmojiDescribe, EmojiCollapse, EmojiStrip}
+
+// emojiSettingsKey is where EmojiSettings are kept in
+// SanitizerSettingsBucket
+const emojiSettingsKey = "emoji"
+
+// EmojiSettings control how emoji in messages are read. Emoji missing from
+// the name tables, such as ones newer than them, are always dropped.
+type EmojiSettings struct {
+	Mode          string `json:"mode"`
+	MaxPerMessage int    `json:"max_per_message"` // names read per message, a collapsed run counting once; 0 for no cap
+}
+
+// DefaultEmojiSettings read the first three emoji of a message, repeats
+// collapsed
+var DefaultEmojiSettings = EmojiSettings{Mode: EmojiCollapse, MaxPerMessage: 3}
+
+// Validate checks the mode and cap
+func (s EmojiSettings) Validate() error {
+	if !slices.Contains(emojiModes, s.Mode) {
+		return fmt.Errorf("unknown emoji mode %q (use %s)", s.Mode, strings.Join(emojiModes, ", "))
+	}
+	if s.MaxPerMessage < 0 {
+		return fmt.Errorf("max_per_message cannot be negative")
+	}
+	return nil
+}
+
+// emojiTimes is read between a collapsed emoji and its count
+var emojiTimes = map[string]string{
+	LangEnglish:    "times",
+	LangIndonesian: "kali",
+}
+
+// emojiTable holds the names of emoji in one language, keyed by emoji
+// without variation selectors and skin tones
+type emojiTable struct {
+	names   map[string]string
+	first   map[rune]bool // runes an emoji starts with
+	longest int           // runes in the longest emoji
+}
+
+// emojiIgnored reports variation selectors and skin tones, which do not
+// change what an emoji is called
+func emojiIgnored(r rune) bool {
+	return r == 0xFE0E || r == 0xFE0F || (r >= 0x1F3FB && r <= 0x1F3FF)
+}
+
+func withoutIgnored(s string) string {
+	return strings.Map(func(r rune) rune {
+		if emojiIgnored(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// isPictographic reports runes of the emoji blocks and the joiners between
+// them, dropped when they are not part of a known emoji
+func isPictographic(r rune) bool {
+	switch {
+	case r == 0x200D, r == 0x20E3: // zero width joiner, keycap
+	case r >= 0x2600 && r <= 0x27BF: // symbols and dingbats
+	case r >= 0x1F000 && r <= 0x1FAFF:
+	case r >= 0xE0020 && r <= 0xE007F: // tags of subdivision flags
+	default:
+		return false
+	}
+	return true
+}
+
+// loadEmojiTable loads emoji names from a CSV file with an emoji and its
+// name on each row
+func loadEmojiTable(path string) (*emojiTable, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	t := &emojiTable{names: make(map[string]string, len(records)), first: make(map[rune]bool)}
+	for _, record := range records {
+		if len(record) < 2 {
+			continue
+		}
+		// Lone skin tones have nothing left
+		key := withoutIgnored(record[0])
+		if key == "" {
+			continue
+		}
+		t.names[key] = record[1]
+		r, _ := utf8.DecodeRuneInString(key)
+		t.first[r] = true
+		t.longest = max(t.longest, utf8.RuneCountInString(key))
+	}
+	return t, nil
+}
+
+// match returns the name of the longest emoji runes start with and how many
+// runes it takes, 0 for none
+func (t *emojiTable) match(runes []rune) (string, int) {
+	if !t.first[runes[0]] {
+		return "", 0
+	}
+	for n := min(t.longest, len(runes)); n > 0; n-- {
+		if name, ok := t.names[string(runes[:n])]; ok {
+			return name, n
+		}
+	}
+	return "", 0
+}
+
+// emojiTable returns the emoji names in lang, loading them on first use.
+// Languages without a table get the English names. Call with emojiMu held.
+func (s *TextSanitizer) emojiTable(lang string) *emojiTable {
+	if lang == "" {
+		lang = LangEnglish
+	}
+	t, loaded := s.emojiTables[lang]
+	if !loaded {
+		var err error
+		t, err = loadEmojiTable(filepath.Join(s.dataDir, "emoji", lang+".csv"))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Error loading emoji names for %s: %v", lang, err)
+		}
+		s.emojiTables[lang] = t
+	}
+	if t == nil && lang != LangEnglish {
+		return s.emojiTable(LangEnglish)
+	}
+	return t
+}
+
+// EmojiSettings returns how the sanitizer reads emoji
+func (s *TextSanitizer) EmojiSettings() EmojiSettings {
+	s.emojiMu.Lock()
+	defer s.emojiMu.Unlock()
+	return s.emoji
+}
+
+// SetEmojiSettings changes how the sanitizer reads emoji
+func (s *TextSanitizer) SetEmojiSettings(settings EmojiSettings) {
+	s.emojiMu.Lock()
+	defer s.emojiMu.Unlock()
+	s.emoji = settings
+}
+
+// replaceEmoji replaces the emoji in text with their names in lang, or
+// drops them, as the emoji settings say
+func (s *TextSanitizer) replaceEmoji(text, lang string) string {
+	s.emojiMu.Lock()
+	settings := s.emoji
+	table := s.emojiTable(lang)
+	s.emojiMu.Unlock()
+
+	times, ok := emojiTimes[lang]
+	if !ok {
+		times = emojiTimes[LangEnglish]
+	}
+
+	var b strings.Builder
+	read := 0
+	// The emoji being read and how often it was repeated
+	name, count := "", 0
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		if settings.MaxPerMessage == 0 || read < settings.MaxPerMessage {
+			b.WriteString(" " + name)
+			if count > 1 {
+				b.WriteString(" " + times + " " + strconv.Itoa(count))
+			}
+			b.WriteString(" ")
+			read++
+		}
+		name, count = "", 0
+	}
+
+	runes := []rune(withoutIgnored(text))
+	for i := 0; i < len(runes); {
+		var matched string
+		n := 0
+		if table != nil {
+			matched, n = table.match(runes[i:])
+		}
+		switch {
+		case n > 0:
+			i += n
+			switch settings.Mode {
+			case EmojiStrip:
+			case EmojiCollapse:
+				if matched != name {
+					flush()
+				}
+				name = matched
+				count++
+			default:
+				name, count = matched, 1
+				flush()
+			}
+		case isPictographic(runes[i]):
+			i++
+		case unicode.IsSpace(runes[i]) && count > 0:
+			// Between repeats
+			i++
+		default:
+			flush()
+			b.WriteRune(runes[i])
+			i++
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// settingsDB returns the database the service keeps its settings in, the
+// one of its provider registry
+func (s *TTSService) settingsDB() *bbolt.DB {
+	if s.providers == nil {
+		return nil
+	}
+	return s.providers.db
+}
+
+// EmojiSettings returns how the service reads emoji
+func (s *TTSService) EmojiSettings() EmojiSettings {
+	return s.sanitizer.EmojiSettings()
+}
+
+// SetEmojiSettings validates, stores and applies emoji settings
+func (s *TTSService) SetEmojiSettings(settings EmojiSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if err := storeSetting(s.settingsDB(), SanitizerSettingsBucket, emojiSettingsKey, settings); err != nil {
+		return err
+	}
+	s.sanitizer.SetEmojiSettings(settings)
+	log.Printf("Sanitizer: Emoji mode %s, at most %d per message", settings.Mode, settings.MaxPerMessage)
+	return nil
+}
+
+// loadEmojiSettings applies the stored emoji settings, keeping the defaults
+// when none are stored
+func (s *TTSService) loadEmojiSettings() error {
+	var settings EmojiSettings
+	found, err := loadSetting(s.settingsDB(), SanitizerSettingsBucket, emojiSettingsKey, &settings)
+	if err != nil || !found {
+		return err
+	}
+	if err := settings.Validate(); err != nil {
+		log.Printf("Sanitizer: Ignoring invalid stored emoji settings - %v", err)
+		return nil
+	}
+	s.sanitizer.SetEmojiSettings(settings)
+	return nil
+}
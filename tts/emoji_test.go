@@ -0,0 +1,88 @@
+package tts
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func newTestSanitizer(settings EmojiSettings) *TextSanitizer {
+	s := NewTextSanitizer()
+	s.dataDir = filepath.Join("..", SanitizerDataDir)
+	s.SetEmojiSettings(settings)
+	return s
+}
+
+func TestEmojiNames(t *testing.T) {
+	s := newTestSanitizer(EmojiSettings{Mode: EmojiDescribe})
+	tests := []struct {
+		voiceID, text, want string
+	}{
+		{"en_us_001", "nice 👍", "nice thumbs up"},
+		// Skin tones and variation selectors do not change the name
+		{"en_us_001", "👍🏽 ❤ ❤️", "thumbs up red heart red heart"},
+		// Joined sequences, flags and keycaps
+		{"en_us_001", "👨‍💻👩🏾‍🚀", "man technologist woman astronaut"},
+		{"en_us_001", "🇮🇩 🏴󠁧󠁢󠁥󠁮󠁧󠁿", "flag of Indonesia flag of England"},
+		{"en_us_001", "1️⃣", "one"},
+		{"id_male_darma", "gas 🔥🇮🇩", "gas api bendera Indonesia"},
+		{"id_male_darma", "👨‍👩‍👧", "keluarga pria, wanita, anak perempuan"},
+		// No Japanese table: English names
+		{"jp_001", "🔥", "fire"},
+		// Emoji missing from the tables are dropped
+		{"en_us_001", "hi \U0001FAFF‍ there", "hi there"},
+	}
+	for _, tt := range tests {
+		if got := s.Sanitize(tt.text, tt.voiceID); got != tt.want {
+			t.Errorf("Sanitize(%q, %s) = %q, want %q", tt.text, tt.voiceID, got, tt.want)
+		}
+	}
+}
+
+func TestEmojiModes(t *testing.T) {
+	const text = "🔥🔥🔥🔥🔥 mantap 😂 😂 👍🎉🙏"
+	tests := []struct {
+		settings      EmojiSettings
+		voiceID, want string
+	}{
+		{EmojiSettings{Mode: EmojiCollapse}, "en_us_001",
+			"fire times five mantap face with tears of joy times two thumbs up party popper folded hands"},
+		{EmojiSettings{Mode: EmojiCollapse, MaxPerMessage: 2}, "id_male_darma",
+			"api kali lima mantap wajah menangis bahagia kali dua"},
+		{EmojiSettings{Mode: EmojiDescribe, MaxPerMessage: 3}, "en_us_001", "fire fire fire mantap"},
+		{EmojiSettings{Mode: EmojiStrip}, "en_us_001", "mantap"},
+	}
+	for _, tt := range tests {
+		s := newTestSanitizer(tt.settings)
+		if got := s.Sanitize(text, tt.voiceID); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.settings, got, tt.want)
+		}
+	}
+
+	// Before the voice is known, emoji are kept for its own pass
+	s := newTestSanitizer(DefaultEmojiSettings)
+	if got := s.Sanitize("gg 🔥🔥", ""); got != "gg 🔥🔥" {
+		t.Errorf("first pass gave %q", got)
+	}
+}
+
+func TestEmojiSettingsPersist(t *testing.T) {
+	catalog := newTestCatalog(t)
+	registry, err := NewProviderRegistry(catalog.db, catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewTTSService(registry, nil)
+	if got := service.EmojiSettings(); got != DefaultEmojiSettings {
+		t.Errorf("new service reads emoji as %+v", got)
+	}
+	if err := service.SetEmojiSettings(EmojiSettings{Mode: "shout"}); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	settings := EmojiSettings{Mode: EmojiStrip, MaxPerMessage: 1}
+	if err := service.SetEmojiSettings(settings); err != nil {
+		t.Fatal(err)
+	}
+	if got := NewTTSService(registry, nil).EmojiSettings(); got != settings {
+		t.Errorf("restarted service reads emoji as %+v, want %+v", got, settings)
+	}
+}
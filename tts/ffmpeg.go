@@ -0,0 +1,91 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+var (
+	ffmpegOnce sync.Once
+	ffmpegBin  string
+)
+
+// ffmpegPath returns the path of the ffmpeg binary, or "" when it is not
+// installed. The lookup happens once.
+func ffmpegPath() string {
+	ffmpegOnce.Do(func() {
+		path, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			log.Printf("TTS tuning: ffmpeg not found, speed and pitch of MP3 voices will not be changed")
+			return
+		}
+		ffmpegBin = path
+	})
+	return ffmpegBin
+}
+
+// tuneWithFFmpeg applies t to MP3 audio by piping it through ffmpeg
+func tuneWithFFmpeg(ctx context.Context, path string, audio []byte, t Tuning) ([]byte, error) {
+	sampleRate := 0
+	walkMP3Frames(stripID3(audio), func(h mp3FrameHeader, frame []byte) {
+		if sampleRate == 0 {
+			sampleRate = h.sampleRate
+		}
+	})
+	if sampleRate == 0 {
+		return nil, fmt.Errorf("no MP3 frames found")
+	}
+
+	cmd := exec.CommandContext(ctx, path,
+		"-hide_banner", "-loglevel", "error",
+		"-f", "mp3", "-i", "pipe:0",
+		"-af", ffmpegFilters(t, sampleRate),
+		"-f", "mp3", "pipe:1")
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(audio)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// ffmpegFilters builds the audio filter chain for t. Pitch is shifted by
+// playing the audio at a different sample rate, and atempo then corrects
+// the length. atempo only accepts 0.5-2, so larger changes are chained.
+func ffmpegFilters(t Tuning, sampleRate int) string {
+	var filters []string
+
+	tempo := t.Speed
+	if t.changesPitch() {
+		ratio := math.Pow(2, t.Pitch/12)
+		filters = append(filters,
+			fmt.Sprintf("asetrate=%d", int(math.Round(float64(sampleRate)*ratio))),
+			fmt.Sprintf("aresample=%d", sampleRate))
+		tempo /= ratio
+	}
+	for ; tempo > 2; tempo /= 2 {
+		filters = append(filters, "atempo=2")
+	}
+	for ; tempo < 0.5; tempo /= 0.5 {
+		filters = append(filters, "atempo=0.5")
+	}
+	if math.Abs(tempo-1) >= tuningEpsilon {
+		filters = append(filters, fmt.Sprintf("atempo=%.4f", tempo))
+	}
+	if math.Abs(t.Gain) >= tuningEpsilon {
+		filters = append(filters, fmt.Sprintf("volume=%.2fdB", t.Gain))
+	}
+	return strings.Join(filters, ",")
+}
@@ -1,6 +1,7 @@
 package tts
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,55 +11,93 @@ import (
 	"strings"
 )
 
-const (
-	defaultHost    = "https://translate.google.com"
-	defaultTimeout = 10000 // ms
-)
+const defaultHost = "https://translate.google.com"
+
+// googleSlowRate is roughly how fast Google's slow voice speaks compared to
+// its normal one
+const googleSlowRate = 0.6
+
+// googleLanguages are the languages Google Translate can speak, by voice ID.
+// They seed the voice catalog.
+var googleLanguages = map[string]string{
+	"af": "Afrikaans", "ar": "Arabic", "bg": "Bulgarian", "bn": "Bengali",
+	"bs": "Bosnian", "ca": "Catalan", "cs": "Czech", "da": "Danish",
+	"de": "German", "el": "Greek", "en": "English", "es": "Spanish",
+	"et": "Estonian", "fi": "Finnish", "fr": "French", "gu": "Gujarati",
+	"hi": "Hindi", "hr": "Croatian", "hu": "Hungarian", "id": "Indonesian",
+	"is": "Icelandic", "it": "Italian", "iw": "Hebrew", "ja": "Japanese",
+	"jw": "Javanese", "km": "Khmer", "kn": "Kannada", "ko": "Korean",
+	"la": "Latin", "lv": "Latvian", "ml": "Malayalam", "mr": "Marathi",
+	"ms": "Malay", "my": "Burmese", "ne": "Nepali", "nl": "Dutch",
+	"no": "Norwegian", "pl": "Polish", "pt": "Portuguese", "ro": "Romanian",
+	"ru": "Russian", "si": "Sinhala", "sk": "Slovak", "sq": "Albanian",
+	"sr": "Serbian", "su": "Sundanese", "sv": "Swedish", "sw": "Swahili",
+	"ta": "Tamil", "te": "Telugu", "th": "Thai", "tl": "Filipino",
+	"tr": "Turkish", "uk": "Ukrainian", "ur": "Urdu", "vi": "Vietnamese",
+	"zh-CN": "Chinese (Simplified)", "zh-TW": "Chinese (Traditional)",
+}
+
+// googleVoiceAliases maps the voice IDs of Google voices before they were
+// renamed to Google's language codes. Avatars and chatter assignments may
+// still hold them.
+var googleVoiceAliases = map[string]string{
+	"kr": "ko",
+	"jp": "ja",
+}
+
+// googleVoiceID returns the language code Google expects for voiceID
+func googleVoiceID(voiceID string) string {
+	if code, ok := googleVoiceAliases[voiceID]; ok {
+		return code
+	}
+	return voiceID
+}
 
 // GoogleTranslateProvider implements the Provider interface for Google Translate
 type GoogleTranslateProvider struct {
+	name    string
+	catalog *VoiceCatalog
 	host    string
 	client  *http.Client
-	timeout int
-	sanitizer *TextSanitizer
 }
 
 // NewGoogleTranslateProvider creates a new Google Translate provider instance
-func NewGoogleTranslateProvider() Provider {
-	log.Printf("Initializing %s provider", ProviderGoogle)
+func NewGoogleTranslateProvider(config ProviderConfig, env *ProviderEnv) (Provider, error) {
+	log.Printf("Initializing %s provider %s", ProviderGoogle, config.Name)
 	return &GoogleTranslateProvider{
-		host:    defaultHost,
-		client:  &http.Client{},
-		timeout: defaultTimeout,
-		sanitizer: NewTextSanitizer(),
-	}
+		name:    config.Name,
+		catalog: env.catalog(),
+		host:    strings.TrimRight(config.HostOr(defaultHost), "/"),
+		client:  &http.Client{Timeout: config.Timeout()},
+	}, nil
 }
 
-// GetAudioBase64 converts text to speech using Google Translate
-func (p *GoogleTranslateProvider) GetAudioBase64(text string, voiceID string, options map[string]interface{}) (string, error) {
+// Synthesize converts text to speech using Google Translate.
+// Google only supports a normal and a slow rate, so any speed below 1 maps
+// to its slow flag; see SplitTuning.
+func (p *GoogleTranslateProvider) Synthesize(ctx context.Context, request SynthesisRequest) (*SynthesisResponse, error) {
+	text, voiceID := request.Text, request.VoiceID
 	log.Printf("Google TTS Request - Text: %q, Lang: %q", text, voiceID)
-	
-	slow := false
-	if val, ok := options["slow"].(bool); ok {
-		slow = val
+
+	if err := checkFormat(request); err != nil {
+		return nil, err
 	}
+	slow := request.Speed > 0 && request.Speed < 1
 
 	if len(text) > MaxTextLength {
 		log.Printf("Text too long: %d characters (max: %d)", len(text), MaxTextLength)
-		return "", fmt.Errorf("text length (%d) should be less than %d characters", len(text), MaxTextLength)
+		return nil, fmt.Errorf("text length (%d) should be less than %d characters", len(text), MaxTextLength)
 	}
 
-	text = p.sanitizer.Sanitize(text, ProviderGoogle)
-
 	if strings.TrimSpace(text) == "" {
-		return "", fmt.Errorf("text cannot be empty")
+		return nil, fmt.Errorf("text cannot be empty")
 	}
 
-	innerData := []interface{}{text, voiceID, slow, "null"}
+	innerData := []interface{}{text, googleVoiceID(voiceID), slow, "null"}
 	innerJSON, err := json.Marshal(innerData)
 	if err != nil {
 		log.Printf("Failed to marshal inner data: %v", err)
-		return "", fmt.Errorf("failed to marshal inner data: %v", err)
+		return nil, fmt.Errorf("failed to marshal inner data: %v", err)
 	}
 
 	data := [][3]interface{}{
@@ -73,19 +112,19 @@ func (p *GoogleTranslateProvider) GetAudioBase64(text string, voiceID string, op
 	outerJSON, err := json.Marshal(outerData)
 	if err != nil {
 		log.Printf("Failed to marshal outer data: %v", err)
-		return "", fmt.Errorf("failed to marshal outer data: %v", err)
+		return nil, fmt.Errorf("failed to marshal outer data: %v", err)
 	}
 
 	formData := url.Values{}
 	formData.Set("f.req", string(outerJSON))
 
 	log.Printf("Sending request to Google Translate TTS")
-	req, err := http.NewRequest("POST", 
+	req, err := http.NewRequestWithContext(ctx, "POST", 
 		p.host+"/_/TranslateWebserverUi/data/batchexecute",
 		strings.NewReader(formData.Encode()))
 	if err != nil {
 		log.Printf("Failed to create request: %v", err)
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	p.setHeaders(req)
@@ -93,40 +132,62 @@ func (p *GoogleTranslateProvider) GetAudioBase64(text string, voiceID string, op
 	resp, err := p.client.Do(req)
 	if err != nil {
 		log.Printf("Request failed: %v", err)
-		return "", fmt.Errorf("request failed: %v", err)
+		return nil, requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
+	if err := checkStatus(resp); err != nil {
+		log.Printf("Request failed: %v", err)
+		return nil, err
+	}
+
 	log.Printf("Response status: %s", resp.Status)
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Failed to read response: %v", err)
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return nil, transientf("failed to read response: %v", err)
 	}
 
 	log.Printf("Response body: %s", string(body))
-	return p.parseResponse(body)
+	base64Audio, err := p.parseResponse(body)
+	if err != nil {
+		return nil, err
+	}
+
+	audio, err := DecodeAudioBase64(base64Audio)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %v", err)
+	}
+	return newMP3Response(audio), nil
+}
+
+// SplitTuning uses Google's slow flag for speeds closer to its slow rate
+// than to normal, leaving the difference and pitch and gain to
+// post-processing
+func (p *GoogleTranslateProvider) SplitTuning(t Tuning) (native, rest Tuning) {
+	native, rest = neutralTuning, t
+	if t.Speed <= (1+googleSlowRate)/2 {
+		native.Speed = googleSlowRate
+		rest.Speed = t.Speed / googleSlowRate
+	}
+	return native, rest
 }
 
-// GetVoiceIDs returns available voice IDs for Google Translate
+// GetVoiceIDs returns the Google Translate voices in the catalog
 func (p *GoogleTranslateProvider) GetVoiceIDs() []string {
-	return []string{
-		"af", "ar", "bg", "bn", "bs", "ca", "cs", "da", "de", "el", "en", "es",
-		"et", "fi", "fr", "gu", "hi", "hr", "hu", "id", "is", "it", "iw", "ja",
-		"jw", "km", "kn", "ko", "la", "lv", "ml", "mr", "ms", "my", "ne", "nl",
-		"no", "pl", "pt", "ro", "ru", "si", "sk", "sq", "sr", "su", "sv", "sw",
-		"ta", "te", "th", "tl", "tr", "uk", "ur", "vi", "zh-CN", "zh-TW",
+	if p.catalog == nil {
+		return sortedKeys(googleLanguages)
 	}
+	return p.catalog.VoiceIDs(p.name, ProviderGoogle)
 }
 
-// ValidateVoiceID checks if a voice ID is valid for Google Translate
+// ValidateVoiceID checks a voice ID against the catalog
 func (p *GoogleTranslateProvider) ValidateVoiceID(voiceID string) bool {
-	for _, id := range p.GetVoiceIDs() {
-		if id == voiceID {
-			return true
-		}
+	if p.catalog == nil {
+		_, ok := googleLanguages[voiceID]
+		return ok
 	}
-	return false
+	return p.catalog.Has(voiceID, p.name, ProviderGoogle)
 }
 
 // Private helper methods
@@ -0,0 +1,304 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+)
+
+// Response modes of the generic HTTP provider
+const (
+	HTTPResponseRaw  = "raw"  // body is the audio itself
+	HTTPResponseJSON = "json" // body is JSON with the audio base64-encoded in a field
+)
+
+// HTTPProviderConfig holds the engine-specific settings of a generic HTTP
+// provider. URL and Body are Go templates rendered with the fields of
+// httpTemplateData, e.g.
+//
+//	url:  {{.Host}}/?voice={{.Voice | urlquery}}&text={{.Text | urlquery}}
+//	body: {"model":"tts-1","input":{{json .Text}},"voice":{{json .Voice}},"speed":{{.Speed}}}
+//
+// Host, timeout and the auth value live in the surrounding ProviderConfig.
+type HTTPProviderConfig struct {
+	URL          string   `json:"url"`
+	Method       string   `json:"method"`       // defaults to POST when a body is set, GET otherwise
+	Body         string   `json:"body"`         // optional request body template
+	ContentType  string   `json:"content_type"` // defaults to application/json when a body is set
+	AuthHeader   string   `json:"auth_header"`  // e.g. "Authorization", sent with the auth_value credential
+	ResponseMode string   `json:"response_mode"`
+	AudioField   string   `json:"audio_field"` // dot path to the base64 audio in JSON mode, defaults to "audio"
+	Voices       []string `json:"voices"`      // accepted voice IDs, empty accepts any
+}
+
+// httpTemplateData is what URL and body templates are rendered with
+type httpTemplateData struct {
+	Host   string
+	Text   string
+	Voice  string
+	Speed  float64
+	Pitch  float64
+	Volume float64
+	Format string // file extension without the dot, e.g. "mp3"
+}
+
+var httpTemplateFuncs = template.FuncMap{
+	// json renders a value as a JSON literal, quoting and escaping strings
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// HTTPProvider implements the Provider interface for any engine with an HTTP
+// API, such as Piper, Coqui or OpenAI-compatible /v1/audio/speech servers
+type HTTPProvider struct {
+	name      string
+	catalog   *VoiceCatalog
+	host      string
+	authValue string
+	config    HTTPProviderConfig
+	url       *template.Template
+	body      *template.Template
+	client    *http.Client
+}
+
+// NewHTTPProvider creates a generic HTTP provider, validating its config and
+// compiling its templates
+func NewHTTPProvider(providerConfig ProviderConfig, env *ProviderEnv) (Provider, error) {
+	p, err := newHTTPProvider(providerConfig)
+	if err != nil {
+		return nil, err
+	}
+	p.catalog = env.catalog()
+	return p, nil
+}
+
+func newHTTPProvider(providerConfig ProviderConfig) (*HTTPProvider, error) {
+	if providerConfig.HTTP == nil {
+		return nil, fmt.Errorf("http settings are required")
+	}
+	config := *providerConfig.HTTP
+	if config.URL == "" {
+		return nil, fmt.Errorf("url is required")
+	}
+
+	switch config.ResponseMode {
+	case "":
+		config.ResponseMode = HTTPResponseRaw
+	case HTTPResponseRaw, HTTPResponseJSON:
+	default:
+		return nil, fmt.Errorf("unknown response mode %q", config.ResponseMode)
+	}
+	if config.ResponseMode == HTTPResponseJSON && config.AudioField == "" {
+		config.AudioField = "audio"
+	}
+	if config.Method == "" {
+		config.Method = http.MethodGet
+		if config.Body != "" {
+			config.Method = http.MethodPost
+		}
+	}
+	if config.Body != "" && config.ContentType == "" {
+		config.ContentType = "application/json"
+	}
+
+	p := &HTTPProvider{
+		name:      providerConfig.Name,
+		host:      providerConfig.Host,
+		authValue: providerConfig.Credentials[CredentialAuthValue],
+		config:    config,
+		client:    &http.Client{Timeout: providerConfig.Timeout()},
+	}
+
+	var err error
+	if p.url, err = template.New("url").Funcs(httpTemplateFuncs).Parse(config.URL); err != nil {
+		return nil, fmt.Errorf("url template: %w", err)
+	}
+	if config.Body != "" {
+		if p.body, err = template.New("body").Funcs(httpTemplateFuncs).Parse(config.Body); err != nil {
+			return nil, fmt.Errorf("body template: %w", err)
+		}
+	}
+
+	return p, nil
+}
+
+// Synthesize renders the configured request and returns the audio the
+// engine answers with, in whatever format it produces
+func (p *HTTPProvider) Synthesize(ctx context.Context, request SynthesisRequest) (*SynthesisResponse, error) {
+	log.Printf("HTTP TTS Request - Text: %q, Voice: %q", request.Text, request.VoiceID)
+
+	if strings.TrimSpace(request.Text) == "" {
+		return nil, fmt.Errorf("text cannot be empty")
+	}
+
+	data := httpTemplateData{
+		Host:   p.host,
+		Text:   request.Text,
+		Voice:  request.VoiceID,
+		Speed:  request.Speed,
+		Pitch:  request.Pitch,
+		Volume: request.Volume,
+		Format: strings.TrimPrefix(AudioFileExt(request.Format), "."),
+	}
+
+	var reqURL bytes.Buffer
+	if err := p.url.Execute(&reqURL, data); err != nil {
+		return nil, fmt.Errorf("render url template: %v", err)
+	}
+
+	var body io.Reader
+	if p.body != nil {
+		var buf bytes.Buffer
+		if err := p.body.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("render body template: %v", err)
+		}
+		body = &buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, p.config.Method, reqURL.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	if p.config.ContentType != "" && body != nil {
+		req.Header.Set("Content-Type", p.config.ContentType)
+	}
+	if p.config.AuthHeader != "" {
+		req.Header.Set(p.config.AuthHeader, p.authValue)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		log.Printf("Request failed: %v", err)
+		return nil, requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	if err := checkStatus(resp); err != nil {
+		log.Printf("Request failed: %v", err)
+		return nil, err
+	}
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, transientf("failed to read response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("upstream returned %s: %s", resp.Status, truncate(string(respBody), 200))
+	}
+
+	audio := respBody
+	mimeType := NormalizeMIMEType(resp.Header.Get("Content-Type"))
+	if p.config.ResponseMode == HTTPResponseJSON {
+		if audio, err = p.extractJSONAudio(respBody); err != nil {
+			return nil, err
+		}
+		mimeType = ""
+	}
+	if len(audio) == 0 {
+		return nil, fmt.Errorf("empty audio in response")
+	}
+
+	// Trust the Content-Type only when it names an audio format
+	if !strings.HasPrefix(mimeType, "audio/") {
+		mimeType = DetectAudioMIME(audio)
+	}
+
+	duration, err := AudioDuration(mimeType, audio)
+	if err != nil {
+		log.Printf("HTTP TTS: Could not determine duration of %s audio - %v", mimeType, err)
+	}
+
+	return &SynthesisResponse{
+		Audio:    audio,
+		MIMEType: mimeType,
+		Duration: duration,
+	}, nil
+}
+
+// extractJSONAudio follows the configured dot path to the base64 audio
+func (p *HTTPProvider) extractJSONAudio(body []byte) ([]byte, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %v", err)
+	}
+
+	for _, key := range strings.Split(p.config.AudioField, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("response has no field %q", p.config.AudioField)
+		}
+		if value, ok = object[key]; !ok {
+			return nil, fmt.Errorf("response has no field %q", p.config.AudioField)
+		}
+	}
+
+	encoded, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("response field %q is not a string", p.config.AudioField)
+	}
+	audio, err := DecodeAudioBase64(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %v", err)
+	}
+	return audio, nil
+}
+
+// GetVoiceIDs returns the configured voice IDs and those in the catalog
+func (p *HTTPProvider) GetVoiceIDs() []string {
+	ids := append([]string(nil), p.config.Voices...)
+	if p.catalog != nil {
+		ids = append(ids, p.catalog.VoiceIDs(p.name)...)
+	}
+	return ids
+}
+
+// ValidateVoiceID checks a voice ID against the configured list and the
+// catalog. With neither listing any voice, any non-empty ID is passed on to
+// the engine.
+func (p *HTTPProvider) ValidateVoiceID(voiceID string) bool {
+	if voiceID == "" {
+		return false
+	}
+	if p.catalog != nil && p.catalog.Has(voiceID, p.name) {
+		return true
+	}
+	for _, id := range p.config.Voices {
+		if id == voiceID {
+			return true
+		}
+	}
+	return len(p.GetVoiceIDs()) == 0
+}
+
+// SplitTuning lets the engine apply each setting the URL or body template
+// passes on, and leaves the rest to post-processing
+func (p *HTTPProvider) SplitTuning(t Tuning) (native, rest Tuning) {
+	native, rest = neutralTuning, t
+	templates := p.config.URL + p.config.Body
+	if strings.Contains(templates, ".Speed") {
+		native.Speed, rest.Speed = t.Speed, 1
+	}
+	if strings.Contains(templates, ".Pitch") {
+		native.Pitch, rest.Pitch = t.Pitch, 0
+	}
+	if strings.Contains(templates, ".Volume") {
+		native.Gain, rest.Gain = t.Gain, 0
+	}
+	return native, rest
+}
+
+// truncate shortens s to at most n bytes for logging
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
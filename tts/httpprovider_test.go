@@ -0,0 +1,179 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testWAV builds a 16 kHz mono 16-bit WAV holding the given number of samples
+func testWAV(samples int) []byte {
+	format := make([]byte, 16)
+	binary.LittleEndian.PutUint16(format[0:], 1)     // PCM
+	binary.LittleEndian.PutUint16(format[2:], 1)     // mono
+	binary.LittleEndian.PutUint32(format[4:], 16000) // sample rate
+	binary.LittleEndian.PutUint32(format[8:], 32000) // byte rate
+	binary.LittleEndian.PutUint16(format[12:], 2)    // block align
+	binary.LittleEndian.PutUint16(format[14:], 16)   // bits per sample
+
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(36+2*samples))
+	out.WriteString("WAVEfmt ")
+	binary.Write(&out, binary.LittleEndian, uint32(16))
+	out.Write(format)
+	out.WriteString("data")
+	binary.Write(&out, binary.LittleEndian, uint32(2*samples))
+	out.Write(make([]byte, 2*samples))
+	return out.Bytes()
+}
+
+func TestHTTPProviderRawAudio(t *testing.T) {
+	wav := testWAV(8000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			t.Errorf("method = %s, want GET", r.Method)
+		}
+		if got := r.URL.Query().Get("text"); got != "halo & dunia" {
+			t.Errorf("text = %q", got)
+		}
+		if got := r.URL.Query().Get("voice"); got != "id_ID-news" {
+			t.Errorf("voice = %q", got)
+		}
+		// Piper answers without a useful Content-Type
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(wav)
+	}))
+	defer server.Close()
+
+	p, err := newHTTPProvider(ProviderConfig{
+		Host: server.URL,
+		HTTP: &HTTPProviderConfig{
+			URL:    "{{.Host}}/?voice={{.Voice | urlquery}}&text={{.Text | urlquery}}",
+			Voices: []string{"id_ID-news"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := p.Synthesize(context.Background(), NewSynthesisRequest("halo & dunia", "id_ID-news"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.MIMEType != MIMETypeWAV {
+		t.Errorf("MIME type = %q, want %q", resp.MIMEType, MIMETypeWAV)
+	}
+	if resp.Duration != 500*time.Millisecond {
+		t.Errorf("duration = %v, want 500ms", resp.Duration)
+	}
+	if !bytes.Equal(resp.Audio, wav) {
+		t.Errorf("audio was modified")
+	}
+	if p.ValidateVoiceID("en_US-amy") {
+		t.Errorf("voice outside the configured list was accepted")
+	}
+}
+
+func TestHTTPProviderJSONResponse(t *testing.T) {
+	frame := make([]byte, 96)
+	copy(frame, headerMPEG2Mono)
+	mp3 := bytes.Repeat(frame, 3)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("auth header = %q", got)
+		}
+		if got := r.Header.Get("Content-Type"); got != "application/json" {
+			t.Errorf("content type = %q", got)
+		}
+		var body struct {
+			Input string  `json:"input"`
+			Voice string  `json:"voice"`
+			Speed float64 `json:"speed"`
+		}
+		data, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(data, &body); err != nil {
+			t.Fatalf("body %s is not valid JSON: %v", data, err)
+		}
+		if body.Input != `say "hi"` || body.Voice != "alloy" || body.Speed != 1 {
+			t.Errorf("body = %+v", body)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"result": map[string]string{"audio": base64.StdEncoding.EncodeToString(mp3)},
+		})
+	}))
+	defer server.Close()
+
+	p, err := newHTTPProvider(ProviderConfig{
+		Credentials: map[string]string{CredentialAuthValue: "Bearer secret"},
+		HTTP: &HTTPProviderConfig{
+			URL:          server.URL + "/v1/audio/speech",
+			Body:         `{"input":{{json .Text}},"voice":{{json .Voice}},"speed":{{.Speed}}}`,
+			AuthHeader:   "Authorization",
+			ResponseMode: HTTPResponseJSON,
+			AudioField:   "result.audio",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := p.Synthesize(context.Background(), NewSynthesisRequest(`say "hi"`, "alloy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.MIMEType != MIMETypeMP3 || resp.Duration != 72*time.Millisecond {
+		t.Errorf("got %s %v, want %s 72ms", resp.MIMEType, resp.Duration, MIMETypeMP3)
+	}
+}
+
+func TestHTTPProviderServerErrorIsTransient(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "model loading", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	p, err := newHTTPProvider(ProviderConfig{HTTP: &HTTPProviderConfig{URL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Synthesize(context.Background(), NewSynthesisRequest("hi", "any")); !IsTransient(err) {
+		t.Errorf("err = %v, want transient", err)
+	}
+}
+
+func TestNewHTTPProviderRejectsBadConfig(t *testing.T) {
+	configs := map[string]*HTTPProviderConfig{
+		"no http settings": nil,
+		"no url":           {},
+		"bad template":     {URL: "http://localhost/{{.Text"},
+		"unknown mode":     {URL: "http://localhost/", ResponseMode: "xml"},
+		"bad body tmpl":    {URL: "http://localhost/", Body: "{{json}"},
+	}
+	for name, config := range configs {
+		if _, err := newHTTPProvider(ProviderConfig{HTTP: config}); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestStitchWAV(t *testing.T) {
+	out, duration, err := StitchWAV([][]byte{testWAV(16000), testWAV(8000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duration != 1500*time.Millisecond {
+		t.Errorf("duration = %v, want 1.5s", duration)
+	}
+	if d, err := WAVDuration(out); err != nil || d != duration {
+		t.Errorf("stitched file measures %v (%v), want %v", d, err, duration)
+	}
+}
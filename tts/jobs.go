@@ -0,0 +1,135 @@
+package tts
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// TTSJobState is where a tts update is on its way to being spoken
+type TTSJobState string
+
+const (
+	JobPending   TTSJobState = "pending"   // waiting for synthesis
+	JobQueued    TTSJobState = "queued"    // synthesized, waiting in the queue
+	JobSpeaking  TTSJobState = "speaking"  // sent to the avatar
+	JobSpoken    TTSJobState = "spoken"    // the avatar finished playing it
+	JobSkipped   TTSJobState = "skipped"   // stopped while speaking
+	JobCancelled TTSJobState = "cancelled" // removed from the queue or cleared
+	JobBlocked   TTSJobState = "blocked"   // contains a blocked word
+	JobRejected  TTSJobState = "rejected"  // held back by moderation
+	JobFailed    TTSJobState = "failed"    // could not be synthesized or played
+)
+
+// ErrJobNotFound is returned for unknown or forgotten job IDs
+var ErrJobNotFound = errors.New("job not found")
+
+// TTSJob is the status of one tts update. Its ID is also the ID of its
+// queue item.
+type TTSJob struct {
+	ID            string      `json:"id"`
+	State         TTSJobState `json:"state"`
+	Priority      int         `json:"priority"`
+	Chatter       string      `json:"chatter,omitempty"` // {platform}/{author_id} of the message author
+	AvatarID      string      `json:"avatar_id,omitempty"`
+	VoiceID       string      `json:"voice_id,omitempty"`
+	Provider      string      `json:"provider,omitempty"`
+	Language      string      `json:"language,omitempty"` // detected language of the text
+	Error         string      `json:"error,omitempty"`
+	Reason        string      `json:"reason,omitempty"` // why a rejected job was rejected
+	QueuedAt      time.Time   `json:"queued_at"`
+	SynthesizedAt *time.Time  `json:"synthesized_at,omitempty"`
+	StartedAt     *time.Time  `json:"started_at,omitempty"`
+	FinishedAt    *time.Time  `json:"finished_at,omitempty"`
+}
+
+// jobTracker remembers the most recent jobs, forgetting the oldest ones
+// past limit
+type jobTracker struct {
+	mu    sync.Mutex
+	jobs  map[string]*TTSJob
+	order []string // job IDs, oldest first
+	limit int
+}
+
+func newJobTracker(limit int) *jobTracker {
+	return &jobTracker{
+		jobs:  make(map[string]*TTSJob),
+		limit: limit,
+	}
+}
+
+// add starts tracking a job
+func (t *jobTracker) add(job TTSJob) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.jobs[job.ID] = &job
+	t.order = append(t.order, job.ID)
+	for len(t.order) > t.limit {
+		delete(t.jobs, t.order[0])
+		t.order = t.order[1:]
+	}
+}
+
+// get returns a copy of a tracked job
+func (t *jobTracker) get(id string) (TTSJob, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	job, ok := t.jobs[id]
+	if !ok {
+		return TTSJob{}, false
+	}
+	return *job, true
+}
+
+// update applies fn to a tracked job, if it is still tracked
+func (t *jobTracker) update(id string, fn func(job *TTSJob)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if job, ok := t.jobs[id]; ok {
+		fn(job)
+	}
+}
+
+// count returns how many tracked jobs match
+func (t *jobTracker) count(match func(job TTSJob) bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	n := 0
+	for _, job := range t.jobs {
+		if match(*job) {
+			n++
+		}
+	}
+	return n
+}
+
+// finish moves a job to a final state. err, if set, is reported as the
+// reason.
+func (t *jobTracker) finish(id string, state TTSJobState, err error) {
+	now := time.Now()
+	t.update(id, func(job *TTSJob) {
+		job.State = state
+		job.FinishedAt = &now
+		if err != nil {
+			job.Error = err.Error()
+		}
+		var rejected *RejectedError
+		if errors.As(err, &rejected) {
+			job.Reason = rejected.Reason
+		}
+	})
+}
+
+// Job returns the status of a tts update by the ID /update returned
+func (tm *TTSMiddleware) Job(id string) (TTSJob, error) {
+	job, ok := tm.jobs.get(id)
+	if !ok {
+		return TTSJob{}, ErrJobNotFound
+	}
+	return job, nil
+}
@@ -0,0 +1,190 @@
+package tts
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/oristarium/orionchat/types"
+)
+
+// Languages DetectLanguage tells apart
+const (
+	LangIndonesian = "id"
+	LangEnglish    = "en"
+	LangJapanese   = "ja"
+	LangSpanish    = "es"
+	LangPortuguese = "pt"
+)
+
+// stopwords are words common in chat in each language. Words shared by
+// languages count for all of them, so they only add to the total.
+var stopwords = map[string][]string{
+	LangIndonesian: {
+		"aku", "kamu", "saya", "kita", "kami", "dia", "mereka", "kalian", "anda", "lu", "gue", "gw",
+		"yang", "yg", "dan", "di", "ke", "dari", "ini", "itu", "ada", "apa", "tidak", "gak", "ga",
+		"nggak", "enggak", "bisa", "mau", "juga", "sudah", "udah", "belum", "aja", "saja", "lagi",
+		"sama", "untuk", "buat", "dengan", "karena", "kalau", "kalo", "tapi", "jadi", "banget",
+		"dong", "sih", "deh", "kok", "nih", "tuh", "bang", "kak", "bro", "terus", "semangat",
+		"terima", "kasih", "makasih", "selamat", "pagi", "siang", "malam", "gimana", "kenapa",
+		"siapa", "mana", "kapan", "boleh", "harus", "akan", "lebih", "baru", "wkwk", "wkwkwk",
+	},
+	LangEnglish: {
+		"i", "you", "he", "she", "we", "they", "it", "me", "my", "your", "the", "a", "an", "and",
+		"or", "but", "is", "are", "was", "were", "be", "been", "have", "has", "do", "does", "did",
+		"not", "don't", "can", "can't", "will", "would", "what", "who", "how", "why", "where",
+		"when", "this", "that", "to", "of", "in", "on", "for", "with", "at", "from", "just", "so",
+		"very", "really", "thanks", "thank", "please", "hello", "hi", "love", "good", "great",
+		"lol", "omg", "bro", "yes", "no", "im", "i'm", "it's", "there", "here", "about",
+	},
+	LangSpanish: {
+		"yo", "tú", "tu", "él", "ella", "nosotros", "ellos", "usted", "el", "la", "los", "las",
+		"un", "una", "y", "o", "pero", "es", "son", "está", "estás", "esta", "estoy", "ser",
+		"que", "qué", "de", "del", "en", "con", "por", "para", "como", "cómo", "muy", "más",
+		"mas", "también", "no", "sí", "si", "gracias", "hola", "bueno", "buenas", "buenos",
+		"días", "noches", "amigo", "hermano", "jajaja", "jaja", "porque", "pues", "mi", "te",
+		"lo", "le", "se", "me", "ya", "aquí", "hay", "todo", "bien", "quiero", "puedo",
+	},
+	LangPortuguese: {
+		"eu", "você", "voce", "vc", "ele", "ela", "nós", "eles", "o", "a", "os", "as", "um",
+		"uma", "e", "ou", "mas", "é", "são", "está", "estou", "tá", "ser", "que", "de", "do",
+		"da", "dos", "das", "em", "no", "na", "com", "por", "para", "pra", "como", "muito",
+		"mais", "também", "não", "nao", "sim", "obrigado", "obrigada", "olá", "ola", "oi",
+		"bom", "boa", "dia", "noite", "cara", "mano", "kkkk", "kkk", "porque", "então",
+		"aqui", "tem", "tudo", "bem", "quero", "posso", "meu", "minha", "isso",
+	},
+}
+
+// stopwordLanguages maps each stopword to the languages it belongs to
+var stopwordLanguages = func() map[string][]string {
+	m := make(map[string][]string)
+	for lang, words := range stopwords {
+		for _, word := range words {
+			m[word] = append(m[word], lang)
+		}
+	}
+	return m
+}()
+
+// letterHints are letters only one of the Latin-script languages uses
+var letterHints = map[rune]string{
+	'ñ': LangSpanish, '¿': LangSpanish, '¡': LangSpanish,
+	'ã': LangPortuguese, 'õ': LangPortuguese, 'ç': LangPortuguese, 'ê': LangPortuguese, 'â': LangPortuguese,
+}
+
+// DetectLanguage guesses the language of a chat message, without any
+// network access. confidence runs from 0, nothing recognized, to 1; a
+// single recognized word gives at most 0.5. lang is "" when nothing was
+// recognized.
+func DetectLanguage(text string) (lang string, confidence float64) {
+	// Kana only occurs in Japanese. Han alone might be Chinese.
+	var kana, han, letters int
+	for _, r := range text {
+		switch {
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			kana++
+		case unicode.Is(unicode.Han, r):
+			han++
+		case unicode.IsLetter(r):
+			letters++
+		}
+	}
+	if kana > 0 && kana+han >= letters {
+		return LangJapanese, 1
+	}
+	if han > 0 && han >= letters {
+		return LangJapanese, 0.4
+	}
+
+	scores := make(map[string]int)
+	seen := make(map[rune]bool)
+	for _, r := range strings.ToLower(text) {
+		if lang, ok := letterHints[r]; ok && !seen[r] {
+			seen[r] = true
+			scores[lang]++
+		}
+	}
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for _, word := range words {
+		for _, lang := range stopwordLanguages[word] {
+			scores[lang]++
+		}
+	}
+
+	best, second := 0, 0
+	for _, candidate := range []string{LangIndonesian, LangEnglish, LangSpanish, LangPortuguese} {
+		switch score := scores[candidate]; {
+		case score > best:
+			lang, best, second = candidate, score, best
+		case score > second:
+			second = score
+		}
+	}
+	if best == 0 {
+		return "", 0
+	}
+	confidence = float64(best-second) / float64(best) * min(1, float64(best)/2)
+	return lang, confidence
+}
+
+// languageLabels maps catalog lang_label values to language codes
+var languageLabels = map[string]string{
+	"indonesian": LangIndonesian,
+	"english":    LangEnglish,
+	"japanese":   LangJapanese,
+	"spanish":    LangSpanish,
+	"portuguese": LangPortuguese,
+}
+
+// voiceIDPrefixes maps the language prefix of voice IDs, such as the "jp"
+// of TikTok's "jp_001", to language codes
+var voiceIDPrefixes = map[string]string{
+	"id": LangIndonesian,
+	"en": LangEnglish,
+	"ja": LangJapanese,
+	"jp": LangJapanese,
+	"es": LangSpanish,
+	"pt": LangPortuguese,
+	"br": LangPortuguese,
+}
+
+// voiceLanguage returns the language a voice speaks, from its catalog entry
+// or else its ID, "" when neither tells
+func (tm *TTSMiddleware) voiceLanguage(voice types.TTSVoice) string {
+	if tm.service != nil {
+		if catalog := tm.service.providers.Catalog(); catalog != nil {
+			if entry, ok := catalog.Get(voice.Provider, voice.VoiceID); ok {
+				if lang, ok := languageLabels[strings.ToLower(entry.LangLabel)]; ok {
+					return lang
+				}
+			}
+		}
+	}
+	return voiceIDLanguage(voice.VoiceID)
+}
+
+// voiceIDLanguage returns the language a voice ID starts with, such as "id"
+// for "id_male_darma" or "en" for "en-US", "" when it does not tell
+func voiceIDLanguage(voiceID string) string {
+	prefix, _, _ := strings.Cut(strings.ToLower(voiceID), "_")
+	prefix, _, _ = strings.Cut(prefix, "-")
+	return voiceIDPrefixes[prefix]
+}
+
+// voicesForText returns the voices that speak the language of text, and
+// that language. It returns no voices when the language is not detected
+// with LanguageMinConfidence, or none of the voices speaks it.
+func (tm *TTSMiddleware) voicesForText(text string, voices []types.TTSVoice) ([]types.TTSVoice, string) {
+	lang, confidence := DetectLanguage(text)
+	if lang == "" || confidence < LanguageMinConfidence {
+		return nil, ""
+	}
+	var matching []types.TTSVoice
+	for _, voice := range voices {
+		if tm.voiceLanguage(voice) == lang {
+			matching = append(matching, voice)
+		}
+	}
+	return matching, lang
+}
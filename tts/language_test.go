@@ -0,0 +1,113 @@
+package tts
+
+import (
+	"testing"
+
+	"github.com/oristarium/orionchat/types"
+)
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"semangat terus bang, aku udah nonton dari pagi", LangIndonesian},
+		{"gak bisa gitu dong kak wkwk", LangIndonesian},
+		{"this is the best stream I have seen in a while", LangEnglish},
+		{"thank you so much for the shoutout!", LangEnglish},
+		{"こんにちは、配信ありがとう！", LangJapanese},
+		{"ラーメン食べたい", LangJapanese},
+		{"hola amigo, ¿cómo estás? muy bueno el stream", LangSpanish},
+		{"muchas gracias por todo hermano", LangSpanish},
+		{"oi mano, você está muito bem hoje", LangPortuguese},
+		{"não acredito, isso é demais kkkk", LangPortuguese},
+	}
+	for _, tt := range tests {
+		lang, confidence := DetectLanguage(tt.text)
+		if lang != tt.want || confidence < LanguageMinConfidence {
+			t.Errorf("DetectLanguage(%q) = %s (%.2f), want %s", tt.text, lang, confidence, tt.want)
+		}
+	}
+
+	for _, text := range []string{"", "GG", "🔥🔥🔥", "hi", "12345", "xqzv plmk"} {
+		if lang, confidence := DetectLanguage(text); confidence >= LanguageMinConfidence {
+			t.Errorf("DetectLanguage(%q) = %s (%.2f), want low confidence", text, lang, confidence)
+		}
+	}
+}
+
+func TestVoiceFollowsMessageLanguage(t *testing.T) {
+	tm := newTestQueue(t)
+	indonesian := types.TTSVoice{VoiceID: "id_male_darma", Provider: "tiktok"}
+	english := types.TTSVoice{VoiceID: "en_us_001", Provider: "tiktok"}
+	japanese := types.TTSVoice{VoiceID: "ja", Provider: "google"}
+	voices := []types.TTSVoice{indonesian, english, japanese}
+
+	pick := func(text string) string {
+		matching, _ := tm.voicesForText(text, voices)
+		voice, err := tm.chatterVoice(nil, "avatar", voices, matching)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return voice.VoiceID
+	}
+	if got := pick("thank you so much for the stream"); got != english.VoiceID {
+		t.Errorf("English message got %s", got)
+	}
+	if got := pick("makasih banyak bang, semangat terus"); got != indonesian.VoiceID {
+		t.Errorf("Indonesian message got %s", got)
+	}
+	if got := pick("ありがとうございます"); got != japanese.VoiceID {
+		t.Errorf("Japanese message got %s", got)
+	}
+	// Unsure, or no voice speaks it: the avatar's first voice
+	if got := pick("GG"); got != indonesian.VoiceID {
+		t.Errorf("short message got %s", got)
+	}
+	if got := pick("hola amigo, muchas gracias por todo"); got != indonesian.VoiceID {
+		t.Errorf("Spanish message without a Spanish voice got %s", got)
+	}
+
+	// A regular keeps their voice, except for messages it cannot speak
+	db, _ := openTestDB(t)
+	defer db.Close()
+	chatters, err := NewChatterMap(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.chatters = chatters
+	chatters.Save(ChatterAssignment{Platform: "youtube", AuthorID: "youtube-1", AvatarID: "avatar",
+		VoiceID: indonesian.VoiceID, Provider: indonesian.Provider})
+	msg := chatMessage("youtube-1", "")
+	for text, want := range map[string]string{
+		"GG":                               indonesian.VoiceID,
+		"semangat terus bang":              indonesian.VoiceID,
+		"thank you so much for the stream": english.VoiceID,
+	} {
+		matching, _ := tm.voicesForText(text, voices)
+		if voice, _ := tm.chatterVoice(msg, "avatar", voices, matching); voice.VoiceID != want {
+			t.Errorf("regular's %q got %s, want %s", text, voice.VoiceID, want)
+		}
+	}
+	if assignment, _ := chatters.Get("youtube", "youtube-1"); assignment.VoiceID != indonesian.VoiceID {
+		t.Errorf("regular's voice changed to %s", assignment.VoiceID)
+	}
+}
+
+func TestVoiceLanguage(t *testing.T) {
+	tm := newTestQueue(t)
+	tests := map[string]string{
+		"id_female_icha":  LangIndonesian,
+		"en_us_ghostface": LangEnglish,
+		"jp_001":          LangJapanese,
+		"es_mx_002":       LangSpanish,
+		"br_003":          LangPortuguese,
+		"pt-BR":           LangPortuguese,
+		"fr_001":          "",
+	}
+	for voiceID, want := range tests {
+		if got := tm.voiceLanguage(types.TTSVoice{VoiceID: voiceID, Provider: "tiktok"}); got != want {
+			t.Errorf("voiceLanguage(%s) = %q, want %q", voiceID, got, want)
+		}
+	}
+}
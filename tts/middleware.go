@@ -2,31 +2,37 @@ package tts
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
-	"encoding/base64"
-
 	"github.com/gorilla/websocket"
 	"github.com/oristarium/orionchat/types"
+	"go.etcd.io/bbolt"
 )
 
 // TTSQueueItem represents an item in the TTS queue
 type TTSQueueItem struct {
-	Data     map[string]interface{}
-	AvatarID string
-	BlobURL  string
-	VoiceID  string
-	Provider string
+	ID       string                 `json:"id"`
+	Data     map[string]interface{} `json:"data"`
+	AvatarID string                 `json:"avatar_id"`
+	BlobURL  string                 `json:"blob_url"`
+	VoiceID  string                 `json:"voice_id"`
+	Provider string                 `json:"provider"`
+	Priority int                    `json:"priority"`        // lane, see MessagePriority
+	QueuedAt time.Time              `json:"queued_at"`       // when the update arrived, used for aging
+	Boost    float64                `json:"boost,omitempty"` // added to the priority when moved through the API
+	Duration time.Duration          `json:"duration,omitempty"` // length of the clip, 0 if unknown
+
+	StartedAt time.Time `json:"-"` // when it was sent to the avatar, not kept across restarts
 }
 
 var upgrader = websocket.Upgrader{
@@ -46,38 +52,111 @@ type TTSMiddleware struct {
 	clientsMux  sync.RWMutex
 	blobDir     string
 	
+	// Queued items and the one being spoken are kept in db until spoken.
+	// Changes are collected in storeOps under queueMux and written by
+	// flushQueueStore, one flush at a time.
+	db          *bbolt.DB
+	storeOps    []queueStoreOp
+	storeMux    sync.Mutex
+	flushMux    sync.Mutex
+
 	// Queue management
 	queue       []TTSQueueItem
 	queueMux    sync.Mutex
-	isSpeaking  bool
+	speaking    map[string]*TTSQueueItem // items being spoken, by avatar ID
+	playback    PlaybackSettings
+	paused      bool
+
+	// Queue changes are reported to onQueueChange from a single goroutine,
+	// coalescing bursts
+	onQueueChange func(TTSQueueSnapshot)
+	queueChanged  chan struct{}
 
-	// Avatar selection tracking
-	lastUsedAvatars []string
-	maxLastUsed     int
+	// An item not reported finished within its length plus speakingGrace
+	// is given up on, see watchItem
+	speakingGrace time.Duration
+	onWatchdog    func(TTSWatchdogEvent)
+
+	// Avatar selection, see selectAvatar
+	selection     avatarSelection
+	avatarHistory *avatarHistory
+	selectMux     sync.Mutex
 
 	// Cleanup channel
 	cleanupChan chan cleanupJob
+
+	// Synthesis goes through the service so it shares its audio cache
+	service *TTSService
+
+	// Updates are synthesized ahead of playback by the pool. avatarVoices
+	// returns the voices configured for an avatar.
+	synth        *synthPool
+	avatarVoices func(avatarID string) ([]types.TTSVoice, error)
+
+	// Avatar and voice of each chatter, nil without a database
+	chatters *ChatterMap
+
+	// Per-chatter limits, see moderate
+	moderator *moderator
+
+	// Status of recent tts updates, see Job
+	jobs *jobTracker
+
+	// In-flight synthesis is bound to synthCtx so clear_tts can cancel it
+	synthCtx    context.Context
+	synthCancel context.CancelFunc
+	synthMux    sync.Mutex
 }
 
-func NewTTSMiddleware() *TTSMiddleware {
-	blobDir := filepath.Join(os.TempDir(), "tts_blobs")
-	os.MkdirAll(blobDir, 0755)
+// NewTTSMiddleware creates the avatar TTS queue. Audio is kept in blobDir and
+// the queue in db, and items stored by an earlier run are restored unless
+// they are older than maxAge (0 keeps them all).
+func NewTTSMiddleware(service *TTSService, db *bbolt.DB, blobDir string, maxAge time.Duration) (*TTSMiddleware, error) {
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return nil, fmt.Errorf("create blob directory: %w", err)
+	}
 
 	tm := &TTSMiddleware{
 		clients:         make(map[*websocket.Conn]string),
 		avatarIds:       make(map[string]bool),
 		blobDir:        blobDir,
+		db:             db,
 		queue:          make([]TTSQueueItem, 0),
-		isSpeaking:     false,
-		maxLastUsed:    3,
-		lastUsedAvatars: make([]string, 0),
+		speaking:       make(map[string]*TTSQueueItem),
 		cleanupChan:    make(chan cleanupJob, 100), // Buffer for cleanup requests
+		queueChanged:   make(chan struct{}, 1),
+		speakingGrace:  SpeakingGracePeriod,
+		service:        service,
+		synth:          newSynthPool(SynthesisWorkers, SynthesisPerProvider, MaxPendingSynthesis),
+		jobs:           newJobTracker(MaxTrackedJobs),
+	}
+	tm.avatarVoices = tm.getAvatarVoices
+	tm.synthCtx, tm.synthCancel = context.WithCancel(context.Background())
+
+	chatters, err := NewChatterMap(db)
+	if err != nil {
+		return nil, fmt.Errorf("open chatter assignments: %w", err)
+	}
+	tm.chatters = chatters
+
+	if err := tm.loadPlayback(); err != nil {
+		return nil, fmt.Errorf("load playback settings: %w", err)
+	}
+	if err := tm.loadAvatarSelection(); err != nil {
+		return nil, fmt.Errorf("load avatar selection: %w", err)
+	}
+	if err := tm.loadModeration(); err != nil {
+		return nil, fmt.Errorf("load moderation settings: %w", err)
+	}
+	if err := tm.restoreQueue(maxAge); err != nil {
+		return nil, fmt.Errorf("restore queue: %w", err)
 	}
 
 	// Start cleanup goroutine
 	go tm.cleanupWorker()
+	go tm.notifyWorker()
 
-	return tm
+	return tm, nil
 }
 
 // cleanupWorker handles blob deletion in a separate goroutine
@@ -150,39 +229,90 @@ func (tm *TTSMiddleware) queueCleanup(blobPath string, delay time.Duration) {
 	}
 }
 
-// processNextInQueue processes the next item in the queue if available
+// processNextInQueue starts queued items for as long as the playback
+// settings allow another speaker
 func (tm *TTSMiddleware) processNextInQueue() {
+	for tm.startNextInQueue() {
+	}
+}
+
+// startNextInQueue sends the next item to its avatar and reports whether an
+// item was taken off the queue. An avatar that is already speaking is
+// passed over, so in concurrent mode each avatar works through its own
+// messages in order.
+func (tm *TTSMiddleware) startNextInQueue() bool {
 	tm.queueMux.Lock()
-	if tm.isSpeaking || len(tm.queue) == 0 {
+	if tm.paused || len(tm.speaking) >= tm.playback.limit() {
 		tm.queueMux.Unlock()
-		return
+		return false
 	}
 
-	// Get next item and mark as speaking
-	item := tm.queue[0]
-	tm.queue = tm.queue[1:]
-	tm.isSpeaking = true
-	queueLength := len(tm.queue)
+	// Take the highest priority item and mark its avatar as speaking
+	next := nextQueueIndex(tm.queue, time.Now(), func(item TTSQueueItem) bool {
+		return tm.speaking[item.AvatarID] == nil
+	})
+	if next == -1 {
+		tm.queueMux.Unlock()
+		return false
+	}
+	item := tm.queue[next]
+	item.StartedAt = time.Now()
+	tm.queue = append(tm.queue[:next], tm.queue[next+1:]...)
+	tm.speaking[item.AvatarID] = &item
+	queueLength, speakers := len(tm.queue), len(tm.speaking)
 	tm.queueMux.Unlock()
+	tm.queueUpdated()
 
-	log.Printf("Queue: Processing next item - Avatar: %s, Queue length: %d", 
-		item.AvatarID, queueLength)
-
-	// Update last used avatars list
-	tm.updateLastUsedAvatars(item.AvatarID)
+	log.Printf("Queue: Processing next item - Avatar: %s, Priority: %d, Waited: %v, Queue length: %d, Speaking: %d", 
+		item.AvatarID, item.Priority, time.Since(item.QueuedAt).Round(time.Millisecond), queueLength, speakers)
 
 	// Prepare WebSocket message
 	message := map[string]interface{}{
 		"signal":       "avatar_speak",
 		"content":      item.Data["content"],
 		"avatar_audio": item.BlobURL,
+		"voice_id":     item.VoiceID,
+		"provider":     item.Provider,
 	}
 
 	// Send to matching clients
-	tm.clientsMux.RLock()
+	if tm.sendToAvatar(item.AvatarID, message) {
+		now := time.Now()
+		tm.jobs.update(item.ID, func(job *TTSJob) {
+			job.State = JobSpeaking
+			job.StartedAt = &now
+		})
+		tm.watchItem(item)
+		return true
+	}
+
+	log.Printf("Queue: No matching clients found for avatar %s, skipping audio", 
+		item.AvatarID)
+
+	// Clean up the blob since it won't be used
+	tm.queueCleanup(tm.blobPath(item), 0)
+	tm.jobs.finish(item.ID, JobFailed, fmt.Errorf("avatar %s is not connected", item.AvatarID))
+
+	// Mark the avatar as not speaking, the caller moves on to the next item
+	tm.queueMux.Lock()
+	tm.unstoreQueueItems(item)
+	delete(tm.speaking, item.AvatarID)
+	tm.queueMux.Unlock()
+	tm.flushQueueStore()
+	tm.queueUpdated()
+	return true
+}
+
+// sendToAvatar sends a message to every client of an avatar and reports
+// whether any received it. Writes are serialized by the clients lock, since
+// a websocket connection allows only one writer at a time.
+func (tm *TTSMiddleware) sendToAvatar(avatarID string, message interface{}) bool {
+	tm.clientsMux.Lock()
+	defer tm.clientsMux.Unlock()
+
 	sent := false
 	for client, avatarId := range tm.clients {
-		if avatarId == item.AvatarID {
+		if avatarId == avatarID {
 			if err := client.WriteJSON(message); err != nil {
 				log.Printf("Queue: Error sending to client - %v", err)
 				continue
@@ -191,75 +321,49 @@ func (tm *TTSMiddleware) processNextInQueue() {
 			log.Printf("Queue: Sent message to avatar %s", avatarId)
 		}
 	}
-	tm.clientsMux.RUnlock()
-
-	if !sent {
-		log.Printf("Queue: No matching clients found for avatar %s, skipping audio", 
-			item.AvatarID)
-		
-		// Clean up the blob since it won't be used
-		filename := filepath.Base(item.BlobURL)
-		blobPath := filepath.Join(tm.blobDir, filename)
-		tm.queueCleanup(blobPath, 0)
-
-		// Mark as not speaking and process next item
-		tm.queueMux.Lock()
-		tm.isSpeaking = false
-		tm.queueMux.Unlock()
-
-		// Try next item
-		tm.processNextInQueue()
-	}
+	return sent
 }
 
-// getAudioBlob fetches TTS audio and stores it as a temporary blob
-func (tm *TTSMiddleware) getAudioBlob(text string, voiceId string, provider string) (string, error) {
-	// Prepare TTS request
-	ttsReq := map[string]interface{}{
-		"text":           text,
-		"voice_id":       voiceId,
-		"voice_provider": provider,
-	}
-
-	jsonData, err := json.Marshal(ttsReq)
+// getAudioBlob synthesizes TTS audio and stores it as a temporary blob.
+// It also returns the synthesis result, whose voice is the one that
+// actually produced the audio, which differs from the requested one when a
+// fallback was used.
+func (tm *TTSMiddleware) getAudioBlob(ctx context.Context, text string, voice types.TTSVoice) (string, SynthesisResult, error) {
+	result, err := tm.service.SynthesizeText(ctx, text, VoiceChain(voice))
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal TTS request: %v", err)
+		return "", result, err
 	}
 
-	// Make request to TTS service
-	resp, err := http.Post("http://localhost:7777/tts-service", "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch TTS audio: %v", err)
-	}
-	defer resp.Body.Close()
-
-	var ttsResp struct {
-		Audio string `json:"audio"` // base64 encoded audio
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&ttsResp); err != nil {
-		return "", fmt.Errorf("failed to decode TTS response: %v", err)
+	// Validate audio data
+	if len(result.Audio) < 4 {
+		return "", result, fmt.Errorf("invalid audio data: too short")
 	}
 
-	// Remove any potential data URL prefix (e.g., "data:audio/mp3;base64,")
-	base64Data := ttsResp.Audio
-	if idx := strings.Index(base64Data, ","); idx != -1 {
-		base64Data = base64Data[idx+1:]
-	}
+	blobURL, err := tm.writeBlob(result.Audio, result.MIMEType)
+	return blobURL, result, err
+}
 
-	// Decode base64 to raw audio bytes
-	audioData, err := base64.StdEncoding.DecodeString(base64Data)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 audio: %v", err)
-	}
+// synthesisContext returns the context new synthesis work should run under
+func (tm *TTSMiddleware) synthesisContext() context.Context {
+	tm.synthMux.Lock()
+	defer tm.synthMux.Unlock()
+	return tm.synthCtx
+}
 
-	// Validate audio data
-	if len(audioData) < 4 {
-		return "", fmt.Errorf("invalid audio data: too short")
-	}
+// cancelSynthesis aborts all in-flight synthesis and starts a fresh context
+// for work that arrives afterwards
+func (tm *TTSMiddleware) cancelSynthesis() {
+	tm.synthMux.Lock()
+	tm.synthCancel()
+	tm.synthCtx, tm.synthCancel = context.WithCancel(context.Background())
+	tm.synthMux.Unlock()
+}
 
+// writeBlob stores raw audio as a temporary blob and returns its URL
+func (tm *TTSMiddleware) writeBlob(audioData []byte, mimeType string) (string, error) {
 	// Create temporary file with proper permissions
 	blobFile, err := os.OpenFile(
-		filepath.Join(tm.blobDir, fmt.Sprintf("tts_%d.mp3", time.Now().UnixNano())),
+		filepath.Join(tm.blobDir, fmt.Sprintf("tts_%d%s", time.Now().UnixNano(), AudioFileExt(mimeType))),
 		os.O_WRONLY|os.O_CREATE|os.O_TRUNC,
 		0644,
 	)
@@ -274,15 +378,14 @@ func (tm *TTSMiddleware) getAudioBlob(text string, voiceId string, provider stri
 		return "", fmt.Errorf("failed to write audio data: %v", err)
 	}
 
-	// Schedule cleanup after 5 minutes
-	tm.queueCleanup(blobFile.Name(), 5*time.Minute)
+	// The blob is removed once its item is spoken or dropped
 
 	// Return the blob URL
 	return fmt.Sprintf("/tts-blob/%s", filepath.Base(blobFile.Name())), nil
 }
 
-// getRandomAvatarVoice fetches voice details for a given avatar ID
-func (tm *TTSMiddleware) getRandomAvatarVoice(avatarId string) (map[string]interface{}, error) {
+// getAvatarVoices fetches the voices of a given avatar ID
+func (tm *TTSMiddleware) getAvatarVoices(avatarId string) ([]types.TTSVoice, error) {
 	// Make internal request to get avatar details
 	url := fmt.Sprintf("http://localhost:7777/api/avatars/%s/get", avatarId)
 	resp, err := http.Get(url)
@@ -301,20 +404,7 @@ func (tm *TTSMiddleware) getRandomAvatarVoice(avatarId string) (map[string]inter
 		return nil, fmt.Errorf("failed to unmarshal avatar data: %v", err)
 	}
 
-	// Check if there are any TTS voices
-	if len(avatar.TTSVoices) == 0 {
-		return nil, fmt.Errorf("avatar has no TTS voices")
-	}
-
-	// Pick a random TTS voice
-	randomVoice := avatar.TTSVoices[rand.Intn(len(avatar.TTSVoices))]
-
-	// Create response with avatar ID and voice details
-	return map[string]interface{}{
-		"avatar_id": avatarId,
-		"voice_id":  randomVoice.VoiceID,
-		"provider":  randomVoice.Provider,
-	}, nil
+	return avatar.TTSVoices, nil
 }
 
 // HandleWebSocket handles new WebSocket connections
@@ -347,14 +437,6 @@ func (tm *TTSMiddleware) HandleWebSocket(w http.ResponseWriter, r *http.Request)
 	// Schedule queue processing after 4 seconds
 	go func() {
 		time.Sleep(4 * time.Second)
-		tm.queueMux.Lock()
-		if tm.isSpeaking {
-			log.Printf("Queue: New client connected but queue is already speaking")
-			tm.queueMux.Unlock()
-			return
-		}
-		tm.queueMux.Unlock()
-		
 		log.Printf("Queue: Processing queue after new client connection delay")
 		tm.processNextInQueue()
 	}()
@@ -386,6 +468,11 @@ func (tm *TTSMiddleware) HandleWebSocket(w http.ResponseWriter, r *http.Request)
 			
 			log.Printf("Queue: WebSocket client disconnected - Avatar: %s, Remaining avatars: %d", 
 				avatarId, remainingAvatars)
+
+			// Nobody is left to report the current item finished
+			if lastConnection {
+				tm.avatarLeft(avatarId)
+			}
 			return
 		}
 
@@ -407,11 +494,24 @@ func (tm *TTSMiddleware) HandleWebSocket(w http.ResponseWriter, r *http.Request)
 				blobPath := filepath.Join(tm.blobDir, filename)
 				tm.queueCleanup(blobPath, 0) // immediate cleanup
 
-				// Mark as not speaking and process next item
+				// Mark the avatar as not speaking and process next item. A
+				// late signal for an item that was skipped is ignored.
 				tm.queueMux.Lock()
-				tm.isSpeaking = false
+				current := tm.speaking[avatarId]
+				if current != nil && current.BlobURL != blobURL {
+					tm.queueMux.Unlock()
+					log.Printf("Queue: Ignoring avatar_finished for %s, no longer speaking", blobURL)
+					continue
+				}
+				if current != nil {
+					tm.unstoreQueueItems(*current)
+					tm.jobs.finish(current.ID, JobSpoken, nil)
+					delete(tm.speaking, avatarId)
+				}
 				queueLength := len(tm.queue)
 				tm.queueMux.Unlock()
+				tm.flushQueueStore()
+				tm.queueUpdated()
 				
 				log.Printf("Queue: Avatar finished speaking - Avatar: %s, Remaining in queue: %d", 
 					avatarId, queueLength)
@@ -442,81 +542,39 @@ func (tm *TTSMiddleware) GetConnectedAvatars() []string {
 	return avatars
 }
 
-// getRandomAvatarWithWeights selects an avatar with reduced probability for recently used ones
-func (tm *TTSMiddleware) getRandomAvatarWithWeights() string {
-	avatars := tm.GetConnectedAvatars()
-	if len(avatars) == 0 {
-		return ""
-	}
-
-	// If we only have one avatar, just return it
-	if len(avatars) == 1 {
-		return avatars[0]
-	}
-
-	// Create a map of weights for each avatar
-	weights := make(map[string]int)
-	for _, avatar := range avatars {
-		weights[avatar] = 100 // Base weight
-	}
-
-	// Reduce weights for recently used avatars
-	for i, avatar := range tm.lastUsedAvatars {
-		if _, exists := weights[avatar]; exists {
-			// More recent avatars get bigger weight reduction
-			reduction := 30 * (len(tm.lastUsedAvatars) - i)
-			weights[avatar] = max(10, weights[avatar] - reduction) // Minimum weight of 10
-		}
-	}
-
-	// Calculate total weight
-	totalWeight := 0
-	for _, weight := range weights {
-		totalWeight += weight
-	}
-
-	// Pick a random number between 0 and total weight
-	r := rand.Intn(totalWeight)
-	
-	// Find the avatar that corresponds to this weight
-	currentWeight := 0
-	for avatar, weight := range weights {
-		currentWeight += weight
-		if r < currentWeight {
-			// Update last used avatars
-			tm.lastUsedAvatars = append(tm.lastUsedAvatars, avatar)
-			if len(tm.lastUsedAvatars) > tm.maxLastUsed {
-				tm.lastUsedAvatars = tm.lastUsedAvatars[1:]
-			}
-			return avatar
-		}
-	}
-
-	// Fallback to first avatar (shouldn't happen)
-	return avatars[0]
-}
-
-// InterceptTTS handles TTS updates and returns whether the update should be broadcasted
-func (tm *TTSMiddleware) InterceptTTS(updateType string, data interface{}) bool {
+// InterceptTTS handles TTS updates and returns whether the update should be broadcasted.
+// priority is the update's explicit queue lane, nil to derive it from the message,
+// and avatarID the avatar it asks to be spoken by, see SelectExplicit.
+// For tts updates it also returns the ID to look the update up by with Job.
+func (tm *TTSMiddleware) InterceptTTS(updateType string, data interface{}, priority *int, avatarID string) (string, bool) {
 	// Handle clear_tts command
 	if updateType == "clear_tts" {
+		// Stop synthesis still in flight so it never reaches the queue
+		tm.cancelSynthesis()
+
 		tm.queueMux.Lock()
 		queueLength := len(tm.queue)
 		
 		// Clean up blobs for all queued items
-		for _, item := range tm.queue {
-			filename := filepath.Base(item.BlobURL)
-			blobPath := filepath.Join(tm.blobDir, filename)
-			tm.queueCleanup(blobPath, 0)
+		cleared := tm.queue
+		for _, item := range tm.speaking {
+			cleared = append(cleared, *item)
 		}
+		for _, item := range cleared {
+			tm.queueCleanup(tm.blobPath(item), 0)
+			tm.jobs.finish(item.ID, JobCancelled, errCleared)
+		}
+		tm.unstoreQueueItems(cleared...)
 		
 		// Clear the queue and reset speaking state
 		tm.queue = make([]TTSQueueItem, 0)
-		tm.isSpeaking = false
+		tm.speaking = make(map[string]*TTSQueueItem)
 		tm.queueMux.Unlock()
+		tm.flushQueueStore()
+		tm.queueUpdated()
 		
 		log.Printf("Queue: Cleared %d items from queue due to clear_tts signal", queueLength)
-		return true // Allow the clear signal to be broadcasted
+		return "", true // Allow the clear signal to be broadcasted
 	}
 
 	// Handle TTS updates
@@ -524,24 +582,6 @@ func (tm *TTSMiddleware) InterceptTTS(updateType string, data interface{}) bool
 		startTime := time.Now()
 		log.Printf("Queue: New TTS update received at %s", startTime.Format(time.RFC3339))
 
-		// Get list of connected avatars
-		avatars := tm.GetConnectedAvatars()
-		if len(avatars) == 0 {
-			log.Printf("Queue: No connected avatars available for TTS")
-			return false
-		}
-
-		// Pick an avatar using weighted random selection
-		chosenAvatarId := tm.getRandomAvatarWithWeights()
-		if chosenAvatarId == "" {
-			log.Printf("Queue: Failed to select an avatar")
-			return false
-		}
-		
-		recentAvatars := strings.Join(tm.lastUsedAvatars, ", ")
-		log.Printf("Queue: Selected avatar %s from %d connected avatars (Recent avatars: %s)", 
-			chosenAvatarId, len(avatars), recentAvatars)
-		
 		// Create enriched data with the original data
 		enrichedData := make(map[string]interface{})
 		if originalData, ok := data.(map[string]interface{}); ok {
@@ -550,14 +590,25 @@ func (tm *TTSMiddleware) InterceptTTS(updateType string, data interface{}) bool
 			}
 		}
 
-		// Get voice details for the chosen avatar
-		avatar, err := tm.getRandomAvatarVoice(chosenAvatarId)
-		if err != nil {
-			log.Printf("Queue: Failed to get voice details for avatar %s - %v", chosenAvatarId, err)
-			return false
+		// Track the update from here on, so every outcome can be looked up
+		jobID := newQueueItemID()
+		lane := MessagePriority(data, priority)
+		chatter := messageChatter(enrichedData)
+		tm.jobs.add(TTSJob{
+			ID:       jobID,
+			State:    JobPending,
+			Priority: lane,
+			Chatter:  chatter,
+			QueuedAt: startTime,
+		})
+
+		// Get list of connected avatars
+		avatars := tm.GetConnectedAvatars()
+		if len(avatars) == 0 {
+			log.Printf("Queue: No connected avatars available for TTS")
+			tm.jobs.finish(jobID, JobFailed, errors.New("no avatar is connected"))
+			return jobID, false
 		}
-		log.Printf("Queue: Selected voice %s (%s) for avatar %s", 
-			avatar["voice_id"], avatar["provider"], chosenAvatarId)
 
 		// Extract text from the message
 		var messageText string
@@ -569,59 +620,154 @@ func (tm *TTSMiddleware) InterceptTTS(updateType string, data interface{}) bool
 
 		if messageText == "" {
 			log.Printf("Queue: No text content found in message")
-			return false
+			tm.jobs.finish(jobID, JobFailed, errors.New("message has no text"))
+			return jobID, false
 		}
-		log.Printf("Queue: Processing message text (length: %d characters)", len(messageText))
 
-		// Get audio blob URL
-		blobURL, err := tm.getAudioBlob(messageText, avatar["voice_id"].(string), avatar["provider"].(string))
+		// Hold back chatters over their limits
+		admitted, err := tm.moderate(jobID, enrichedData, messageText)
 		if err != nil {
-			log.Printf("Queue: Failed to get audio blob - %v", err)
-			return false
+			log.Printf("Queue: Rejected message from %s - %v", chatter, err)
+			tm.jobs.finish(jobID, JobRejected, err)
+			return jobID, false
+		}
+
+		// Pick an avatar using the configured strategy
+		chosenAvatarId := tm.selectAvatar(enrichedData, avatarID, avatars)
+		if chosenAvatarId == "" {
+			log.Printf("Queue: Failed to select an avatar")
+			tm.moderator.revoke(admitted)
+			tm.jobs.finish(jobID, JobFailed, errors.New("no avatar could be selected"))
+			return jobID, false
 		}
-		log.Printf("Queue: Generated audio blob: %s", blobURL)
-
-		// Create queue item
-		queueItem := TTSQueueItem{
-			Data:     enrichedData,
-			AvatarID: chosenAvatarId,
-			BlobURL:  blobURL,
-			VoiceID:  avatar["voice_id"].(string),
-			Provider: avatar["provider"].(string),
+		tm.jobs.update(jobID, func(job *TTSJob) { job.AvatarID = chosenAvatarId })
+
+		// Synthesize in the background so /update returns right away
+		job := &synthJob{
+			id:       jobID,
+			ctx:      tm.synthesisContext(),
+			data:     enrichedData,
+			text:     messageText,
+			avatarID: chosenAvatarId,
+			priority: lane,
+			queuedAt: startTime,
+			admitted: admitted,
 		}
+		if !tm.synth.add(job) {
+			log.Printf("Queue: %d messages already waiting for synthesis, dropping message", MaxPendingSynthesis)
+			tm.moderator.revoke(admitted)
+			tm.jobs.finish(jobID, JobFailed, fmt.Errorf("%d messages are already waiting for synthesis", MaxPendingSynthesis))
+			return jobID, false
+		}
+		log.Printf("Queue: Message %s waiting for synthesis (length: %d characters)", jobID, len(messageText))
+		go tm.synthesize(job)
 
-		// Add to queue
-		tm.queueMux.Lock()
-		tm.queue = append(tm.queue, queueItem)
-		queueLength := len(tm.queue)
-		isSpeaking := tm.isSpeaking
-		tm.queueMux.Unlock()
+		return jobID, false // Don't broadcast
+	}
 
-		processingTime := time.Since(startTime)
-		log.Printf("Queue: Item added - Avatar: %s, Position: %d, Processing time: %v", 
-			chosenAvatarId, queueLength, processingTime)
+	return "", true // Continue with broadcast
+}
 
-		// Process queue if not currently speaking
-		if !isSpeaking {
-			go tm.processNextInQueue()
-		}
+// synthesize fetches the audio of a job once the pool has room for its
+// provider, then hands it to the pool to be queued in arrival order
+func (tm *TTSMiddleware) synthesize(job *synthJob) {
+	defer tm.synth.finish(job, tm.deliverSynthesized)
 
-		return false // Don't broadcast
+	// Get voice details for the chosen avatar
+	var voice types.TTSVoice
+	voices, err := tm.avatarVoices(job.avatarID)
+	if err == nil {
+		matching, lang := tm.voicesForText(job.text, voices)
+		tm.jobs.update(job.id, func(j *TTSJob) { j.Language = lang })
+		voice, err = tm.chatterVoice(job.data, job.avatarID, voices, matching)
 	}
+	if err != nil {
+		log.Printf("Queue: Failed to get voice details for avatar %s - %v", job.avatarID, err)
+		tm.moderator.revoke(job.admitted)
+		tm.jobs.finish(job.id, JobFailed, err)
+		return
+	}
+	log.Printf("Queue: Selected voice %s (%s) with %d fallback(s) for avatar %s", 
+		voice.VoiceID, voice.Provider, len(voice.Fallbacks), job.avatarID)
 
-	return true // Continue with broadcast
+	release, err := tm.synth.acquire(job.ctx, voice.Provider)
+	if err != nil {
+		log.Printf("Queue: Synthesis cancelled by clear_tts, dropping message")
+		tm.jobs.finish(job.id, JobCancelled, errCleared)
+		return
+	}
+	blobURL, result, err := tm.getAudioBlob(job.ctx, job.text, voice)
+	usedVoice := result.Voice
+	release()
+	var blockedErr *BlockedWordError
+	switch {
+	case errors.Is(err, context.Canceled):
+		log.Printf("Queue: Synthesis cancelled by clear_tts, dropping message")
+		tm.jobs.finish(job.id, JobCancelled, errCleared)
+		return
+	case errors.As(err, &blockedErr):
+		log.Printf("Queue: Failed to get audio blob - %v", err)
+		tm.jobs.finish(job.id, JobBlocked, err)
+		return
+	case err != nil:
+		log.Printf("Queue: Failed to get audio blob - %v", err)
+		tm.moderator.revoke(job.admitted)
+		tm.jobs.finish(job.id, JobFailed, err)
+		return
+	}
+	log.Printf("Queue: Generated audio blob: %s (voice: %s, provider: %s)", 
+		blobURL, usedVoice.VoiceID, usedVoice.Provider)
+
+	now := time.Now()
+	tm.jobs.update(job.id, func(j *TTSJob) {
+		j.VoiceID = usedVoice.VoiceID
+		j.Provider = usedVoice.Provider
+		j.SynthesizedAt = &now
+	})
+
+	job.item = &TTSQueueItem{
+		ID:       job.id,
+		Data:     job.data,
+		AvatarID: job.avatarID,
+		BlobURL:  blobURL,
+		VoiceID:  usedVoice.VoiceID,
+		Provider: usedVoice.Provider,
+		Priority: job.priority,
+		QueuedAt: job.queuedAt,
+		Duration: result.Duration,
+	}
 }
 
-// updateLastUsedAvatars maintains a list of recently used avatars
-func (tm *TTSMiddleware) updateLastUsedAvatars(avatarId string) {
-	// Add the new avatar to the front of the list
-	tm.lastUsedAvatars = append([]string{avatarId}, tm.lastUsedAvatars...)
+// deliverSynthesized adds a finished job to the queue. Jobs arrive in the
+// order their updates did; failed ones are passed over.
+func (tm *TTSMiddleware) deliverSynthesized(job *synthJob) {
+	if job.item == nil {
+		return
+	}
+	queueItem := *job.item
 
-	// Trim the list if it exceeds maxLastUsed
-	if len(tm.lastUsedAvatars) > tm.maxLastUsed {
-		tm.lastUsedAvatars = tm.lastUsedAvatars[:tm.maxLastUsed]
+	// Add to queue, unless clear_tts came in while the job was waiting
+	tm.queueMux.Lock()
+	if job.ctx.Err() != nil {
+		tm.queueMux.Unlock()
+		tm.queueCleanup(tm.blobPath(queueItem), 0)
+		tm.jobs.finish(job.id, JobCancelled, errCleared)
+		log.Printf("Queue: Message %s cleared before it was queued", job.id)
+		return
 	}
+	tm.queue = append(tm.queue, queueItem)
+	tm.jobs.update(job.id, func(j *TTSJob) { j.State = JobQueued })
+	tm.storeQueueItem(queueItem)
+	queueLength := len(tm.queue)
+	tm.queueMux.Unlock()
+	tm.flushQueueStore()
+	tm.queueUpdated()
+
+	processingTime := time.Since(job.queuedAt)
+	log.Printf("Queue: Item added - Avatar: %s, Priority: %d, Queue length: %d, Processing time: %v", 
+		queueItem.AvatarID, queueItem.Priority, queueLength, processingTime)
 
-	log.Printf("Queue: Updated last used avatars: %s", strings.Join(tm.lastUsedAvatars, ", "))
+	// Start it if a speaker is free
+	go tm.processNextInQueue()
 }
  
\ No newline at end of file
@@ -0,0 +1,389 @@
+package tts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/oristarium/orionchat/types"
+)
+
+// Why a tts update was rejected, see TTSJob.Reason
+const (
+	RejectCooldown  = "cooldown"   // the chatter spoke too recently
+	RejectQueued    = "queued"     // the chatter has too many messages waiting
+	RejectRateLimit = "rate_limit" // too many messages this minute
+	RejectDuplicate = "duplicate"  // the text was just spoken
+)
+
+// Chatter roles that can have their own limits, in order of precedence
+const (
+	RoleBroadcaster = "broadcaster"
+	RoleModerator   = "moderator"
+	RoleSubscriber  = "subscriber"
+	RoleVerified    = "verified"
+)
+
+var chatterRoles = []string{RoleBroadcaster, RoleModerator, RoleSubscriber, RoleVerified}
+
+// moderationSettingsKey is where ModerationSettings are kept in
+// QueueSettingsBucket
+const moderationSettingsKey = "moderation"
+
+// RejectedError is returned for tts updates turned away by moderation
+type RejectedError struct {
+	Reason  string
+	Message string
+}
+
+func (e *RejectedError) Error() string {
+	return e.Message
+}
+
+// ChatterLimits hold one chatter back. Zero turns a limit off.
+type ChatterLimits struct {
+	CooldownSeconds int  `json:"cooldown_seconds"` // between a chatter's messages
+	MaxQueued       int  `json:"max_queued"`       // a chatter's messages waiting at once
+	Exempt          bool `json:"exempt"`           // skip the per-minute cap and duplicate check
+}
+
+// ModerationSettings keep single chatters from filling the queue. Messages
+// without an author, such as custom TTS text, are never held back.
+type ModerationSettings struct {
+	Enabled bool `json:"enabled"`
+	ChatterLimits
+	Roles                  map[string]ChatterLimits `json:"roles,omitempty"` // replace the limits above for a role
+	MaxPerMinute           int                      `json:"max_per_minute"`
+	DuplicateWindowSeconds int                      `json:"duplicate_window_seconds"`
+	DuplicateSimilarity    float64                  `json:"duplicate_similarity"` // 0-1, 1 only drops exact repeats
+}
+
+// DefaultModerationSettings let moderators and the broadcaster through and
+// give subscribers more room
+var DefaultModerationSettings = ModerationSettings{
+	Enabled:       true,
+	ChatterLimits: ChatterLimits{CooldownSeconds: 10, MaxQueued: 2},
+	Roles: map[string]ChatterLimits{
+		RoleBroadcaster: {Exempt: true},
+		RoleModerator:   {Exempt: true},
+		RoleSubscriber:  {CooldownSeconds: 5, MaxQueued: 3},
+	},
+	MaxPerMinute:           30,
+	DuplicateWindowSeconds: 60,
+	DuplicateSimilarity:    0.9,
+}
+
+// Validate checks the limits
+func (s ModerationSettings) Validate() error {
+	limits := map[string]ChatterLimits{"": s.ChatterLimits}
+	for role, l := range s.Roles {
+		known := false
+		for _, r := range chatterRoles {
+			known = known || r == role
+		}
+		if !known {
+			return fmt.Errorf("unknown role %q (use %s)", role, strings.Join(chatterRoles, ", "))
+		}
+		limits[role] = l
+	}
+	for _, l := range limits {
+		if l.CooldownSeconds < 0 || l.MaxQueued < 0 {
+			return fmt.Errorf("cooldown_seconds and max_queued cannot be negative")
+		}
+	}
+	if s.MaxPerMinute < 0 || s.DuplicateWindowSeconds < 0 {
+		return fmt.Errorf("max_per_minute and duplicate_window_seconds cannot be negative")
+	}
+	if s.DuplicateSimilarity < 0 || s.DuplicateSimilarity > 1 {
+		return fmt.Errorf("duplicate_similarity must be between 0 and 1")
+	}
+	return nil
+}
+
+// limitsFor returns the limits of the first of roles with an override, or
+// the general limits
+func (s ModerationSettings) limitsFor(roles []string) ChatterLimits {
+	for _, role := range roles {
+		if l, ok := s.Roles[role]; ok {
+			return l
+		}
+	}
+	return s.ChatterLimits
+}
+
+// messageRoles returns the roles of a message's author, in order of
+// precedence
+func messageRoles(data map[string]interface{}) []string {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil
+	}
+	var message types.ChatMessageData
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return nil
+	}
+	r := message.Author.Roles
+	has := map[string]bool{
+		RoleBroadcaster: r.Broadcaster,
+		RoleModerator:   r.Moderator,
+		RoleSubscriber:  r.Subscriber,
+		RoleVerified:    r.Verified,
+	}
+	var roles []string
+	for _, role := range chatterRoles {
+		if has[role] {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+// recentText is an accepted message, kept to spot duplicates
+type recentText struct {
+	at      time.Time
+	text    string
+	bigrams map[string]int
+}
+
+// moderator tracks what it needs to enforce ModerationSettings
+type moderator struct {
+	mu       sync.Mutex
+	settings ModerationSettings
+	lastSeen map[string]time.Time // chatter key to their last accepted message
+	accepted []time.Time          // messages counting toward MaxPerMinute, oldest first
+	recent   []recentText         // oldest first
+}
+
+func newModerator(settings ModerationSettings) *moderator {
+	return &moderator{
+		settings: settings,
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// admission is what admit recorded for a message, so it can be revoked
+// when the message is not queued after all
+type admission struct {
+	chatter  string
+	at       time.Time
+	lastSeen time.Time // the chatter's last message before this one
+}
+
+// admit decides whether a message by chatter may be queued, and if so
+// records it. queued counts the chatter's messages already waiting. The
+// returned admission is nil when nothing was recorded.
+func (m *moderator) admit(now time.Time, chatter string, roles []string, text string, queued func() int) (*admission, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.settings
+	if !s.Enabled || chatter == "" {
+		return nil, nil
+	}
+	limits := s.limitsFor(roles)
+	m.forget(now)
+
+	if cooldown := time.Duration(limits.CooldownSeconds) * time.Second; cooldown > 0 {
+		if wait := m.lastSeen[chatter].Add(cooldown).Sub(now); wait > 0 {
+			return nil, &RejectedError{RejectCooldown,
+				fmt.Sprintf("chatter is on cooldown for another %v", wait.Round(time.Second))}
+		}
+	}
+	if limits.MaxQueued > 0 {
+		if n := queued(); n >= limits.MaxQueued {
+			return nil, &RejectedError{RejectQueued,
+				fmt.Sprintf("chatter already has %d message(s) waiting", n)}
+		}
+	}
+
+	normalized := normalizeForDuplicates(text)
+	var grams map[string]int
+	if !limits.Exempt {
+		if s.MaxPerMinute > 0 && len(m.accepted) >= s.MaxPerMinute {
+			return nil, &RejectedError{RejectRateLimit,
+				fmt.Sprintf("%d messages were already queued in the last minute", s.MaxPerMinute)}
+		}
+		if s.DuplicateWindowSeconds > 0 && normalized != "" {
+			grams = bigrams(normalized)
+			for _, r := range m.recent {
+				if r.text == normalized || diceSimilarity(grams, r.bigrams) >= s.DuplicateSimilarity {
+					return nil, &RejectedError{RejectDuplicate,
+						fmt.Sprintf("the same text was queued %v ago", now.Sub(r.at).Round(time.Second))}
+				}
+			}
+		}
+	}
+
+	admitted := &admission{chatter: chatter, at: now, lastSeen: m.lastSeen[chatter]}
+	m.lastSeen[chatter] = now
+	if !limits.Exempt {
+		m.accepted = append(m.accepted, now)
+		if grams != nil {
+			m.recent = append(m.recent, recentText{at: now, text: normalized, bigrams: grams})
+		}
+	}
+	return admitted, nil
+}
+
+// revoke takes back what admit recorded for a message that was not queued
+func (m *moderator) revoke(a *admission) {
+	if a == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.lastSeen[a.chatter].Equal(a.at) {
+		if a.lastSeen.IsZero() {
+			delete(m.lastSeen, a.chatter)
+		} else {
+			m.lastSeen[a.chatter] = a.lastSeen
+		}
+	}
+	for i := len(m.accepted) - 1; i >= 0; i-- {
+		if m.accepted[i].Equal(a.at) {
+			m.accepted = append(m.accepted[:i:i], m.accepted[i+1:]...)
+			break
+		}
+	}
+	for i := len(m.recent) - 1; i >= 0; i-- {
+		if m.recent[i].at.Equal(a.at) {
+			m.recent = append(m.recent[:i:i], m.recent[i+1:]...)
+			break
+		}
+	}
+}
+
+// forget drops what no limit looks at any more. Call with mu held.
+func (m *moderator) forget(now time.Time) {
+	for len(m.accepted) > 0 && now.Sub(m.accepted[0]) >= time.Minute {
+		m.accepted = m.accepted[1:]
+	}
+	window := time.Duration(m.settings.DuplicateWindowSeconds) * time.Second
+	for len(m.recent) > 0 && now.Sub(m.recent[0].at) >= window {
+		m.recent = m.recent[1:]
+	}
+
+	longest := m.settings.CooldownSeconds
+	for _, l := range m.settings.Roles {
+		longest = max(longest, l.CooldownSeconds)
+	}
+	for chatter, at := range m.lastSeen {
+		if now.Sub(at) >= time.Duration(longest)*time.Second {
+			delete(m.lastSeen, chatter)
+		}
+	}
+}
+
+// normalizeForDuplicates lowercases text, keeps only letters and digits,
+// and collapses letters repeated for emphasis, so "HALOOO!!" and "halo"
+// compare equal
+func normalizeForDuplicates(text string) string {
+	var b strings.Builder
+	var last rune
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if r != last {
+				b.WriteRune(r)
+			}
+			last = r
+		case unicode.IsSpace(r) && last != ' ' && b.Len() > 0:
+			b.WriteRune(' ')
+			last = ' '
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// bigrams counts the pairs of adjacent runes in text
+func bigrams(text string) map[string]int {
+	runes := []rune(text)
+	grams := make(map[string]int, len(runes))
+	for i := 0; i+1 < len(runes); i++ {
+		grams[string(runes[i:i+2])]++
+	}
+	return grams
+}
+
+// diceSimilarity compares two bigram counts: 1 for the same text, 0 for
+// texts with no pair in common
+func diceSimilarity(a, b map[string]int) float64 {
+	total, shared := 0, 0
+	for gram, n := range a {
+		total += n
+		shared += min(n, b[gram])
+	}
+	for _, n := range b {
+		total += n
+	}
+	if total == 0 {
+		return 0
+	}
+	return 2 * float64(shared) / float64(total)
+}
+
+// Moderation returns the current moderation settings
+func (tm *TTSMiddleware) Moderation() ModerationSettings {
+	tm.moderator.mu.Lock()
+	defer tm.moderator.mu.Unlock()
+	return tm.moderator.settings
+}
+
+// SetModeration validates, stores and applies moderation settings
+func (tm *TTSMiddleware) SetModeration(settings ModerationSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	settings.Roles = maps.Clone(settings.Roles)
+	if err := tm.storeQueueSetting(moderationSettingsKey, settings); err != nil {
+		return err
+	}
+
+	tm.moderator.mu.Lock()
+	tm.moderator.settings = settings
+	tm.moderator.mu.Unlock()
+
+	log.Printf("Queue: Moderation enabled=%v, cooldown %ds, max %d queued per chatter, max %d per minute",
+		settings.Enabled, settings.CooldownSeconds, settings.MaxQueued, settings.MaxPerMinute)
+	return nil
+}
+
+// loadModeration reads the stored moderation settings, keeping the defaults
+// when none are stored
+func (tm *TTSMiddleware) loadModeration() error {
+	tm.moderator = newModerator(DefaultModerationSettings)
+	var settings ModerationSettings
+	found, err := tm.loadQueueSetting(moderationSettingsKey, &settings)
+	if err != nil || !found {
+		return err
+	}
+	if err := settings.Validate(); err != nil {
+		log.Printf("Queue: Ignoring invalid stored moderation settings - %v", err)
+		return nil
+	}
+	tm.moderator.settings = settings
+	return nil
+}
+
+// moderate holds back messages from chatters who are over their limits,
+// and records the ones let through. A message that then fails before it is
+// queued is revoked, so it does not count.
+func (tm *TTSMiddleware) moderate(jobID string, data map[string]interface{}, text string) (*admission, error) {
+	chatter := messageChatter(data)
+	if chatter == "" {
+		return nil, nil
+	}
+	queued := func() int {
+		return tm.jobs.count(func(job TTSJob) bool {
+			return job.ID != jobID && job.Chatter == chatter &&
+				(job.State == JobPending || job.State == JobQueued)
+		})
+	}
+	return tm.moderator.admit(time.Now(), chatter, messageRoles(data), text, queued)
+}
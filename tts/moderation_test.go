@@ -0,0 +1,193 @@
+package tts
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oristarium/orionchat/types"
+)
+
+func rejection(err error) string {
+	var rejected *RejectedError
+	if errors.As(err, &rejected) {
+		return rejected.Reason
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestModeratorLimits(t *testing.T) {
+	m := newModerator(ModerationSettings{
+		Enabled:       true,
+		ChatterLimits: ChatterLimits{CooldownSeconds: 10, MaxQueued: 2},
+		Roles: map[string]ChatterLimits{
+			RoleModerator:  {Exempt: true},
+			RoleSubscriber: {CooldownSeconds: 2},
+		},
+		MaxPerMinute:           3,
+		DuplicateWindowSeconds: 30,
+		DuplicateSimilarity:    0.8,
+	})
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	none := func() int { return 0 }
+
+	steps := []struct {
+		at      int
+		chatter string
+		roles   []string
+		text    string
+		queued  int
+		want    string
+	}{
+		{0, "yt/a", nil, "halo semua", 0, ""},
+		{5, "yt/a", nil, "apa kabar", 0, RejectCooldown},
+		{10, "yt/a", nil, "apa kabar", 2, RejectQueued},
+		{10, "yt/b", nil, "HALOOO SEMUA!!", 0, RejectDuplicate},
+		{11, "yt/b", []string{RoleSubscriber}, "selamat malam", 0, ""},
+		{13, "yt/b", []string{RoleSubscriber}, "mantap jiwa", 0, ""},
+		{14, "yt/c", nil, "gas terus", 0, RejectRateLimit},
+		// Moderators skip the cap and the duplicate check, not cooldowns
+		{14, "yt/m", []string{RoleModerator, RoleSubscriber}, "halo semua", 0, ""},
+		{15, "yt/m", []string{RoleModerator}, "gas terus", 0, ""},
+		// The duplicate window and the minute pass
+		{61, "yt/c", nil, "halo semua", 0, ""},
+	}
+	for i, step := range steps {
+		queued := none
+		if step.queued > 0 {
+			n := step.queued
+			queued = func() int { return n }
+		}
+		_, err := m.admit(at(step.at), step.chatter, step.roles, step.text, queued)
+		if got := rejection(err); got != step.want {
+			t.Errorf("step %d (%s %q): got %q, want %q", i, step.chatter, step.text, got, step.want)
+		}
+	}
+
+	m.settings.Enabled = false
+	if _, err := m.admit(at(62), "yt/c", nil, "halo semua", none); err != nil {
+		t.Errorf("disabled moderation rejected a message: %v", err)
+	}
+}
+
+func TestModeratorRevoke(t *testing.T) {
+	m := newModerator(ModerationSettings{
+		Enabled:                true,
+		ChatterLimits:          ChatterLimits{CooldownSeconds: 10},
+		MaxPerMinute:           3,
+		DuplicateWindowSeconds: 30,
+		DuplicateSimilarity:    0.8,
+	})
+	start := time.Now()
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	none := func() int { return 0 }
+
+	if _, err := m.admit(at(0), "yt/a", nil, "halo semua", none); err != nil {
+		t.Fatal(err)
+	}
+	failed, err := m.admit(at(20), "yt/a", nil, "apa kabar", none)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.revoke(failed)
+
+	// The revoked message leaves no cooldown, duplicate or count behind,
+	// while the earlier one still counts
+	if _, err := m.admit(at(21), "yt/a", nil, "apa kabar", none); err != nil {
+		t.Errorf("after revoking, got %v", err)
+	}
+	if _, err := m.admit(at(22), "yt/b", nil, "halo semua", none); rejection(err) != RejectDuplicate {
+		t.Errorf("earlier message forgotten, got %v", err)
+	}
+	if _, err := m.admit(at(23), "yt/c", nil, "gas terus", none); err != nil {
+		t.Errorf("revoked message counted toward the rate limit: %v", err)
+	}
+	if _, err := m.admit(at(24), "yt/d", nil, "mantap", none); rejection(err) != RejectRateLimit {
+		t.Errorf("rate limit after revoking, got %v", err)
+	}
+	m.revoke(nil)
+}
+
+func TestNormalizeForDuplicates(t *testing.T) {
+	same := [][2]string{
+		{"HALOOO bang!!!", "halo bang"},
+		{"  semangat   terus  ", "semangat terus"},
+	}
+	for _, pair := range same {
+		if a, b := normalizeForDuplicates(pair[0]), normalizeForDuplicates(pair[1]); a != b {
+			t.Errorf("%q normalized to %q, %q to %q", pair[0], a, pair[1], b)
+		}
+	}
+	a, b := bigrams("semangat terus bang"), bigrams("semangat terus kak")
+	if sim := diceSimilarity(a, b); sim < 0.7 || sim >= 1 {
+		t.Errorf("similarity of near duplicates = %.2f", sim)
+	}
+	if sim := diceSimilarity(a, bigrams("xyz")); sim != 0 {
+		t.Errorf("similarity of unrelated texts = %.2f", sim)
+	}
+}
+
+// textMessage is a chat message by the same chatter each time
+func textMessage(text string) map[string]interface{} {
+	data := chatMessage("youtube-1", "")
+	data["content"] = map[string]interface{}{"sanitized": text}
+	return data
+}
+
+func TestRejectedJobReportsReason(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+	tm, err := NewTTSMiddleware(nil, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.clients[&websocket.Conn{}] = "avatar"
+	// Synthesis of the first message waits until the test is over
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	tm.avatarVoices = func(string) ([]types.TTSVoice, error) {
+		<-release
+		return nil, errors.New("no voices in this test")
+	}
+
+	first, _ := tm.InterceptTTS("tts", textMessage("halo"), nil, "")
+	second, _ := tm.InterceptTTS("tts", textMessage("halo lagi"), nil, "")
+
+	if job, _ := tm.Job(first); job.State == JobRejected || job.Chatter != "youtube/youtube-1" {
+		t.Errorf("first message: %+v", job)
+	}
+	job, _ := tm.Job(second)
+	if job.State != JobRejected || job.Reason != RejectCooldown || job.Error == "" {
+		t.Errorf("second message: %+v", job)
+	}
+}
+
+func TestFailedMessageDoesNotCount(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+	tm, err := NewTTSMiddleware(nil, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.clients[&websocket.Conn{}] = "avatar"
+	tm.avatarVoices = func(string) ([]types.TTSVoice, error) {
+		return nil, errors.New("no voices in this test")
+	}
+
+	first, _ := tm.InterceptTTS("tts", textMessage("halo"), nil, "")
+	deadline := time.Now().Add(2 * time.Second)
+	for job, _ := tm.Job(first); job.State != JobFailed && time.Now().Before(deadline); job, _ = tm.Job(first) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The first message never reached the queue, so no cooldown applies
+	second, _ := tm.InterceptTTS("tts", textMessage("halo"), nil, "")
+	if job, _ := tm.Job(second); job.State == JobRejected {
+		t.Errorf("second message: %+v", job)
+	}
+}
@@ -0,0 +1,250 @@
+package tts
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MPEG audio version identifiers as encoded in the frame header
+const (
+	mpegVersion25 = 0
+	mpegVersion2  = 2
+	mpegVersion1  = 3
+)
+
+// MPEG audio layer identifiers as encoded in the frame header
+const (
+	mpegLayer3 = 1
+	mpegLayer2 = 2
+	mpegLayer1 = 3
+)
+
+// mp3Bitrates maps [version1?][layer] to the bitrate table in kbps
+var mp3Bitrates = map[bool]map[int][15]int{
+	true: {
+		mpegLayer1: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		mpegLayer2: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		mpegLayer3: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	false: {
+		mpegLayer1: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		mpegLayer2: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		mpegLayer3: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mp3SampleRates maps a version to its sample rate table in Hz
+var mp3SampleRates = map[int][3]int{
+	mpegVersion1:  {44100, 48000, 32000},
+	mpegVersion2:  {22050, 24000, 16000},
+	mpegVersion25: {11025, 12000, 8000},
+}
+
+// mp3FrameHeader holds the fields of an MPEG audio frame header we care about
+type mp3FrameHeader struct {
+	version    int
+	layer      int
+	sampleRate int
+	samples    int
+	length     int
+	mono       bool
+	crc        bool // a 16-bit CRC follows the header
+}
+
+// parseMP3FrameHeader decodes the 4-byte frame header at the start of b
+func parseMP3FrameHeader(b []byte) (mp3FrameHeader, bool) {
+	var h mp3FrameHeader
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return h, false
+	}
+
+	h.version = int(b[1]>>3) & 0x03
+	h.layer = int(b[1]>>1) & 0x03
+	bitrateIndex := int(b[2]>>4) & 0x0F
+	sampleRateIndex := int(b[2]>>2) & 0x03
+	padding := int(b[2]>>1) & 0x01
+	h.mono = (b[3]>>6)&0x03 == 0x03
+	h.crc = b[1]&0x01 == 0
+
+	if h.version == 1 || h.layer == 0 || bitrateIndex == 0 || bitrateIndex == 0x0F || sampleRateIndex == 0x03 {
+		return h, false
+	}
+
+	bitrate := mp3Bitrates[h.version == mpegVersion1][h.layer][bitrateIndex] * 1000
+	h.sampleRate = mp3SampleRates[h.version][sampleRateIndex]
+
+	switch {
+	case h.layer == mpegLayer1:
+		h.samples = 384
+		h.length = (12*bitrate/h.sampleRate + padding) * 4
+	case h.layer == mpegLayer3 && h.version != mpegVersion1:
+		h.samples = 576
+		h.length = 72*bitrate/h.sampleRate + padding
+	default:
+		h.samples = 1152
+		h.length = 144*bitrate/h.sampleRate + padding
+	}
+
+	return h, h.length > 4
+}
+
+// duration returns the playback length of a single frame
+func (h mp3FrameHeader) duration() time.Duration {
+	return time.Duration(h.samples) * time.Second / time.Duration(h.sampleRate)
+}
+
+// sideInfoSize returns the length in bytes of a Layer III frame's side
+// information
+func (h mp3FrameHeader) sideInfoSize() int {
+	switch {
+	case h.version == mpegVersion1 && h.mono:
+		return 17
+	case h.version != mpegVersion1 && h.mono:
+		return 9
+	case h.version != mpegVersion1:
+		return 17
+	}
+	return 32
+}
+
+// isInfoFrame reports whether the frame is a Xing/Info or VBRI header frame
+// rather than audio. Encoders put one at the start of each file and it
+// describes that file only, so it must not survive stitching.
+func (h mp3FrameHeader) isInfoFrame(frame []byte) bool {
+	if offset := 4 + h.sideInfoSize(); len(frame) >= offset+4 {
+		tag := string(frame[offset : offset+4])
+		if tag == "Xing" || tag == "Info" {
+			return true
+		}
+	}
+	return len(frame) >= 40 && string(frame[36:40]) == "VBRI"
+}
+
+// stripID3 removes a leading ID3v2 tag and a trailing ID3v1 tag
+func stripID3(data []byte) []byte {
+	if len(data) >= 10 && string(data[:3]) == "ID3" {
+		// Tag size is a 28-bit synchsafe integer that excludes the 10-byte header
+		size := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+		if data[5]&0x10 != 0 {
+			size += 10 // footer present
+		}
+		if 10+size <= len(data) {
+			data = data[10+size:]
+		} else {
+			data = nil
+		}
+	}
+
+	if len(data) >= 128 && string(data[len(data)-128:len(data)-125]) == "TAG" {
+		data = data[:len(data)-128]
+	}
+	return data
+}
+
+// walkMP3Frames calls fn for every frame of MPEG audio data, in order.
+// frame is a slice of data, so changes made by fn apply to data.
+func walkMP3Frames(data []byte, fn func(h mp3FrameHeader, frame []byte)) {
+	synced := false
+	for i := 0; i+4 <= len(data); {
+		h, ok := parseMP3FrameHeader(data[i:])
+		if !ok {
+			synced = false
+			i++
+			continue
+		}
+
+		end := i + h.length
+		if end > len(data) {
+			break // truncated final frame
+		}
+
+		// When hunting for a frame, guard against false sync words inside
+		// frame data by requiring another header right after it
+		if !synced && end+4 <= len(data) {
+			if _, ok := parseMP3FrameHeader(data[end:]); !ok {
+				i++
+				continue
+			}
+		}
+
+		fn(h, data[i:end])
+		synced = true
+		i = end
+	}
+}
+
+// extractMP3Frames returns the audio frames of an MP3 file with tags and
+// info frames removed, along with their total duration
+func extractMP3Frames(data []byte) ([]byte, time.Duration, error) {
+	var out bytes.Buffer
+	var duration time.Duration
+	frames := 0
+
+	walkMP3Frames(stripID3(data), func(h mp3FrameHeader, frame []byte) {
+		if frames > 0 || !h.isInfoFrame(frame) {
+			out.Write(frame)
+			duration += h.duration()
+		}
+		frames++
+	})
+
+	if out.Len() == 0 {
+		return nil, 0, fmt.Errorf("no MP3 frames found")
+	}
+	return out.Bytes(), duration, nil
+}
+
+// MP3Duration returns the playback length of an MP3 file
+func MP3Duration(data []byte) (time.Duration, error) {
+	_, duration, err := extractMP3Frames(data)
+	return duration, err
+}
+
+// StitchMP3 joins several MP3 files into a single continuous stream and
+// returns it with the combined duration
+func StitchMP3(chunks [][]byte) ([]byte, time.Duration, error) {
+	var out bytes.Buffer
+	var total time.Duration
+
+	for i, chunk := range chunks {
+		frames, duration, err := extractMP3Frames(chunk)
+		if err != nil {
+			return nil, 0, fmt.Errorf("chunk %d: %v", i, err)
+		}
+		out.Write(frames)
+		total += duration
+	}
+
+	return out.Bytes(), total, nil
+}
+
+// DecodeAudioBase64 decodes base64 audio, dropping any data URL prefix
+// (e.g. "data:audio/mp3;base64,")
+func DecodeAudioBase64(data string) ([]byte, error) {
+	if idx := strings.Index(data, ","); idx != -1 {
+		data = data[idx+1:]
+	}
+	return base64.StdEncoding.DecodeString(data)
+}
+
+// StitchMP3Base64 decodes base64 MP3 chunks, stitches them and returns the
+// result re-encoded as base64 with the combined duration
+func StitchMP3Base64(chunks []string) (string, time.Duration, error) {
+	decoded := make([][]byte, 0, len(chunks))
+	for i, chunk := range chunks {
+		audio, err := DecodeAudioBase64(chunk)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to decode chunk %d: %v", i, err)
+		}
+		decoded = append(decoded, audio)
+	}
+
+	stitched, duration, err := StitchMP3(decoded)
+	if err != nil {
+		return "", 0, err
+	}
+	return base64.StdEncoding.EncodeToString(stitched), duration, nil
+}
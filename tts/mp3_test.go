@@ -0,0 +1,211 @@
+package tts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+var (
+	// MPEG-1 Layer III, 128 kbps, 44.1 kHz, stereo
+	headerMPEG1 = []byte{0xFF, 0xFB, 0x90, 0x00}
+	// MPEG-2 Layer III, 32 kbps, 24 kHz, mono (Google Translate output)
+	headerMPEG2Mono = []byte{0xFF, 0xF3, 0x44, 0xC0}
+)
+
+// mp3Frame builds a frame of the length its header describes
+func mp3Frame(t *testing.T, header []byte) []byte {
+	t.Helper()
+	h, ok := parseMP3FrameHeader(header)
+	if !ok {
+		t.Fatalf("invalid test header % x", header)
+	}
+	frame := make([]byte, h.length)
+	copy(frame, header)
+	return frame
+}
+
+// xingFrame builds a frame carrying a Xing tag at the side-info offset
+func xingFrame(t *testing.T, header []byte, offset int) []byte {
+	frame := mp3Frame(t, header)
+	copy(frame[offset:], "Xing")
+	return frame
+}
+
+func mp3File(frames ...[]byte) []byte {
+	return bytes.Join(frames, nil)
+}
+
+func TestParseMP3FrameHeader(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     []byte
+		ok         bool
+		sampleRate int
+		samples    int
+		length     int
+		mono       bool
+	}{
+		{"mpeg1 layer3 stereo", headerMPEG1, true, 44100, 1152, 417, false},
+		{"mpeg1 layer3 padded", []byte{0xFF, 0xFB, 0x92, 0x00}, true, 44100, 1152, 418, false},
+		{"mpeg2 layer3 24kHz mono", headerMPEG2Mono, true, 24000, 576, 96, true},
+		{"no sync word", []byte{0x49, 0x44, 0x33, 0x04}, false, 0, 0, 0, false},
+		{"reserved version", []byte{0xFF, 0xEB, 0x90, 0x00}, false, 0, 0, 0, false},
+		{"free bitrate", []byte{0xFF, 0xFB, 0x00, 0x00}, false, 0, 0, 0, false},
+		{"bad bitrate", []byte{0xFF, 0xFB, 0xF0, 0x00}, false, 0, 0, 0, false},
+		{"reserved sample rate", []byte{0xFF, 0xFB, 0x9C, 0x00}, false, 0, 0, 0, false},
+		{"short", []byte{0xFF, 0xFB}, false, 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, ok := parseMP3FrameHeader(tt.header)
+			if ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+			if !ok {
+				return
+			}
+			if h.sampleRate != tt.sampleRate || h.samples != tt.samples || h.length != tt.length || h.mono != tt.mono {
+				t.Errorf("got rate=%d samples=%d length=%d mono=%v, want rate=%d samples=%d length=%d mono=%v",
+					h.sampleRate, h.samples, h.length, h.mono, tt.sampleRate, tt.samples, tt.length, tt.mono)
+			}
+		})
+	}
+}
+
+func TestExtractMP3Frames(t *testing.T) {
+	frame1 := mp3Frame(t, headerMPEG1)
+	frame1Duration := 1152 * time.Second / 44100
+	frame2 := mp3Frame(t, headerMPEG2Mono)
+	frame2Duration := 24 * time.Millisecond
+
+	id3WithFooter := append([]byte{'I', 'D', '3', 4, 0, 0x10, 0, 0, 0, 20}, make([]byte, 20+10)...)
+	id3v1 := append([]byte("TAG"), make([]byte, 125)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		frames   int
+		duration time.Duration
+		wantErr  bool
+	}{
+		{
+			name:     "plain mpeg1",
+			data:     mp3File(frame1, frame1, frame1),
+			frames:   3,
+			duration: 3 * frame1Duration,
+		},
+		{
+			name:     "google 24kHz mono",
+			data:     mp3File(frame2, frame2, frame2, frame2),
+			frames:   4,
+			duration: 4 * frame2Duration,
+		},
+		{
+			name:     "id3v2 with footer and id3v1",
+			data:     mp3File(id3WithFooter, frame1, frame1, id3v1),
+			frames:   2,
+			duration: 2 * frame1Duration,
+		},
+		{
+			name:     "leading xing frame dropped",
+			data:     mp3File(xingFrame(t, headerMPEG1, 36), frame1, frame1),
+			frames:   2,
+			duration: 2 * frame1Duration,
+		},
+		{
+			name:     "leading mono mpeg2 info frame dropped",
+			data:     mp3File(xingFrame(t, headerMPEG2Mono, 13), frame2),
+			frames:   1,
+			duration: frame2Duration,
+		},
+		{
+			name:     "xing tag only dropped on first frame",
+			data:     mp3File(frame1, xingFrame(t, headerMPEG1, 36), frame1),
+			frames:   3,
+			duration: 3 * frame1Duration,
+		},
+		{
+			name:     "truncated last frame",
+			data:     mp3File(frame1, frame1, frame1[:200]),
+			frames:   2,
+			duration: 2 * frame1Duration,
+		},
+		{
+			name:     "garbage before first frame",
+			data:     mp3File([]byte{0x00, 0xFF, 0x12, 0x34}, frame1, frame1),
+			frames:   2,
+			duration: 2 * frame1Duration,
+		},
+		{
+			name:    "not mp3",
+			data:    []byte(`{"error":"quota exceeded"}`),
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, duration, err := extractMP3Frames(tt.data)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if duration != tt.duration {
+				t.Errorf("duration = %v, want %v", duration, tt.duration)
+			}
+			if got := countFrames(t, out); got != tt.frames {
+				t.Errorf("frames = %d, want %d", got, tt.frames)
+			}
+		})
+	}
+}
+
+func TestStitchMP3DurationIsSumOfParts(t *testing.T) {
+	frame := mp3Frame(t, headerMPEG2Mono)
+	chunks := [][]byte{
+		mp3File(xingFrame(t, headerMPEG2Mono, 13), frame, frame),
+		mp3File(frame, frame, frame),
+		mp3File(xingFrame(t, headerMPEG2Mono, 13), frame),
+	}
+
+	var sum time.Duration
+	for _, chunk := range chunks {
+		_, d, err := extractMP3Frames(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += d
+	}
+
+	out, total, err := StitchMP3(chunks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != sum {
+		t.Errorf("stitched duration = %v, want sum of parts %v", total, sum)
+	}
+	if total != 6*24*time.Millisecond {
+		t.Errorf("stitched duration = %v, want %v", total, 6*24*time.Millisecond)
+	}
+	if got := countFrames(t, out); got != 6 {
+		t.Errorf("frames = %d, want 6", got)
+	}
+}
+
+// countFrames walks a clean frame stream and counts its frames
+func countFrames(t *testing.T, data []byte) int {
+	t.Helper()
+	n := 0
+	for i := 0; i < len(data); n++ {
+		h, ok := parseMP3FrameHeader(data[i:])
+		if !ok {
+			t.Fatalf("no frame header at offset %d", i)
+		}
+		i += h.length
+	}
+	return n
+}
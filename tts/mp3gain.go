@@ -0,0 +1,108 @@
+package tts
+
+import (
+	"fmt"
+	"math"
+)
+
+// mp3GainStep is the change in dB of one global_gain step. Layer III scales
+// every sample of a granule by 2^(global_gain/4), so each step is
+// 20*log10(2^0.25) dB.
+const mp3GainStep = 1.505
+
+// adjustMP3Gain changes the volume of Layer III audio by rewriting the
+// global_gain field of every granule, the way mp3gain does. Nothing is
+// decoded or re-encoded, so there is no quality loss, but the gain moves in
+// steps of about 1.5 dB.
+func adjustMP3Gain(data []byte, db float64) ([]byte, error) {
+	steps := int(math.Round(db / mp3GainStep))
+	if steps == 0 {
+		return data, nil
+	}
+
+	out := append([]byte(nil), data...)
+	frames, adjusted := 0, 0
+	walkMP3Frames(stripID3(out), func(h mp3FrameHeader, frame []byte) {
+		frames++
+		if h.layer != mpegLayer3 || h.isInfoFrame(frame) {
+			return
+		}
+		for _, bit := range globalGainOffsets(h) {
+			if (bit+8+7)/8 > len(frame) {
+				return
+			}
+			gain := int(readBits(frame, bit, 8)) + steps
+			writeBits(frame, bit, 8, uint32(max(0, min(255, gain))))
+		}
+		adjusted++
+	})
+
+	if frames == 0 {
+		return data, fmt.Errorf("no MP3 frames found")
+	}
+	if adjusted == 0 {
+		return data, fmt.Errorf("no Layer III frames found")
+	}
+	return out, nil
+}
+
+// globalGainOffsets returns the bit offsets within a frame of the
+// global_gain field of each granule and channel
+func globalGainOffsets(h mp3FrameHeader) []int {
+	channels := 2
+	if h.mono {
+		channels = 1
+	}
+
+	start := 32 // frame header
+	if h.crc {
+		start += 16
+	}
+
+	// The side info starts with main_data_begin and private bits, then
+	// MPEG-1 adds scfsi per channel. Each granule/channel block starts with
+	// part2_3_length (12) and big_values (9), followed by global_gain.
+	var granules, header, block int
+	if h.version == mpegVersion1 {
+		granules, block = 2, 59
+		header = 9 + 3 + 4*channels
+		if h.mono {
+			header = 9 + 5 + 4
+		}
+	} else {
+		granules, block = 1, 63
+		header = 8 + 2
+		if h.mono {
+			header = 8 + 1
+		}
+	}
+
+	offsets := make([]int, 0, granules*channels)
+	for i := 0; i < granules*channels; i++ {
+		offsets = append(offsets, start+header+i*block+12+9)
+	}
+	return offsets
+}
+
+// readBits reads n bits, most significant first, starting at bit offset
+func readBits(b []byte, offset, n int) uint32 {
+	var v uint32
+	for i := offset; i < offset+n; i++ {
+		v = v<<1 | uint32(b[i/8]>>(7-i%8))&1
+	}
+	return v
+}
+
+// writeBits writes the low n bits of v, most significant first, starting
+// at bit offset
+func writeBits(b []byte, offset, n int, v uint32) {
+	for i := 0; i < n; i++ {
+		pos := offset + i
+		mask := byte(1) << (7 - pos%8)
+		if v>>(n-1-i)&1 == 1 {
+			b[pos/8] |= mask
+		} else {
+			b[pos/8] &^= mask
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package tts
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// tuneWAV applies speed, pitch and gain to 16-bit PCM WAV audio. Pitch is
+// shifted by resampling, which also changes the length, and the length is
+// then corrected with WSOLA time stretching so the requested speed is kept.
+func tuneWAV(audio []byte, t Tuning) ([]byte, error) {
+	w, err := parseWAV(audio)
+	if err != nil {
+		return nil, err
+	}
+	if w.audioFormat() != 1 || w.bitsPerSample() != 16 || w.channels() == 0 {
+		return nil, fmt.Errorf("only 16-bit PCM WAV can be tuned")
+	}
+
+	channels := decodePCM16(w.data, w.channels())
+
+	ratio := 1.0
+	if t.changesPitch() {
+		ratio = math.Pow(2, t.Pitch/12)
+		for c := range channels {
+			channels[c] = resample(channels[c], ratio)
+		}
+	}
+	if stretch := ratio / t.Speed; math.Abs(stretch-1) >= tuningEpsilon {
+		channels = timeStretch(channels, stretch, w.sampleRate())
+	}
+	if gain := math.Pow(10, t.Gain/20); gain != 1 {
+		for _, samples := range channels {
+			for i := range samples {
+				samples[i] *= gain
+			}
+		}
+	}
+
+	return encodeWAV(w.format, encodePCM16(channels)), nil
+}
+
+// decodePCM16 splits interleaved 16-bit samples into channels scaled to
+// -1..1
+func decodePCM16(data []byte, numChannels int) [][]float64 {
+	frames := len(data) / (2 * numChannels)
+	channels := make([][]float64, numChannels)
+	for c := range channels {
+		channels[c] = make([]float64, frames)
+	}
+	for i := 0; i < frames; i++ {
+		for c := 0; c < numChannels; c++ {
+			offset := 2 * (i*numChannels + c)
+			channels[c][i] = float64(int16(binary.LittleEndian.Uint16(data[offset:]))) / 32768
+		}
+	}
+	return channels
+}
+
+// encodePCM16 interleaves channels into 16-bit samples, clipping anything
+// outside -1..1
+func encodePCM16(channels [][]float64) []byte {
+	frames := len(channels[0])
+	out := make([]byte, 2*frames*len(channels))
+	for i := 0; i < frames; i++ {
+		for c, samples := range channels {
+			v := math.Max(-1, math.Min(1, samples[i])) * 32767
+			binary.LittleEndian.PutUint16(out[2*(i*len(channels)+c):], uint16(int16(math.Round(v))))
+		}
+	}
+	return out
+}
+
+// resample reads samples at ratio times the original rate using linear
+// interpolation. A ratio above 1 raises the pitch and shortens the audio.
+func resample(samples []float64, ratio float64) []float64 {
+	n := int(float64(len(samples)) / ratio)
+	out := make([]float64, n)
+	for i := range out {
+		pos := float64(i) * ratio
+		j := int(pos)
+		if j+1 >= len(samples) {
+			out[i] = samples[len(samples)-1]
+			continue
+		}
+		frac := pos - float64(j)
+		out[i] = samples[j]*(1-frac) + samples[j+1]*frac
+	}
+	return out
+}
+
+// timeStretch changes the length of audio by stretch without changing its
+// pitch, using WSOLA: Hann-windowed frames are overlap-added at a fixed hop
+// and each one is taken from wherever near its nominal position continues
+// the previous frame best. The search runs on a mix of all channels so they
+// stay aligned.
+func timeStretch(channels [][]float64, stretch float64, sampleRate int) [][]float64 {
+	frame := sampleRate * 30 / 1000 &^ 1
+	hop := frame / 2
+	tolerance := sampleRate * 6 / 1000
+	n := len(channels[0])
+	if frame < 4 || n < frame {
+		return channels
+	}
+
+	window := make([]float64, frame)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frame))
+	}
+
+	mix := make([]float64, n)
+	for _, samples := range channels {
+		for i, v := range samples {
+			mix[i] += v
+		}
+	}
+
+	outLen := int(float64(n) * stretch)
+	out := make([][]float64, len(channels))
+	for c := range out {
+		out[c] = make([]float64, outLen+frame)
+	}
+	weight := make([]float64, outLen+frame)
+
+	prev := 0
+	for outPos := 0; outPos < outLen; outPos += hop {
+		pos := int(float64(outPos) / stretch)
+		if outPos > 0 {
+			pos = bestOverlap(mix, prev+hop, pos, tolerance, frame)
+		}
+		for i := 0; i < frame && pos+i < n; i++ {
+			for c, samples := range channels {
+				out[c][outPos+i] += samples[pos+i] * window[i]
+			}
+			weight[outPos+i] += window[i]
+		}
+		prev = pos
+	}
+
+	for c := range out {
+		for i := 0; i < outLen; i++ {
+			if weight[i] > 1e-3 {
+				out[c][i] /= weight[i]
+			}
+		}
+		out[c] = out[c][:outLen]
+	}
+	return out
+}
+
+// bestOverlap returns the position within tolerance of nominal whose frame
+// correlates best with the natural continuation of the previous frame
+func bestOverlap(mix []float64, natural, nominal, tolerance, frame int) int {
+	if natural+frame > len(mix) {
+		return nominal
+	}
+
+	best, bestScore := nominal, math.Inf(-1)
+	for candidate := nominal - tolerance; candidate <= nominal+tolerance; candidate++ {
+		if candidate < 0 || candidate+frame > len(mix) {
+			continue
+		}
+		// Every other sample is plenty for speech and halves the cost
+		score := 0.0
+		for i := 0; i < frame; i += 2 {
+			score += mix[natural+i] * mix[candidate+i]
+		}
+		if score > bestScore {
+			best, bestScore = candidate, score
+		}
+	}
+	return best
+}
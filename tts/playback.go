@@ -0,0 +1,92 @@
+package tts
+
+import (
+	"fmt"
+	"log"
+)
+
+// Playback modes of the TTS queue
+const (
+	PlaybackSingle     = "single"     // one avatar speaks at a time
+	PlaybackConcurrent = "concurrent" // every avatar speaks its own messages
+)
+
+// playbackSettingsKey is where PlaybackSettings are kept in QueueSettingsBucket
+const playbackSettingsKey = "playback"
+
+// PlaybackSettings choose how many queued messages are spoken at once. In
+// concurrent mode each avatar speaks one message at a time, and at most
+// MaxConcurrent avatars speak together.
+type PlaybackSettings struct {
+	Mode          string `json:"mode"`
+	MaxConcurrent int    `json:"max_concurrent"`
+}
+
+// DefaultPlaybackSettings keep to a single speaker
+var DefaultPlaybackSettings = PlaybackSettings{
+	Mode:          PlaybackSingle,
+	MaxConcurrent: DefaultMaxConcurrentSpeakers,
+}
+
+// Validate checks the mode and limit
+func (p PlaybackSettings) Validate() error {
+	if p.Mode != PlaybackSingle && p.Mode != PlaybackConcurrent {
+		return fmt.Errorf("unknown playback mode %q (use %s or %s)", p.Mode, PlaybackSingle, PlaybackConcurrent)
+	}
+	if p.MaxConcurrent < 1 || p.MaxConcurrent > MaxConcurrentSpeakers {
+		return fmt.Errorf("max_concurrent must be between 1 and %d", MaxConcurrentSpeakers)
+	}
+	return nil
+}
+
+// limit returns how many items may be spoken at once
+func (p PlaybackSettings) limit() int {
+	if p.Mode == PlaybackConcurrent {
+		return p.MaxConcurrent
+	}
+	return 1
+}
+
+// Playback returns the current playback settings
+func (tm *TTSMiddleware) Playback() PlaybackSettings {
+	tm.queueMux.Lock()
+	defer tm.queueMux.Unlock()
+	return tm.playback
+}
+
+// SetPlayback validates, stores and applies playback settings. Items being
+// spoken finish even if the new limit is lower.
+func (tm *TTSMiddleware) SetPlayback(settings PlaybackSettings) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+	if err := tm.storeQueueSetting(playbackSettingsKey, settings); err != nil {
+		return err
+	}
+
+	tm.queueMux.Lock()
+	tm.playback = settings
+	tm.queueMux.Unlock()
+
+	log.Printf("Queue: Playback mode %s (max %d at once)", settings.Mode, settings.limit())
+	tm.queueUpdated()
+	tm.processNextInQueue()
+	return nil
+}
+
+// loadPlayback reads the stored playback settings, keeping the defaults
+// when none are stored
+func (tm *TTSMiddleware) loadPlayback() error {
+	tm.playback = DefaultPlaybackSettings
+	var settings PlaybackSettings
+	found, err := tm.loadQueueSetting(playbackSettingsKey, &settings)
+	if err != nil || !found {
+		return err
+	}
+	if err := settings.Validate(); err != nil {
+		log.Printf("Queue: Ignoring invalid stored playback settings - %v", err)
+		return nil
+	}
+	tm.playback = settings
+	return nil
+}
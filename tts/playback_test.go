@@ -0,0 +1,117 @@
+package tts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialAvatar connects a fake avatar page to tm and waits until it is
+// registered
+func dialAvatar(t *testing.T, tm *TTSMiddleware, server *httptest.Server, avatarID string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?avatarId="+avatarID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	for {
+		tm.clientsMux.RLock()
+		registered := tm.avatarIds[avatarID]
+		tm.clientsMux.RUnlock()
+		if registered {
+			return conn
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func speakingIDs(tm *TTSMiddleware) string {
+	var ids []string
+	for _, item := range tm.QueueSnapshot().Speaking {
+		ids = append(ids, item.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestConcurrentPlayback(t *testing.T) {
+	now := time.Now()
+	tm := newTestQueue(t,
+		TTSQueueItem{ID: "x1", AvatarID: "x", BlobURL: "/tts-blob/x1.mp3", QueuedAt: now.Add(-3 * time.Second)},
+		TTSQueueItem{ID: "x2", AvatarID: "x", BlobURL: "/tts-blob/x2.mp3", QueuedAt: now.Add(-2 * time.Second)},
+		TTSQueueItem{ID: "y1", AvatarID: "y", BlobURL: "/tts-blob/y1.mp3", QueuedAt: now.Add(-time.Second)},
+		TTSQueueItem{ID: "z1", AvatarID: "z", BlobURL: "/tts-blob/z1.mp3", QueuedAt: now},
+	)
+	server := httptest.NewServer(http.HandlerFunc(tm.HandleWebSocket))
+	defer server.Close()
+	x := dialAvatar(t, tm, server, "x")
+	dialAvatar(t, tm, server, "y")
+	dialAvatar(t, tm, server, "z")
+
+	// Single mode speaks one item at a time
+	tm.processNextInQueue()
+	if got := speakingIDs(tm); got != "x1" {
+		t.Fatalf("single mode speaking %q, want x1", got)
+	}
+
+	// Concurrent mode lets other avatars speak too, up to the limit, while
+	// x keeps its second message until x1 is done
+	if err := tm.SetPlayback(PlaybackSettings{Mode: PlaybackConcurrent, MaxConcurrent: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := speakingIDs(tm); got != "x1,y1" {
+		t.Fatalf("concurrent mode speaking %q, want x1,y1", got)
+	}
+
+	if err := x.WriteJSON(map[string]interface{}{"signal": "avatar_finished", "avatar_audio": "/tts-blob/x1.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for speakingIDs(tm) != "y1,x2" && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := speakingIDs(tm); got != "y1,x2" {
+		t.Errorf("after x1 finished speaking %q, want y1,x2", got)
+	}
+
+	if err := tm.SkipCurrent("y"); err != nil {
+		t.Fatal(err)
+	}
+	if got := speakingIDs(tm); got != "x2,z1" {
+		t.Errorf("after skipping y speaking %q, want x2,z1", got)
+	}
+
+	if err := tm.SetPlayback(PlaybackSettings{Mode: "everyone"}); err == nil {
+		t.Error("unknown mode accepted")
+	}
+	if err := tm.SetPlayback(PlaybackSettings{Mode: PlaybackConcurrent, MaxConcurrent: MaxConcurrentSpeakers + 1}); err == nil {
+		t.Error("limit past MaxConcurrentSpeakers accepted")
+	}
+}
+
+func TestPlaybackSettingsPersist(t *testing.T) {
+	db, _ := openTestDB(t)
+	tm, err := NewTTSMiddleware(nil, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tm.Playback() != DefaultPlaybackSettings {
+		t.Errorf("fresh start uses %+v", tm.Playback())
+	}
+	settings := PlaybackSettings{Mode: PlaybackConcurrent, MaxConcurrent: 4}
+	if err := tm.SetPlayback(settings); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted, err := NewTTSMiddleware(nil, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restarted.Playback() != settings {
+		t.Errorf("after restart %+v, want %+v", restarted.Playback(), settings)
+	}
+}
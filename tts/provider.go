@@ -1,24 +1,105 @@
 package tts
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MIMETypeMP3 is the audio format every built-in provider produces
+const MIMETypeMP3 = "audio/mpeg"
+
+// SynthesisRequest describes a single synthesis call with typed options
+type SynthesisRequest struct {
+	Text    string
+	VoiceID string
+	Speed   float64 // playback rate, 1.0 is normal
+	Pitch   float64 // semitones, 0 is normal
+	Volume  float64 // gain in dB, 0 is normal
+	Format  string  // wanted MIME type, empty for the provider default
+}
+
+// NewSynthesisRequest creates a request with neutral speed, pitch and volume
+func NewSynthesisRequest(text, voiceID string) SynthesisRequest {
+	return SynthesisRequest{
+		Text:    text,
+		VoiceID: voiceID,
+		Speed:   1,
+		Format:  MIMETypeMP3,
+	}
+}
+
+// Options returns the request's settings as a map, used for cache keys
+func (r SynthesisRequest) Options() map[string]interface{} {
+	return map[string]interface{}{
+		"speed":  r.Speed,
+		"pitch":  r.Pitch,
+		"volume": r.Volume,
+		"format": r.Format,
+	}
+}
+
+// SynthesisResponse is the audio produced by a provider
+type SynthesisResponse struct {
+	Audio    []byte
+	MIMEType string
+	Duration time.Duration
+}
 
 // Provider defines the interface for TTS providers
 type Provider interface {
-	GetAudioBase64(text string, voiceID string, options map[string]interface{}) (string, error)
+	// Synthesize converts text to speech. Implementations must stop work and
+	// return ctx.Err() once ctx is cancelled.
+	Synthesize(ctx context.Context, req SynthesisRequest) (*SynthesisResponse, error)
 	GetVoiceIDs() []string
 	ValidateVoiceID(voiceID string) bool
 }
 
-// ProviderFactory is a map of provider names to their constructor functions
-var ProviderFactory = map[string]func() Provider{
+// ProviderEnv carries shared state that provider constructors may need
+type ProviderEnv struct {
+	TikTokSessions *TikTokSessionPool
+	Catalog        *VoiceCatalog
+}
+
+// catalog returns the voice catalog, or nil when there is none
+func (e *ProviderEnv) catalog() *VoiceCatalog {
+	if e == nil {
+		return nil
+	}
+	return e.Catalog
+}
+
+// ProviderFactory maps provider types to their constructors. Instances are
+// built from a ProviderConfig and kept by the ProviderRegistry.
+var ProviderFactory = map[string]func(config ProviderConfig, env *ProviderEnv) (Provider, error){
 	ProviderGoogle: NewGoogleTranslateProvider,
 	ProviderTikTok: NewTikTokProvider,
+	ProviderHTTP:   NewHTTPProvider,
 }
 
-// GetProvider returns a TTS provider by name
-func GetProvider(name string) (Provider, error) {
-	if constructor, exists := ProviderFactory[name]; exists {
-		return constructor(), nil
+// checkFormat rejects requests for a format other than MP3
+func checkFormat(req SynthesisRequest) error {
+	if req.Format != "" && req.Format != MIMETypeMP3 {
+		return fmt.Errorf("unsupported audio format: %s", req.Format)
 	}
-	return nil, fmt.Errorf("provider %s not found", name)
-} 
\ No newline at end of file
+	return nil
+}
+
+// newMP3Response wraps MP3 bytes, measuring the duration from its frames
+func newMP3Response(audio []byte) *SynthesisResponse {
+	duration, _ := MP3Duration(audio)
+	return &SynthesisResponse{
+		Audio:    audio,
+		MIMEType: MIMETypeMP3,
+		Duration: duration,
+	}
+}
+
+// requestError classifies a failed HTTP round trip. Cancellation is
+// returned as is so callers can tell it apart from a transient failure.
+func requestError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return transientf("request failed: %v", err)
+}
@@ -0,0 +1,331 @@
+package tts
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/oristarium/orionchat/types"
+)
+
+// MessagePriority returns the queue lane of a TTS update. An explicit
+// priority wins, clamped to the known lanes. Otherwise donations come
+// first, then moderators and the broadcaster, then subscribers.
+func MessagePriority(data interface{}, explicit *int) int {
+	if explicit != nil {
+		return max(PriorityNormal, min(PriorityDonation, *explicit))
+	}
+
+	// Updates arrive as generic JSON, so decode the parts we need
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return PriorityNormal
+	}
+	var message types.ChatMessageData
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return PriorityNormal
+	}
+
+	roles := message.Author.Roles
+	switch {
+	case message.Metadata.MonetaryData != nil:
+		return PriorityDonation
+	case roles.Broadcaster || roles.Moderator:
+		return PriorityModerator
+	case roles.Subscriber:
+		return PrioritySubscriber
+	}
+	return PriorityNormal
+}
+
+// effectivePriority is an item's lane raised by one for every
+// PriorityAgingInterval it has waited. Every item ages at the same rate, so
+// the order of queued items never changes by itself.
+func (item TTSQueueItem) effectivePriority(now time.Time) float64 {
+	return float64(item.Priority) + item.Boost + float64(now.Sub(item.QueuedAt))/float64(PriorityAgingInterval)
+}
+
+// nextQueueIndex returns the index of the item to speak next: the highest
+// effective priority, and among equals the one queued first. Only items
+// ready accepts are considered, all of them when it is nil. It returns -1
+// when there is no such item.
+func nextQueueIndex(queue []TTSQueueItem, now time.Time, ready func(TTSQueueItem) bool) int {
+	best := -1
+	for i, item := range queue {
+		if ready != nil && !ready(item) {
+			continue
+		}
+		if best == -1 {
+			best = i
+			continue
+		}
+		p, bp := item.effectivePriority(now), queue[best].effectivePriority(now)
+		if p > bp || (p == bp && item.QueuedAt.Before(queue[best].QueuedAt)) {
+			best = i
+		}
+	}
+	return best
+}
+
+// orderQueue sorts queue into the order it will be spoken in
+func orderQueue(queue []TTSQueueItem, now time.Time) []TTSQueueItem {
+	ordered := append([]TTSQueueItem(nil), queue...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		pi, pj := ordered[i].effectivePriority(now), ordered[j].effectivePriority(now)
+		if pi != pj {
+			return pi > pj
+		}
+		return ordered[i].QueuedAt.Before(ordered[j].QueuedAt)
+	})
+	return ordered
+}
+
+// Errors returned by the queue controls
+var (
+	ErrQueueItemNotFound = errors.New("queue item not found")
+	ErrNotSpeaking       = errors.New("nothing is being spoken")
+)
+
+// errCleared is the reason reported for jobs dropped by clear_tts
+var errCleared = errors.New("cleared by clear_tts")
+
+// queueItemSeq numbers the queue items created since start
+var queueItemSeq atomic.Uint64
+
+// newQueueItemID returns a queue item ID that stays unique across restarts.
+// The clock alone is not enough, as it can tick slower than items arrive.
+func newQueueItemID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36) + "-" + strconv.FormatUint(queueItemSeq.Add(1), 36)
+}
+
+// TTSQueueItemView is a queue item as shown to clients
+type TTSQueueItemView struct {
+	ID       string      `json:"id"`
+	Position int         `json:"position"` // 1 speaks next, 0 is speaking now
+	Priority int         `json:"priority"`
+	Author   interface{} `json:"author,omitempty"`
+	Text     string      `json:"text"`
+	AvatarID string      `json:"avatar_id"`
+	VoiceID  string      `json:"voice_id"`
+	Provider string      `json:"provider"`
+	QueuedAt time.Time   `json:"queued_at"`
+}
+
+// TTSQueueSnapshot is the state of the queue at one moment. Current is the
+// longest running of the Speaking items, the only one in single mode.
+type TTSQueueSnapshot struct {
+	Paused   bool               `json:"paused"`
+	Playback PlaybackSettings   `json:"playback"`
+	Current  *TTSQueueItemView  `json:"current"`
+	Speaking []TTSQueueItemView `json:"speaking"`
+	Items    []TTSQueueItemView `json:"items"`
+}
+
+func (item TTSQueueItem) view(position int) TTSQueueItemView {
+	text := ""
+	if content, ok := item.Data["content"].(map[string]interface{}); ok {
+		text, _ = content["sanitized"].(string)
+	}
+	return TTSQueueItemView{
+		ID:       item.ID,
+		Position: position,
+		Priority: item.Priority,
+		Author:   item.Data["author"],
+		Text:     text,
+		AvatarID: item.AvatarID,
+		VoiceID:  item.VoiceID,
+		Provider: item.Provider,
+		QueuedAt: item.QueuedAt,
+	}
+}
+
+// QueueSnapshot returns the items being spoken and the queued items in the
+// order they will be spoken
+func (tm *TTSMiddleware) QueueSnapshot() TTSQueueSnapshot {
+	tm.queueMux.Lock()
+	defer tm.queueMux.Unlock()
+
+	snapshot := TTSQueueSnapshot{
+		Paused:   tm.paused,
+		Playback: tm.playback,
+		Speaking: []TTSQueueItemView{},
+		Items:    []TTSQueueItemView{},
+	}
+	speaking := make([]TTSQueueItem, 0, len(tm.speaking))
+	for _, item := range tm.speaking {
+		speaking = append(speaking, *item)
+	}
+	sort.Slice(speaking, func(i, j int) bool {
+		return speaking[i].StartedAt.Before(speaking[j].StartedAt)
+	})
+	for _, item := range speaking {
+		snapshot.Speaking = append(snapshot.Speaking, item.view(0))
+	}
+	if len(snapshot.Speaking) > 0 {
+		snapshot.Current = &snapshot.Speaking[0]
+	}
+	for i, item := range orderQueue(tm.queue, time.Now()) {
+		snapshot.Items = append(snapshot.Items, item.view(i+1))
+	}
+	return snapshot
+}
+
+// RemoveQueueItem drops a queued item before it is spoken
+func (tm *TTSMiddleware) RemoveQueueItem(id string) error {
+	tm.queueMux.Lock()
+	var removed *TTSQueueItem
+	for i, item := range tm.queue {
+		if item.ID == id {
+			removed = &item
+			tm.queue = append(tm.queue[:i], tm.queue[i+1:]...)
+			tm.unstoreQueueItems(item)
+			tm.jobs.finish(item.ID, JobCancelled, errors.New("removed from the queue"))
+			break
+		}
+	}
+	tm.queueMux.Unlock()
+	tm.flushQueueStore()
+
+	if removed == nil {
+		return ErrQueueItemNotFound
+	}
+	tm.queueCleanup(tm.blobPath(*removed), 0)
+	log.Printf("Queue: Removed item %s", id)
+	tm.queueUpdated()
+	return nil
+}
+
+// MoveQueueItem moves a queued item to a 1-based position in the speaking
+// order. The item's boost is set so it sorts between its new neighbours;
+// since all items age alike it stays there, and new messages still slot in
+// by their own priority.
+func (tm *TTSMiddleware) MoveQueueItem(id string, position int) error {
+	defer tm.flushQueueStore()
+	tm.queueMux.Lock()
+	defer tm.queueMux.Unlock()
+
+	now := time.Now()
+	ordered := orderQueue(tm.queue, now)
+	from := -1
+	for i, item := range ordered {
+		if item.ID == id {
+			from = i
+			break
+		}
+	}
+	if from == -1 {
+		return ErrQueueItemNotFound
+	}
+
+	moved := ordered[from]
+	rest := append(ordered[:from:from], ordered[from+1:]...)
+	to := max(0, min(len(rest), position-1))
+
+	var target float64
+	switch {
+	case len(rest) == 0:
+		return nil
+	case to == 0:
+		target = rest[0].effectivePriority(now) + 1
+	case to == len(rest):
+		target = rest[len(rest)-1].effectivePriority(now) - 1
+	default:
+		target = (rest[to-1].effectivePriority(now) + rest[to].effectivePriority(now)) / 2
+	}
+
+	for i := range tm.queue {
+		if tm.queue[i].ID == id {
+			tm.queue[i].Boost += target - moved.effectivePriority(now)
+			tm.storeQueueItem(tm.queue[i])
+		}
+	}
+	log.Printf("Queue: Moved item %s to position %d", id, to+1)
+	tm.queueUpdated()
+	return nil
+}
+
+// SkipCurrent stops what avatarID is speaking and moves on to the next
+// item. An empty avatarID stops every avatar that is speaking.
+func (tm *TTSMiddleware) SkipCurrent(avatarID string) error {
+	tm.queueMux.Lock()
+	var skipped []TTSQueueItem
+	for id, item := range tm.speaking {
+		if avatarID != "" && id != avatarID {
+			continue
+		}
+		skipped = append(skipped, *item)
+		delete(tm.speaking, id)
+		tm.unstoreQueueItems(*item)
+		tm.jobs.finish(item.ID, JobSkipped, nil)
+	}
+	tm.queueMux.Unlock()
+	tm.flushQueueStore()
+
+	if len(skipped) == 0 {
+		return ErrNotSpeaking
+	}
+	for _, item := range skipped {
+		tm.sendToAvatar(item.AvatarID, map[string]interface{}{
+			"signal":       "avatar_stop",
+			"avatar_audio": item.BlobURL,
+		})
+		tm.queueCleanup(tm.blobPath(item), 0)
+		log.Printf("Queue: Skipped item %s on avatar %s", item.ID, item.AvatarID)
+	}
+
+	tm.queueUpdated()
+	tm.processNextInQueue()
+	return nil
+}
+
+// SetPaused pauses or resumes the queue. Pausing lets the item being spoken
+// finish but starts no new one.
+func (tm *TTSMiddleware) SetPaused(paused bool) {
+	tm.queueMux.Lock()
+	changed := tm.paused != paused
+	tm.paused = paused
+	tm.queueMux.Unlock()
+
+	if !changed {
+		return
+	}
+	log.Printf("Queue: Paused: %v", paused)
+	tm.queueUpdated()
+	if !paused {
+		tm.processNextInQueue()
+	}
+}
+
+// OnQueueChange registers fn to receive a snapshot after every change to
+// the queue
+func (tm *TTSMiddleware) OnQueueChange(fn func(TTSQueueSnapshot)) {
+	tm.queueMux.Lock()
+	defer tm.queueMux.Unlock()
+	tm.onQueueChange = fn
+}
+
+// queueUpdated schedules a change notification without blocking
+func (tm *TTSMiddleware) queueUpdated() {
+	select {
+	case tm.queueChanged <- struct{}{}:
+	default:
+		// A notification is already pending and will see this change too
+	}
+}
+
+// notifyWorker delivers change notifications one at a time, so listeners
+// never receive an older snapshot after a newer one
+func (tm *TTSMiddleware) notifyWorker() {
+	for range tm.queueChanged {
+		tm.queueMux.Lock()
+		fn := tm.onQueueChange
+		tm.queueMux.Unlock()
+		if fn != nil {
+			fn(tm.QueueSnapshot())
+		}
+	}
+}
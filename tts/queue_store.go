@@ -0,0 +1,185 @@
+package tts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// queueStoreOp is a pending write to QueueBucket. A nil data deletes the
+// item.
+type queueStoreOp struct {
+	id   string
+	data []byte
+}
+
+// storeQueueItem records that a queued or speaking item must be persisted
+// so it survives a restart. Call with queueMux held, so writes are made in
+// the order of the queue changes, and call flushQueueStore once it is
+// released.
+func (tm *TTSMiddleware) storeQueueItem(item TTSQueueItem) {
+	if tm.db == nil {
+		return
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		log.Printf("Queue: Error marshaling item %s - %v", item.ID, err)
+		return
+	}
+	tm.storeMux.Lock()
+	tm.storeOps = append(tm.storeOps, queueStoreOp{id: item.ID, data: data})
+	tm.storeMux.Unlock()
+}
+
+// unstoreQueueItems records that items were spoken or dropped. Like
+// storeQueueItem, call with queueMux held and flushQueueStore after.
+func (tm *TTSMiddleware) unstoreQueueItems(items ...TTSQueueItem) {
+	if tm.db == nil || len(items) == 0 {
+		return
+	}
+	tm.storeMux.Lock()
+	for _, item := range items {
+		tm.storeOps = append(tm.storeOps, queueStoreOp{id: item.ID})
+	}
+	tm.storeMux.Unlock()
+}
+
+// flushQueueStore writes the pending queue changes to the database. It runs
+// without queueMux, so a slow disk does not hold up the queue.
+func (tm *TTSMiddleware) flushQueueStore() {
+	tm.flushMux.Lock()
+	defer tm.flushMux.Unlock()
+
+	tm.storeMux.Lock()
+	ops := tm.storeOps
+	tm.storeOps = nil
+	tm.storeMux.Unlock()
+	if len(ops) == 0 {
+		return
+	}
+
+	err := tm.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(QueueBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		for _, op := range ops {
+			if op.data == nil {
+				err = b.Delete([]byte(op.id))
+			} else {
+				err = b.Put([]byte(op.id), op.data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Queue: Error storing %d queue change(s) - %v", len(ops), err)
+	}
+}
+
+// storeQueueSetting persists a setting changed through the API under key
+// in QueueSettingsBucket
+func (tm *TTSMiddleware) storeQueueSetting(key string, value interface{}) error {
+	return storeSetting(tm.db, QueueSettingsBucket, key, value)
+}
+
+// loadQueueSetting reads the setting stored under key into value. It
+// reports false when none is stored or the stored one does not decode.
+func (tm *TTSMiddleware) loadQueueSetting(key string, value interface{}) (bool, error) {
+	return loadSetting(tm.db, QueueSettingsBucket, key, value)
+}
+
+// QueueBlobPath returns the directory queued audio is kept in, next to the
+// database rather than in the working directory
+func QueueBlobPath(db *bbolt.DB) (string, error) {
+	dbPath, err := filepath.Abs(db.Path())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(dbPath), QueueBlobDir), nil
+}
+
+// BlobDir returns the directory queued audio is kept in
+func (tm *TTSMiddleware) BlobDir() string {
+	return tm.blobDir
+}
+
+// blobPath returns where the audio of an item is kept
+func (tm *TTSMiddleware) blobPath(item TTSQueueItem) string {
+	return filepath.Join(tm.blobDir, filepath.Base(item.BlobURL))
+}
+
+// restoreQueue loads the items stored before the last shutdown, including
+// the one that was being spoken, which is spoken again. Items older than
+// maxAge, or whose audio is gone, are dropped, as are blob files no stored
+// item refers to.
+func (tm *TTSMiddleware) restoreQueue(maxAge time.Duration) error {
+	var stored []TTSQueueItem
+	err := tm.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(QueueBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var item TTSQueueItem
+			if err := json.Unmarshal(v, &item); err != nil {
+				log.Printf("Error unmarshaling queue item %q: %v", string(k), err)
+				item.ID = string(k)
+			}
+			stored = append(stored, item)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	keep := make(map[string]bool)
+	var dropped []TTSQueueItem
+	for _, item := range stored {
+		if item.BlobURL == "" || (maxAge > 0 && now.Sub(item.QueuedAt) > maxAge) {
+			dropped = append(dropped, item)
+			continue
+		}
+		if _, err := os.Stat(tm.blobPath(item)); err != nil {
+			dropped = append(dropped, item)
+			continue
+		}
+		keep[filepath.Base(item.BlobURL)] = true
+		tm.queue = append(tm.queue, item)
+		tm.jobs.add(TTSJob{
+			ID:       item.ID,
+			State:    JobQueued,
+			Priority: item.Priority,
+			Chatter:  messageChatter(item.Data),
+			AvatarID: item.AvatarID,
+			VoiceID:  item.VoiceID,
+			Provider: item.Provider,
+			QueuedAt: item.QueuedAt,
+		})
+	}
+	tm.unstoreQueueItems(dropped...)
+	tm.flushQueueStore()
+
+	entries, err := os.ReadDir(tm.blobDir)
+	if err != nil {
+		return fmt.Errorf("read blob directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() && !keep[entry.Name()] {
+			os.Remove(filepath.Join(tm.blobDir, entry.Name()))
+		}
+	}
+
+	log.Printf("Queue: Restored %d item(s), dropped %d", len(tm.queue), len(dropped))
+	return nil
+}
@@ -0,0 +1,279 @@
+package tts
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"go.etcd.io/bbolt"
+)
+
+func TestMessagePriority(t *testing.T) {
+	decode := func(s string) interface{} {
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	explicit := func(p int) *int { return &p }
+
+	tests := []struct {
+		name     string
+		data     string
+		explicit *int
+		want     int
+	}{
+		{"plain", `{"content":{"sanitized":"hi"}}`, nil, PriorityNormal},
+		{"subscriber", `{"author":{"roles":{"subscriber":true}}}`, nil, PrioritySubscriber},
+		{"moderator", `{"author":{"roles":{"moderator":true,"subscriber":true}}}`, nil, PriorityModerator},
+		{"broadcaster", `{"author":{"roles":{"broadcaster":true}}}`, nil, PriorityModerator},
+		{"superchat", `{"metadata":{"monetary_data":{"amount":"50000"}}}`, nil, PriorityDonation},
+		{"explicit", `{"metadata":{"monetary_data":{"amount":"50000"}}}`, explicit(1), PrioritySubscriber},
+		{"explicit clamped", `{}`, explicit(99), PriorityDonation},
+	}
+	for _, tt := range tests {
+		if got := MessagePriority(decode(tt.data), tt.explicit); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNextQueueIndexServesLanesAndAges(t *testing.T) {
+	now := time.Now()
+	queue := []TTSQueueItem{
+		{BlobURL: "old chat", Priority: PriorityNormal, QueuedAt: now.Add(-20 * time.Second)},
+		{BlobURL: "chat", Priority: PriorityNormal, QueuedAt: now.Add(-10 * time.Second)},
+		{BlobURL: "superchat", Priority: PriorityDonation, QueuedAt: now},
+		{BlobURL: "mod", Priority: PriorityModerator, QueuedAt: now.Add(-time.Second)},
+	}
+
+	var order []string
+	for len(queue) > 0 {
+		i := nextQueueIndex(queue, now, nil)
+		order = append(order, queue[i].BlobURL)
+		queue = append(queue[:i], queue[i+1:]...)
+	}
+	want := []string{"superchat", "mod", "old chat", "chat"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+
+	// A message that has waited long enough overtakes a fresh donation
+	starved := TTSQueueItem{Priority: PriorityNormal, QueuedAt: now.Add(-4 * PriorityAgingInterval)}
+	fresh := TTSQueueItem{Priority: PriorityDonation, QueuedAt: now}
+	if i := nextQueueIndex([]TTSQueueItem{fresh, starved}, now, nil); i != 1 {
+		t.Errorf("aged message was not served first")
+	}
+	if i := nextQueueIndex(nil, now, nil); i != -1 {
+		t.Errorf("empty queue returned %d", i)
+	}
+}
+
+func newTestQueue(t *testing.T, items ...TTSQueueItem) *TTSMiddleware {
+	t.Helper()
+	return &TTSMiddleware{
+		clients:       make(map[*websocket.Conn]string),
+		avatarIds:     make(map[string]bool),
+		blobDir:       t.TempDir(),
+		queue:         items,
+		speaking:      make(map[string]*TTSQueueItem),
+		playback:      DefaultPlaybackSettings,
+		cleanupChan:   make(chan cleanupJob, 100),
+		queueChanged:  make(chan struct{}, 1),
+		jobs:          newJobTracker(MaxTrackedJobs),
+		speakingGrace: SpeakingGracePeriod,
+	}
+}
+
+func queueOrder(tm *TTSMiddleware) string {
+	var ids []string
+	for _, item := range tm.QueueSnapshot().Items {
+		ids = append(ids, item.ID)
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestQueueControls(t *testing.T) {
+	now := time.Now()
+	tm := newTestQueue(t,
+		TTSQueueItem{ID: "a", Priority: PriorityNormal, QueuedAt: now.Add(-3 * time.Second),
+			Data: map[string]interface{}{"content": map[string]interface{}{"sanitized": "halo"}}},
+		TTSQueueItem{ID: "b", Priority: PriorityNormal, QueuedAt: now.Add(-2 * time.Second)},
+		TTSQueueItem{ID: "c", Priority: PriorityNormal, QueuedAt: now.Add(-time.Second)},
+		TTSQueueItem{ID: "d", Priority: PriorityDonation, QueuedAt: now},
+	)
+
+	snapshot := tm.QueueSnapshot()
+	if got := queueOrder(tm); got != "d,a,b,c" {
+		t.Fatalf("order = %s, want d,a,b,c", got)
+	}
+	if snapshot.Items[1].Text != "halo" || snapshot.Items[1].Position != 2 {
+		t.Errorf("unexpected view %+v", snapshot.Items[1])
+	}
+
+	steps := []struct {
+		id       string
+		position int
+		want     string
+	}{
+		{"c", 1, "c,d,a,b"},
+		{"c", 3, "d,a,c,b"},
+		{"d", 99, "a,c,b,d"},
+	}
+	for _, step := range steps {
+		if err := tm.MoveQueueItem(step.id, step.position); err != nil {
+			t.Fatal(err)
+		}
+		if got := queueOrder(tm); got != step.want {
+			t.Errorf("move %s to %d: order = %s, want %s", step.id, step.position, got, step.want)
+		}
+	}
+
+	// A new donation still goes ahead of moved normal items
+	tm.queue = append(tm.queue, TTSQueueItem{ID: "e", Priority: PriorityDonation, QueuedAt: time.Now()})
+	if got := queueOrder(tm); got != "e,a,c,b,d" {
+		t.Errorf("order after new donation = %s", got)
+	}
+
+	if err := tm.RemoveQueueItem("c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.RemoveQueueItem("c"); !errors.Is(err, ErrQueueItemNotFound) {
+		t.Errorf("removing twice: %v", err)
+	}
+	if err := tm.MoveQueueItem("c", 1); !errors.Is(err, ErrQueueItemNotFound) {
+		t.Errorf("moving a removed item: %v", err)
+	}
+	if err := tm.SkipCurrent(""); !errors.Is(err, ErrNotSpeaking) {
+		t.Errorf("skipping while idle: %v", err)
+	}
+
+	tm.SetPaused(true)
+	tm.processNextInQueue()
+	if snapshot := tm.QueueSnapshot(); !snapshot.Paused || snapshot.Current != nil || len(snapshot.Items) != 4 {
+		t.Errorf("paused queue moved on: %+v", snapshot)
+	}
+}
+
+func TestQueueRestoredAfterRestart(t *testing.T) {
+	db, _ := openTestDB(t)
+	blobDir := t.TempDir()
+
+	tm, err := NewTTSMiddleware(nil, db, blobDir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueue := func(id string, priority int, queuedAt time.Time) {
+		blobURL, err := tm.writeBlob([]byte("ID3 audio"), "audio/mpeg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tm.queueMux.Lock()
+		item := TTSQueueItem{ID: id, BlobURL: blobURL, Priority: priority, QueuedAt: queuedAt}
+		tm.queue = append(tm.queue, item)
+		tm.storeQueueItem(item)
+		tm.queueMux.Unlock()
+		tm.flushQueueStore()
+	}
+
+	now := time.Now()
+	enqueue("a", PriorityNormal, now.Add(-3*time.Second))
+	enqueue("b", PriorityNormal, now.Add(-2*time.Second))
+	enqueue("c", PriorityDonation, now.Add(-time.Second))
+	enqueue("stale", PriorityDonation, now.Add(-2*time.Minute))
+	enqueue("removed", PriorityNormal, now)
+	if err := tm.MoveQueueItem("b", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.RemoveQueueItem("removed"); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(blobDir, "tts_orphan.mp3")
+	if err := os.WriteFile(orphan, []byte("ID3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := NewTTSMiddleware(nil, db, blobDir, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := queueOrder(restored); got != "b,c,a" {
+		t.Errorf("restored order = %s, want b,c,a", got)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Errorf("orphaned blob was kept: %v", err)
+	}
+	for _, item := range restored.queue {
+		if _, err := os.Stat(restored.blobPath(item)); err != nil {
+			t.Errorf("blob of %s is gone: %v", item.ID, err)
+		}
+	}
+
+	// An item whose audio is gone cannot be spoken and is dropped
+	os.Remove(restored.blobPath(restored.queue[0]))
+	again, err := NewTTSMiddleware(nil, db, blobDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.queue) != 2 {
+		t.Errorf("restored %d items, want 2", len(again.queue))
+	}
+}
+
+func TestQueueStoreKeepsChangeOrder(t *testing.T) {
+	db, _ := openTestDB(t)
+	tm, err := NewTTSMiddleware(nil, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made before a flush are written in the order they were made
+	tm.queueMux.Lock()
+	tm.storeQueueItem(TTSQueueItem{ID: "spoken", BlobURL: "/tts-blob/a.mp3"})
+	tm.storeQueueItem(TTSQueueItem{ID: "kept", BlobURL: "/tts-blob/b.mp3"})
+	tm.unstoreQueueItems(TTSQueueItem{ID: "spoken"})
+	tm.queueMux.Unlock()
+	tm.flushQueueStore()
+
+	var stored []string
+	db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(QueueBucket)).ForEach(func(k, v []byte) error {
+			stored = append(stored, string(k))
+			return nil
+		})
+	})
+	if strings.Join(stored, ",") != "kept" {
+		t.Errorf("stored items = %v, want [kept]", stored)
+	}
+}
+
+func TestQueueBlobPathIsNextToDatabase(t *testing.T) {
+	db, path := openTestDB(t)
+	defer db.Close()
+	blobDir, err := QueueBlobPath(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(filepath.Dir(path), QueueBlobDir); blobDir != want || !filepath.IsAbs(blobDir) {
+		t.Errorf("QueueBlobPath = %s, want %s", blobDir, want)
+	}
+}
+
+func TestQueueItemIDsAreUnique(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100000; i++ {
+		id := newQueueItemID()
+		if seen[id] {
+			t.Fatalf("ID %s handed out twice after %d IDs", id, i)
+		}
+		seen[id] = true
+	}
+}
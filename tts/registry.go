@@ -0,0 +1,329 @@
+package tts
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// Credential keys understood by the built-in provider types
+const (
+	CredentialAuthValue = "auth_value" // generic HTTP, sent in the auth header
+)
+
+// maskedCredential replaces credential values in API responses. Sending it
+// back unchanged keeps the stored value.
+const maskedCredential = "********"
+
+var providerNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// ProviderConfig is the persisted configuration of a provider instance.
+// Name is what voices refer to, Type selects the constructor in
+// ProviderFactory.
+type ProviderConfig struct {
+	Name        string              `json:"name"`
+	Type        string              `json:"type"`
+	Enabled     bool                `json:"enabled"`
+	Host        string              `json:"host,omitempty"`
+	TimeoutMS   int                 `json:"timeout_ms,omitempty"`
+	Credentials map[string]string   `json:"credentials,omitempty"`
+	HTTP        *HTTPProviderConfig `json:"http,omitempty"`
+}
+
+// Timeout returns the configured request timeout, or the default
+func (c ProviderConfig) Timeout() time.Duration {
+	if c.TimeoutMS > 0 {
+		return time.Duration(c.TimeoutMS) * time.Millisecond
+	}
+	return ProviderRequestTimeout
+}
+
+// HostOr returns the configured host, or def when none is set
+func (c ProviderConfig) HostOr(def string) string {
+	if c.Host != "" {
+		return c.Host
+	}
+	return def
+}
+
+// Fingerprint hashes the parts of the config that change the audio a
+// provider makes, its type, host and HTTP settings. Credentials, timeouts
+// and whether it is enabled are left out.
+func (c ProviderConfig) Fingerprint() string {
+	data, _ := json.Marshal(struct {
+		Type string              `json:"type"`
+		Host string              `json:"host"`
+		HTTP *HTTPProviderConfig `json:"http"`
+	}{c.Type, c.Host, c.HTTP})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Masked returns a copy safe to send to clients, with credentials hidden
+func (c ProviderConfig) Masked() ProviderConfig {
+	if len(c.Credentials) > 0 {
+		masked := make(map[string]string, len(c.Credentials))
+		for k := range c.Credentials {
+			masked[k] = maskedCredential
+		}
+		c.Credentials = masked
+	}
+	return c
+}
+
+// defaultProviderConfigs are created on first start and can be disabled but
+// not deleted
+var defaultProviderConfigs = []ProviderConfig{
+	{Name: ProviderGoogle, Type: ProviderGoogle, Enabled: true},
+	{Name: ProviderTikTok, Type: ProviderTikTok, Enabled: true},
+}
+
+// ProviderRegistry holds long-lived provider instances built from configs
+// persisted in bbolt. Saving a config rebuilds its instance in place, so
+// changes apply without a restart.
+type ProviderRegistry struct {
+	db  *bbolt.DB
+	env *ProviderEnv
+
+	mu        sync.RWMutex
+	configs   map[string]ProviderConfig
+	providers map[string]Provider
+	errors    map[string]error // why a stored config could not be built
+}
+
+// NewProviderRegistry loads provider configs from db, creating the built-in
+// ones on first start. Providers validate voice IDs against catalog.
+func NewProviderRegistry(db *bbolt.DB, catalog *VoiceCatalog) (*ProviderRegistry, error) {
+	r := &ProviderRegistry{
+		db:        db,
+		configs:   make(map[string]ProviderConfig),
+		providers: make(map[string]Provider),
+		errors:    make(map[string]error),
+	}
+
+	var stored []ProviderConfig
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ProvidersBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var config ProviderConfig
+			if err := json.Unmarshal(v, &config); err != nil {
+				log.Printf("Error unmarshaling provider config %q: %v", string(k), err)
+				return nil
+			}
+			stored = append(stored, config)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions, err := NewTikTokSessionPool(db)
+	if err != nil {
+		return nil, fmt.Errorf("load TikTok sessions: %w", err)
+	}
+	r.env = &ProviderEnv{TikTokSessions: sessions, Catalog: catalog}
+
+	for _, config := range stored {
+		r.load(config)
+	}
+
+	for _, config := range defaultProviderConfigs {
+		if _, exists := r.configs[config.Name]; exists {
+			continue
+		}
+		if err := r.Save(config); err != nil {
+			return nil, fmt.Errorf("create default provider %s: %w", config.Name, err)
+		}
+	}
+
+	log.Printf("Provider registry: Loaded %d provider(s)", len(r.configs))
+	return r, nil
+}
+
+// load builds a stored config, keeping it even if it no longer builds so
+// it can still be fixed through the API
+func (r *ProviderRegistry) load(config ProviderConfig) {
+	r.configs[config.Name] = config
+	provider, err := buildProvider(config, r.env)
+	if err != nil {
+		log.Printf("Provider registry: Cannot build %s - %v", config.Name, err)
+		r.errors[config.Name] = err
+		return
+	}
+	r.providers[config.Name] = provider
+}
+
+// buildProvider validates a config and constructs its provider
+func buildProvider(config ProviderConfig, env *ProviderEnv) (Provider, error) {
+	if !providerNamePattern.MatchString(config.Name) {
+		return nil, fmt.Errorf("invalid provider name %q (use lowercase letters, digits, - and _)", config.Name)
+	}
+	constructor, exists := ProviderFactory[config.Type]
+	if !exists {
+		return nil, fmt.Errorf("unknown provider type %q", config.Type)
+	}
+	return constructor(config, env)
+}
+
+// Get returns the provider instance registered under name
+func (r *ProviderRegistry) Get(name string) (Provider, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	config, exists := r.configs[name]
+	if !exists {
+		return nil, fmt.Errorf("provider %s not found", name)
+	}
+	if !config.Enabled {
+		return nil, fmt.Errorf("provider %s is disabled", name)
+	}
+	if err := r.errors[name]; err != nil {
+		return nil, fmt.Errorf("provider %s is misconfigured: %v", name, err)
+	}
+	return r.providers[name], nil
+}
+
+// Config returns the stored config of a provider
+func (r *ProviderRegistry) Config(name string) (ProviderConfig, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	config, exists := r.configs[name]
+	return config, exists
+}
+
+// List returns every provider config, sorted by name
+func (r *ProviderRegistry) List() []ProviderConfig {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	configs := make([]ProviderConfig, 0, len(r.configs))
+	for _, config := range r.configs {
+		configs = append(configs, config)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return configs[i].Name < configs[j].Name
+	})
+	return configs
+}
+
+// Save validates, persists and applies a provider config. When updating,
+// credentials are merged with the stored ones (see mergeCredentials).
+func (r *ProviderRegistry) Save(config ProviderConfig) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.configs[config.Name]; exists {
+		if existing.Type != config.Type {
+			return fmt.Errorf("provider %s is of type %s, its type cannot be changed", config.Name, existing.Type)
+		}
+		config.Credentials = mergeCredentials(existing.Credentials, config.Credentials)
+	}
+
+	provider, err := buildProvider(config, r.env)
+	if err != nil {
+		return err
+	}
+	if err := r.persist(config); err != nil {
+		return err
+	}
+
+	r.configs[config.Name] = config
+	r.providers[config.Name] = provider
+	delete(r.errors, config.Name)
+
+	log.Printf("Provider registry: Saved %s (type: %s, enabled: %v)", config.Name, config.Type, config.Enabled)
+	return nil
+}
+
+// persist writes a provider config to the database
+func (r *ProviderRegistry) persist(config ProviderConfig) error {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return fmt.Errorf("marshal provider config: %w", err)
+	}
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(ProvidersBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.Put([]byte(config.Name), data)
+	})
+}
+
+// Catalog returns the voice catalog providers validate against
+func (r *ProviderRegistry) Catalog() *VoiceCatalog {
+	return r.env.Catalog
+}
+
+// TikTokSessions returns the session pool shared by TikTok providers
+func (r *ProviderRegistry) TikTokSessions() *TikTokSessionPool {
+	return r.env.TikTokSessions
+}
+
+// mergeCredentials applies updated credentials over the stored ones. A nil
+// update keeps everything, a masked value keeps that entry and an empty
+// value removes it.
+func mergeCredentials(stored, update map[string]string) map[string]string {
+	if update == nil {
+		return stored
+	}
+	merged := make(map[string]string, len(update))
+	for k, v := range update {
+		switch v {
+		case maskedCredential:
+			if old, ok := stored[k]; ok {
+				merged[k] = old
+			}
+		case "":
+		default:
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// Delete removes a provider. Built-in providers can only be disabled.
+func (r *ProviderRegistry) Delete(name string) error {
+	for _, config := range defaultProviderConfigs {
+		if config.Name == name {
+			return fmt.Errorf("built-in provider %s cannot be deleted, disable it instead", name)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.configs[name]; !exists {
+		return fmt.Errorf("provider %s not found", name)
+	}
+
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(ProvidersBucket))
+		if b == nil {
+			return fmt.Errorf("bucket not found")
+		}
+		return b.Delete([]byte(name))
+	})
+	if err != nil {
+		return err
+	}
+
+	delete(r.configs, name)
+	delete(r.providers, name)
+	delete(r.errors, name)
+
+	log.Printf("Provider registry: Deleted %s", name)
+	return nil
+}
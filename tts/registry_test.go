@@ -0,0 +1,144 @@
+package tts
+
+import (
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+)
+
+func openTestDB(t *testing.T) (*bbolt.DB, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	db, err := bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, path
+}
+
+func TestProviderRegistryPersistsAndHotApplies(t *testing.T) {
+	db, path := openTestDB(t)
+
+	r, err := NewProviderRegistry(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get(ProviderGoogle); err != nil {
+		t.Fatalf("built-in provider missing: %v", err)
+	}
+
+	piper := ProviderConfig{
+		Name:    "piper",
+		Type:    ProviderHTTP,
+		Enabled: true,
+		Host:    "http://localhost:5000",
+		HTTP:    &HTTPProviderConfig{URL: "{{.Host}}/?text={{.Text | urlquery}}"},
+	}
+	if err := r.Save(piper); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := r.Get("piper")
+
+	piper.Host = "http://localhost:5001"
+	if err := r.Save(piper); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := r.Get("piper")
+	if before == after || after.(*HTTPProvider).host != "http://localhost:5001" {
+		t.Errorf("saving did not rebuild the provider instance")
+	}
+
+	piper.Enabled = false
+	if err := r.Save(piper); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get("piper"); err == nil {
+		t.Errorf("disabled provider was returned")
+	}
+
+	db.Close()
+	db, err = bbolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	r, err = NewProviderRegistry(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, ok := r.Config("piper")
+	if !ok || config.Host != "http://localhost:5001" || config.Enabled {
+		t.Errorf("config not restored: %+v", config)
+	}
+}
+
+func TestProviderRegistryCredentials(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+
+	r, err := NewProviderRegistry(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = r.Save(ProviderConfig{
+		Name:        "openai",
+		Type:        ProviderHTTP,
+		Enabled:     true,
+		Credentials: map[string]string{CredentialAuthValue: "Bearer secret"},
+		HTTP:        &HTTPProviderConfig{URL: "http://localhost:8880/v1/audio/speech", AuthHeader: "Authorization"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	masked, _ := r.Config("openai")
+	masked = masked.Masked()
+	if masked.Credentials[CredentialAuthValue] != maskedCredential {
+		t.Fatalf("credential not masked: %v", masked.Credentials)
+	}
+
+	// Sending the masked config back keeps the real value
+	masked.TimeoutMS = 30000
+	if err := r.Save(masked); err != nil {
+		t.Fatal(err)
+	}
+	config, _ := r.Config("openai")
+	if config.Credentials[CredentialAuthValue] != "Bearer secret" {
+		t.Errorf("auth value = %q, want the stored one", config.Credentials[CredentialAuthValue])
+	}
+
+	// An empty value removes the credential
+	config.Credentials = map[string]string{CredentialAuthValue: ""}
+	if err := r.Save(config); err != nil {
+		t.Fatal(err)
+	}
+	if config, _ := r.Config("openai"); len(config.Credentials) != 0 {
+		t.Errorf("credentials = %v, want none", config.Credentials)
+	}
+}
+
+func TestProviderRegistryRejects(t *testing.T) {
+	db, _ := openTestDB(t)
+	defer db.Close()
+
+	r, err := NewProviderRegistry(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Delete(ProviderGoogle); err == nil {
+		t.Errorf("built-in provider was deleted")
+	}
+	if err := r.Save(ProviderConfig{Name: "Bad Name", Type: ProviderGoogle}); err == nil {
+		t.Errorf("invalid name was accepted")
+	}
+	if err := r.Save(ProviderConfig{Name: "x", Type: "espeak"}); err == nil {
+		t.Errorf("unknown type was accepted")
+	}
+	if err := r.Save(ProviderConfig{Name: ProviderGoogle, Type: ProviderHTTP}); err == nil {
+		t.Errorf("type change was accepted")
+	}
+}
@@ -0,0 +1,61 @@
+package tts
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// retryPolicy bounds retries of transient failures and the time a fallback
+// chain may take
+type retryPolicy struct {
+	maxAttempts    int // counting the first try
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	chainTimeout   time.Duration
+}
+
+// defaultRetryPolicy is the policy of every TTSService
+var defaultRetryPolicy = retryPolicy{
+	maxAttempts:    RetryMaxAttempts,
+	initialBackoff: RetryInitialBackoff,
+	maxBackoff:     RetryMaxBackoff,
+	chainTimeout:   FallbackChainTimeout,
+}
+
+// TransientError marks a provider failure that may succeed if retried,
+// such as a network error or an upstream 5xx response. Anything else is
+// treated as permanent for that voice: for example running out of valid
+// TikTok session IDs is not retried and the chain moves straight on to the
+// next voice.
+type TransientError struct {
+	Err error
+}
+
+func (e *TransientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TransientError) Unwrap() error {
+	return e.Err
+}
+
+// transientf formats an error and marks it as transient
+func transientf(format string, args ...interface{}) error {
+	return &TransientError{Err: fmt.Errorf(format, args...)}
+}
+
+// IsTransient reports whether err is worth retrying
+func IsTransient(err error) bool {
+	var transient *TransientError
+	return errors.As(err, &transient)
+}
+
+// checkStatus turns a retryable HTTP status into a transient error
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return transientf("upstream returned %s", resp.Status)
+	}
+	return nil
+}
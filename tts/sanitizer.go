@@ -1,53 +1,34 @@
 package tts
 
 import (
-	"encoding/csv"
-	"os"
-	"path/filepath"
+	"log"
+	"maps"
+	"regexp"
+	"slices"
 	"strings"
+	"sync"
 )
 
 // TextSanitizer provides methods for sanitizing text for TTS
 type TextSanitizer struct {
-	replacements         map[string]string
+	replacements         map[string]string // Added by WithReplacements, on top of the replacement lists
 	providerReplacements map[string]map[string]string
-	slangDictionaries    map[string]map[string]string // Maps language code to slang dictionary
-	blockedWords        map[string]map[string]bool   // Maps language code to set of blocked words
-	loadedLanguages     map[string]bool              // Tracks which languages have been attempted to load
-	loadedBlockLists    map[string]bool              // Tracks which block lists have been attempted to load
+	dataDir              string // Holds the slang_dict, blocked and emoji CSV files
+
+	shippedOnce sync.Once
+	shipped     *ruleSet // Lists read from dataDir, used when no SanitizerRules are in use
+
+	emojiMu     sync.Mutex
+	emoji       EmojiSettings
+	emojiTables map[string]*emojiTable // Maps language code to emoji names, nil when there are none
 }
 
-// NewTextSanitizer creates a new sanitizer with default replacements
+// NewTextSanitizer creates a new sanitizer. It reads its replacement, slang
+// and blocked word lists from the SanitizerRules in use, or else from the
+// lists shipped with the app.
 func NewTextSanitizer() *TextSanitizer {
 	return &TextSanitizer{
-		replacements: map[string]string{
-			"+":  "plus",
-			"&":  "and",
-			"ä":  "ae",
-			"ö":  "oe",
-			"ü":  "ue",
-			"ß":  "ss",
-			"\n": " ", // Convert newlines to spaces
-			"\r": " ", // Convert carriage returns to spaces
-			"\t": " ", // Convert tabs to spaces
-			"$":  "dollar",
-			"€":  "euro",
-			"£":  "pound",
-			"¥":  "yen",
-			"@":  "at",
-			"#":  "hash",
-			"%":  "percent",
-			"=":  "equals",
-			"*":  "asterisk",
-			"~":  "tilde",
-			"^":  "caret",
-			"<":  "less than",
-			">":  "greater than",
-			"|":  "pipe",
-			"\\": "backslash",
-			"\"": "", // Remove quotes
-			"'":  "", // Remove single quotes
-		},
+		replacements: make(map[string]string),
 		providerReplacements: map[string]map[string]string{
 			ProviderGoogle: {
 				// Google-specific replacements
@@ -56,79 +37,60 @@ func NewTextSanitizer() *TextSanitizer {
 				// TikTok-specific replacements
 			},
 		},
-		slangDictionaries: make(map[string]map[string]string),
-		blockedWords:     make(map[string]map[string]bool),
-		loadedLanguages:  make(map[string]bool),
-		loadedBlockLists: make(map[string]bool),
+		dataDir:     SanitizerDataDir,
+		emoji:       DefaultEmojiSettings,
+		emojiTables: make(map[string]*emojiTable),
 	}
 }
 
-// loadSlangDictionary loads a slang dictionary from a CSV file
-func (s *TextSanitizer) loadSlangDictionary(langCode string) error {
-	filepath := filepath.Join("assets", "data", "slang_dict", langCode+".csv")
-	
-	file, err := os.Open(filepath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Mark as attempted to load even if file doesn't exist
-			s.loadedLanguages[langCode] = true
-			return nil // Return nil as this is an expected case
-		}
-		return err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	dict := make(map[string]string)
-
-	records, err := reader.ReadAll()
-	if err != nil {
-		return err
-	}
-
-	for _, record := range records {
-		if len(record) >= 2 {
-			dict[record[0]] = record[1]
-		}
-	}
-
-	s.slangDictionaries[langCode] = dict
-	s.loadedLanguages[langCode] = true
-	return nil
+// rules returns the replacement, slang and blocked word lists in effect.
+// They are read on every message, so saved rules apply right away.
+func (s *TextSanitizer) rules() *ruleSet {
+	if rules := activeRules.Load(); rules != nil {
+		return rules.current.Load()
+	}
+	s.shippedOnce.Do(func() {
+		lists, err := shippedRules(s.dataDir)
+		if err != nil {
+			log.Printf("Error loading sanitizer lists: %v", err)
+			lists = defaultRules()
+		}
+		s.shipped = newRuleSet(lists)
+	})
+	return s.shipped
 }
 
-// loadBlockedWords loads blocked words from a CSV file
-func (s *TextSanitizer) loadBlockedWords(langCode string) error {
-	filepath := filepath.Join("assets", "data", "blocked", langCode+".csv")
-	
-	file, err := os.Open(filepath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Mark as attempted to load even if file doesn't exist
-			s.loadedBlockLists[langCode] = true
-			return nil // Return nil as this is an expected case
+// replacer returns a replacer applying the replacements for lang and
+// provider, and those added by WithReplacements. Longer matches go first,
+// so "<=" is not read as "<" followed by "=".
+func (s *TextSanitizer) replacer(lists ruleLists, lang, provider string) *strings.Replacer {
+	merged := make(map[string]string)
+	for _, rule := range lists.list(RuleReplacements, RulesAllLanguages) {
+		merged[rule.Match] = rule.Replacement
+	}
+	if lang != "" {
+		for _, rule := range lists.list(RuleReplacements, lang) {
+			merged[rule.Match] = rule.Replacement
 		}
-		return err
 	}
-	defer file.Close()
+	maps.Copy(merged, s.providerReplacements[provider])
+	maps.Copy(merged, s.replacements)
 
-	reader := csv.NewReader(file)
-	blockedSet := make(map[string]bool)
-
-	records, err := reader.ReadAll()
-	if err != nil {
-		return err
+	matches := make([]string, 0, len(merged))
+	for match := range merged {
+		matches = append(matches, match)
 	}
-
-	for _, record := range records {
-		if len(record) >= 1 {
-			blockedSet[strings.ToLower(record[0])] = true
+	slices.SortFunc(matches, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
 		}
+		return strings.Compare(a, b)
+	})
+	oldNew := make([]string, 0, 2*len(matches))
+	for _, match := range matches {
+		oldNew = append(oldNew, match, merged[match])
 	}
-
-	s.blockedWords[langCode] = blockedSet
-	s.loadedBlockLists[langCode] = true
-	return nil
+	return strings.NewReplacer(oldNew...)
 }
 
 // getLanguageFromProvider extracts language code from provider string
@@ -140,181 +102,89 @@ func (s *TextSanitizer) getLanguageFromProvider(provider string) string {
 	return ""
 }
 
-// numberWords maps numbers to their word representations
-var numberWords = map[string]string{
-	"0": "zero",
-	"1": "one",
-	"2": "two",
-	// ... etc
-}
-
-// emojiDescriptions maps common emojis to their descriptions
-var emojiDescriptions = map[string]string{
-	"😊": "smiling face",
-	"👍": "thumbs up",
-	"❤️": "heart",
-	// ... etc
-}
-
-// ContainsBlockedWords checks if the text contains any blocked words for the given language
+// ContainsBlockedWords checks if the text contains any blocked words of the
+// "all" list or of the language of the voice ID passed as provider. With no
+// provider only the "all" list is checked. Text and blocked words are
+// normalized alike, so spaced out letters, leetspeak and look-alike letters
+// of other scripts are caught.
 // Returns true and the blocked word if found, false and empty string if not found
 func (s *TextSanitizer) ContainsBlockedWords(text, provider string) (bool, string) {
 	langCode := s.getLanguageFromProvider(provider)
 	if langCode == "" {
-		return false, ""
+		langCode = RulesAllLanguages
 	}
-
-	// Try to load blocked words if not loaded before
-	if !s.loadedBlockLists[langCode] {
-		if err := s.loadBlockedWords(langCode); err != nil {
-			// Log error but continue with processing
-			println("Error loading blocked words for", langCode+":", err.Error())
-			return false, ""
-		}
-	}
-
-	// Check if we have blocked words for this language
-	blockedSet, ok := s.blockedWords[langCode]
-	if !ok {
+	matcher := s.rules().blocker(langCode)
+	if matcher == nil {
 		return false, ""
 	}
-
-	// Convert text to lowercase for case-insensitive comparison
-	text = strings.ToLower(text)
-
-	// First check individual words after removing punctuation
-	cleanText := strings.Map(func(r rune) rune {
-		if strings.ContainsRune("!@#$%^&*()_+-=[]{}|;:'\",.<>?/~`", r) {
-			return ' '
-		}
-		return r
-	}, text)
-
-	words := strings.Fields(cleanText)
-	
-	// Check each individual word
-	for _, word := range words {
-		if blockedSet[word] {
-			return true, word
-		}
+	if rule, found := matcher.find(blockedForm(text, true)); found {
+		return true, rule.Match
 	}
-
-	// Then check for attempts to bypass using punctuation
-	// We'll only combine words that were originally separated by punctuation
-	originalWords := strings.Fields(text)
-	if len(originalWords) <= 1 {
-		return false, ""
-	}
-
-	// Build a map of word positions that had punctuation between them
-	punctuationBetween := make(map[int]bool)
-	currentPos := 0
-	for i := 0; i < len(originalWords)-1; i++ {
-		currentPos += len(originalWords[i])
-		// Skip spaces until we find a non-space
-		for currentPos < len(text) && text[currentPos] == ' ' {
-			currentPos++
-		}
-		// Check if there's punctuation before the next word
-		if currentPos < len(text) {
-			nextChar := text[currentPos]
-			if strings.ContainsRune("!@#$%^&*()_+-=[]{}|;:'\",.<>?/~`", rune(nextChar)) {
-				punctuationBetween[i] = true
-			}
-		}
-		// Move to start of next word
-		for currentPos < len(text) && (text[currentPos] == ' ' || strings.ContainsRune("!@#$%^&*()_+-=[]{}|;:'\",.<>?/~`", rune(text[currentPos]))) {
-			currentPos++
-		}
-	}
-
-	// Only check combinations where there was punctuation between words
-	for i := 0; i < len(originalWords)-1; i++ {
-		if punctuationBetween[i] {
-			combined := strings.Map(func(r rune) rune {
-				if strings.ContainsRune("!@#$%^&*()_+-=[]{}|;:'\",.<>?/~`", r) {
-					return -1
-				}
-				return r
-			}, originalWords[i] + originalWords[i+1])
-			
-			if blockedSet[combined] {
-				return true, combined
-			}
-		}
-	}
-
 	return false, ""
 }
 
-// Sanitize cleans the text for TTS processing
+// Sanitize cleans the text for TTS processing. Numbers and emoji are spelled
+// out in the language of the voice ID passed as provider; with no provider
+// they are kept, symbols and all, for the pass made once the voice is known.
 func (s *TextSanitizer) Sanitize(text string, provider string) string {
-	// Apply common replacements first
-	var oldNew []string
-	for old, new := range s.replacements {
-		oldNew = append(oldNew, old, new)
+	// Handle URLs first, so the numbers in them are not read out
+	words := strings.Fields(text)
+	for i, word := range words {
+		if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
+			words[i] = "link"
+		}
 	}
+	text = strings.Join(words, " ")
 
-	// Apply provider-specific replacements
-	if providerReps, ok := s.providerReplacements[provider]; ok {
-		for old, new := range providerReps {
-			oldNew = append(oldNew, old, new)
-		}
+	// Name emoji, then spell out numbers, currency amounts and the like
+	lang := voiceIDLanguage(provider)
+	if provider != "" {
+		text = s.replaceEmoji(text, lang)
 	}
+	text = Verbalize(text, lang)
 
-	replacer := strings.NewReplacer(oldNew...)
+	// Apply common, language and provider-specific replacements
+	lists := s.rules().lists
+	replacer := s.replacer(lists, lang, provider)
 
 	// Apply replacements and clean up spaces
-	sanitized := replacer.Replace(text)
+	var sanitized string
+	if provider == "" {
+		sanitized = replaceOutside(text, numberTokenPattern, replacer)
+	} else {
+		sanitized = replacer.Replace(text)
+	}
 	sanitized = strings.TrimSpace(sanitized)
 	sanitized = strings.Join(strings.Fields(sanitized), " ") // Normalize spaces
 
-	// Try to load and apply language-specific slang dictionary if not loaded before
+	// Apply slang dictionary replacements
 	langCode := s.getLanguageFromProvider(provider)
-	if langCode != "" && !s.loadedLanguages[langCode] {
-		if err := s.loadSlangDictionary(langCode); err != nil {
-			// Log error but continue with processing
-			println("Error loading slang dictionary for", langCode+":", err.Error())
-		}
-	}
-
-	// Apply slang dictionary replacements if available
-	if dict, ok := s.slangDictionaries[langCode]; ok {
+	if langCode != "" {
 		words := strings.Fields(sanitized)
 		for i, word := range words {
-			if replacement, exists := dict[strings.ToLower(word)]; exists {
-				words[i] = replacement
+			if rule, exists := lists.lookup(RuleSlang, langCode, strings.ToLower(word)); exists {
+				words[i] = rule.Replacement
 			}
 		}
 		sanitized = strings.Join(words, " ")
 	}
 
-	// Handle numbers
-	words := strings.Fields(sanitized)
-	for i, word := range words {
-		if replacement, ok := numberWords[word]; ok {
-			words[i] = replacement
-		}
-	}
-	sanitized = strings.Join(words, " ")
-
-	// Replace emojis with descriptions
-	for emoji, desc := range emojiDescriptions {
-		sanitized = strings.ReplaceAll(sanitized, emoji, desc)
-	}
-
-	// Handle URLs
-	words = strings.Fields(sanitized)
-	for i, word := range words {
-		if strings.HasPrefix(word, "http://") || strings.HasPrefix(word, "https://") {
-			words[i] = "link"
-		}
-	}
-	sanitized = strings.Join(words, " ")
-
 	return sanitized
 }
 
+// replaceOutside applies replacer to the parts of text protect does not match
+func replaceOutside(text string, protect *regexp.Regexp, replacer *strings.Replacer) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range protect.FindAllStringIndex(text, -1) {
+		b.WriteString(replacer.Replace(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(replacer.Replace(text[last:]))
+	return b.String()
+}
+
 // WithReplacements adds or updates specific replacements
 func (s *TextSanitizer) WithReplacements(replacements map[string]string) *TextSanitizer {
 	for k, v := range replacements {
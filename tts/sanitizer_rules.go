@@ -0,0 +1,489 @@
+package tts
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.etcd.io/bbolt"
+)
+
+// Kinds of sanitizer rule lists
+const (
+	RuleReplacements = "replacements" // text replaced wherever it occurs, such as "&" with "and"
+	RuleSlang        = "slang"        // words replaced, such as "gpp" with "gak apa apa"
+	RuleBlocked      = "blocked"      // words that keep a message from being spoken
+)
+
+var ruleKinds = []string{RuleReplacements, RuleSlang, RuleBlocked}
+
+// RulesAllLanguages names the lists that apply to voices of every language,
+// next to the lists of their own language
+const RulesAllLanguages = "all"
+
+// ruleLanguagePattern matches the language codes lists are kept under: the
+// first two letters of voice IDs, as in "id" for "id_male_darma"
+var ruleLanguagePattern = regexp.MustCompile(`^[a-z]{2}$`)
+
+// ruleDataDirs are the directories under SanitizerDataDir holding the lists
+// shipped with the app, one CSV per language
+var ruleDataDirs = map[string]string{
+	RuleSlang:   "slang_dict",
+	RuleBlocked: "blocked",
+}
+
+// defaultReplacements are the replacements for every language on first start
+var defaultReplacements = map[string]string{
+	"+":  "plus",
+	"&":  "and",
+	"ä":  "ae",
+	"ö":  "oe",
+	"ü":  "ue",
+	"ß":  "ss",
+	"$":  "dollar",
+	"€":  "euro",
+	"£":  "pound",
+	"¥":  "yen",
+	"@":  "at",
+	"#":  "hash",
+	"%":  "percent",
+	"=":  "equals",
+	"*":  "asterisk",
+	"~":  "tilde",
+	"^":  "caret",
+	"<":  "less than",
+	">":  "greater than",
+	"|":  "pipe",
+	"\\": "backslash",
+	"\"": "", // Remove quotes
+	"'":  "", // Remove single quotes
+}
+
+// SanitizerRule is an entry of a sanitizer list. Blocked words have a mode
+// instead of a replacement.
+type SanitizerRule struct {
+	Match       string `json:"match"`
+	Replacement string `json:"replacement,omitempty"`
+	Mode        string `json:"mode,omitempty"` // BlockWholeWord or BlockSubstring
+}
+
+// RuleList describes one stored list
+type RuleList struct {
+	Kind  string `json:"kind"`
+	Lang  string `json:"lang"`
+	Rules int    `json:"rules"`
+}
+
+// ruleLists holds every list by kind, language and matched text. A
+// ruleLists is never changed once in use; edits make a new one.
+type ruleLists map[string]map[string]map[string]SanitizerRule
+
+// ruleSet is the lists in effect, with the blocked word matchers built from
+// them on first use
+type ruleSet struct {
+	lists    ruleLists
+	mu       sync.Mutex
+	blockers map[string]*blockMatcher // Maps language code to matcher, nil when it has no blocked words
+}
+
+func newRuleSet(lists ruleLists) *ruleSet {
+	return &ruleSet{lists: lists, blockers: make(map[string]*blockMatcher)}
+}
+
+// list returns the rules of a kind and language, nil when there are none
+func (l ruleLists) list(kind, lang string) map[string]SanitizerRule {
+	return l[kind][lang]
+}
+
+// with returns a copy of l with the list of a kind and language replaced
+func (l ruleLists) with(kind, lang string, list map[string]SanitizerRule) ruleLists {
+	next := maps.Clone(l)
+	if next == nil {
+		next = make(ruleLists)
+	}
+	next[kind] = maps.Clone(next[kind])
+	if next[kind] == nil {
+		next[kind] = make(map[string]map[string]SanitizerRule)
+	}
+	if len(list) == 0 {
+		delete(next[kind], lang)
+	} else {
+		next[kind][lang] = list
+	}
+	return next
+}
+
+// lookup returns the rule matching word in the list of a kind for lang,
+// falling back to the list for every language
+func (l ruleLists) lookup(kind, lang, word string) (SanitizerRule, bool) {
+	if rule, ok := l.list(kind, lang)[word]; ok {
+		return rule, true
+	}
+	rule, ok := l.list(kind, RulesAllLanguages)[word]
+	return rule, ok
+}
+
+// ValidateRuleList checks that a kind and language name a list
+func ValidateRuleList(kind, lang string) error {
+	if !slices.Contains(ruleKinds, kind) {
+		return fmt.Errorf("unknown list %q (use %s)", kind, strings.Join(ruleKinds, ", "))
+	}
+	if lang != RulesAllLanguages && !ruleLanguagePattern.MatchString(lang) {
+		return fmt.Errorf("invalid language %q (use a two-letter code or %s)", lang, RulesAllLanguages)
+	}
+	return nil
+}
+
+// normalizeRule checks a rule for a list and puts it in stored form: slang
+// and blocked words are matched case-insensitively, and only blocked words
+// have a mode, by default BlockWholeWord
+func normalizeRule(kind, lang string, rule SanitizerRule) (SanitizerRule, error) {
+	if err := ValidateRuleList(kind, lang); err != nil {
+		return rule, err
+	}
+	if kind != RuleReplacements {
+		rule.Match = strings.ToLower(strings.TrimSpace(rule.Match))
+	}
+	if rule.Match == "" {
+		return rule, fmt.Errorf("match is required")
+	}
+	if kind != RuleBlocked {
+		rule.Mode = ""
+		return rule, nil
+	}
+	rule.Replacement = ""
+	if rule.Mode == "" {
+		rule.Mode = BlockWholeWord
+	}
+	if !slices.Contains(blockModes, rule.Mode) {
+		return rule, fmt.Errorf("unknown mode %q (use %s)", rule.Mode, strings.Join(blockModes, ", "))
+	}
+	return rule, nil
+}
+
+// ruleKey is the bbolt key of a rule
+func ruleKey(kind, lang, match string) []byte {
+	return []byte(kind + "/" + lang + "/" + match)
+}
+
+// parseRuleCSV reads a list in the format of the CSV files shipped with the
+// app: match and replacement for replacements and slang, a word per row for
+// blocked words, optionally followed by its mode
+func parseRuleCSV(kind, lang string, r io.Reader) ([]SanitizerRule, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var rules []SanitizerRule
+	for i, record := range records {
+		if len(record) == 0 || (len(record) == 1 && strings.TrimSpace(record[0]) == "") {
+			continue
+		}
+		rule := SanitizerRule{Match: record[0]}
+		if kind == RuleBlocked && len(record) > 1 {
+			rule.Mode = strings.TrimSpace(record[1])
+		} else if kind != RuleBlocked {
+			if len(record) < 2 {
+				return nil, fmt.Errorf("row %d: want a match and a replacement", i+1)
+			}
+			rule.Replacement = record[1]
+		}
+		if rule, err = normalizeRule(kind, lang, rule); err != nil {
+			return nil, fmt.Errorf("row %d: %w", i+1, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// defaultRules returns lists holding only the default replacements
+func defaultRules() ruleLists {
+	replacements := make(map[string]SanitizerRule, len(defaultReplacements))
+	for match, replacement := range defaultReplacements {
+		replacements[match] = SanitizerRule{Match: match, Replacement: replacement}
+	}
+	return ruleLists{}.with(RuleReplacements, RulesAllLanguages, replacements)
+}
+
+// shippedRules returns the lists shipped with the app: the default
+// replacements, and the slang and blocked CSV files under dataDir
+func shippedRules(dataDir string) (ruleLists, error) {
+	lists := defaultRules()
+	for _, kind := range []string{RuleSlang, RuleBlocked} {
+		paths, err := filepath.Glob(filepath.Join(dataDir, ruleDataDirs[kind], "*.csv"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			lang := strings.TrimSuffix(filepath.Base(path), ".csv")
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			rules, err := parseRuleCSV(kind, lang, file)
+			file.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			list := make(map[string]SanitizerRule, len(rules))
+			for _, rule := range rules {
+				list[rule.Match] = rule
+			}
+			lists = lists.with(kind, lang, list)
+		}
+	}
+	return lists, nil
+}
+
+// SanitizerRules keeps the replacement, slang and blocked word lists in
+// bbolt. Once passed to UseSanitizerRules, every TextSanitizer reads them,
+// and saved rules apply from the next message on.
+type SanitizerRules struct {
+	db      *bbolt.DB
+	mu      sync.Mutex // serializes edits
+	current atomic.Pointer[ruleSet]
+}
+
+// NewSanitizerRules loads the stored lists, importing the ones shipped with
+// the app under dataDir on first start
+func NewSanitizerRules(db *bbolt.DB, dataDir string) (*SanitizerRules, error) {
+	r := &SanitizerRules{db: db}
+	lists := make(ruleLists)
+	count := 0
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(SanitizerRulesBucket))
+		if b == nil {
+			shipped, err := shippedRules(dataDir)
+			if err != nil {
+				return fmt.Errorf("import shipped sanitizer lists: %w", err)
+			}
+			if b, err = tx.CreateBucket([]byte(SanitizerRulesBucket)); err != nil {
+				return fmt.Errorf("create bucket: %w", err)
+			}
+			for kind, languages := range shipped {
+				for lang, list := range languages {
+					for _, rule := range list {
+						if err := putRule(b, kind, lang, rule); err != nil {
+							return err
+						}
+					}
+				}
+			}
+			log.Printf("Sanitizer rules: Imported the lists shipped with the app")
+		}
+		return b.ForEach(func(k, v []byte) error {
+			kind, rest, _ := strings.Cut(string(k), "/")
+			lang, _, _ := strings.Cut(rest, "/")
+			var rule SanitizerRule
+			if err := json.Unmarshal(v, &rule); err != nil {
+				log.Printf("Error unmarshaling sanitizer rule %q: %v", string(k), err)
+				return nil
+			}
+			if lists[kind] == nil {
+				lists[kind] = make(map[string]map[string]SanitizerRule)
+			}
+			if lists[kind][lang] == nil {
+				lists[kind][lang] = make(map[string]SanitizerRule)
+			}
+			lists[kind][lang][rule.Match] = rule
+			count++
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.current.Store(newRuleSet(lists))
+	log.Printf("Sanitizer rules: Loaded %d rule(s)", count)
+	return r, nil
+}
+
+func putRule(b *bbolt.Bucket, kind, lang string, rule SanitizerRule) error {
+	data, err := json.Marshal(rule)
+	if err != nil {
+		return fmt.Errorf("marshal rule: %w", err)
+	}
+	return b.Put(ruleKey(kind, lang, rule.Match), data)
+}
+
+// lists returns the lists in effect
+func (r *SanitizerRules) lists() ruleLists {
+	return r.current.Load().lists
+}
+
+// Lists describes the stored lists, sorted by kind and language
+func (r *SanitizerRules) Lists() []RuleList {
+	var lists []RuleList
+	for kind, languages := range r.lists() {
+		for lang, list := range languages {
+			lists = append(lists, RuleList{Kind: kind, Lang: lang, Rules: len(list)})
+		}
+	}
+	slices.SortFunc(lists, func(a, b RuleList) int {
+		return strings.Compare(a.Kind+"/"+a.Lang, b.Kind+"/"+b.Lang)
+	})
+	return lists
+}
+
+// List returns the rules of a list sorted by match, empty for lists without
+// rules
+func (r *SanitizerRules) List(kind, lang string) ([]SanitizerRule, error) {
+	if err := ValidateRuleList(kind, lang); err != nil {
+		return nil, err
+	}
+	list := r.lists().list(kind, lang)
+	rules := make([]SanitizerRule, 0, len(list))
+	for _, rule := range list {
+		rules = append(rules, rule)
+	}
+	slices.SortFunc(rules, func(a, b SanitizerRule) int { return strings.Compare(a.Match, b.Match) })
+	return rules, nil
+}
+
+// edit changes the list of a kind and language in the store and in memory.
+// change gets the stored bucket and a copy of the list to update in step.
+func (r *SanitizerRules) edit(kind, lang string, change func(b *bbolt.Bucket, list map[string]SanitizerRule) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current := r.lists()
+	list := maps.Clone(current.list(kind, lang))
+	if list == nil {
+		list = make(map[string]SanitizerRule)
+	}
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(SanitizerRulesBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return change(b, list)
+	})
+	if err != nil {
+		return err
+	}
+	r.current.Store(newRuleSet(current.with(kind, lang, list)))
+	return nil
+}
+
+// Save adds a rule to a list or replaces the one with the same match, and
+// returns it as stored
+func (r *SanitizerRules) Save(kind, lang string, rule SanitizerRule) (SanitizerRule, error) {
+	rule, err := normalizeRule(kind, lang, rule)
+	if err != nil {
+		return rule, err
+	}
+	err = r.edit(kind, lang, func(b *bbolt.Bucket, list map[string]SanitizerRule) error {
+		list[rule.Match] = rule
+		return putRule(b, kind, lang, rule)
+	})
+	if err != nil {
+		return rule, err
+	}
+	log.Printf("Sanitizer rules: Saved %s/%s %q", kind, lang, rule.Match)
+	return rule, nil
+}
+
+// ErrRuleNotFound is returned for rules that are not in their list
+var ErrRuleNotFound = errors.New("rule not found")
+
+// Delete removes the rule with a match from a list
+func (r *SanitizerRules) Delete(kind, lang, match string) error {
+	rule, err := normalizeRule(kind, lang, SanitizerRule{Match: match})
+	if err != nil {
+		return err
+	}
+	err = r.edit(kind, lang, func(b *bbolt.Bucket, list map[string]SanitizerRule) error {
+		if _, ok := list[rule.Match]; !ok {
+			return ErrRuleNotFound
+		}
+		delete(list, rule.Match)
+		return b.Delete(ruleKey(kind, lang, rule.Match))
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("Sanitizer rules: Deleted %s/%s %q", kind, lang, rule.Match)
+	return nil
+}
+
+// Import adds the rules of a CSV file in the format of the files shipped
+// with the app to a list, replacing rules with the same match. With replace
+// set, the rules not in the file are removed. It returns how many rules
+// were read.
+func (r *SanitizerRules) Import(kind, lang string, csvData io.Reader, replace bool) (int, error) {
+	if err := ValidateRuleList(kind, lang); err != nil {
+		return 0, err
+	}
+	rules, err := parseRuleCSV(kind, lang, csvData)
+	if err != nil {
+		return 0, err
+	}
+	err = r.edit(kind, lang, func(b *bbolt.Bucket, list map[string]SanitizerRule) error {
+		if replace {
+			for match := range list {
+				if err := b.Delete(ruleKey(kind, lang, match)); err != nil {
+					return err
+				}
+				delete(list, match)
+			}
+		}
+		for _, rule := range rules {
+			list[rule.Match] = rule
+			if err := putRule(b, kind, lang, rule); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Printf("Sanitizer rules: Imported %d rule(s) into %s/%s (replace: %v)", len(rules), kind, lang, replace)
+	return len(rules), nil
+}
+
+// Export writes a list as CSV in the format Import reads
+func (r *SanitizerRules) Export(kind, lang string, w io.Writer) error {
+	rules, err := r.List(kind, lang)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	for _, rule := range rules {
+		record := []string{rule.Match}
+		switch {
+		case kind != RuleBlocked:
+			record = append(record, rule.Replacement)
+		case rule.Mode == BlockSubstring:
+			// Whole words are left as in the shipped files
+			record = append(record, rule.Mode)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// activeRules is the store every TextSanitizer reads, see UseSanitizerRules
+var activeRules atomic.Pointer[SanitizerRules]
+
+// UseSanitizerRules makes every TextSanitizer, including ones already
+// created, read its lists from rules
+func UseSanitizerRules(rules *SanitizerRules) {
+	activeRules.Store(rules)
+}
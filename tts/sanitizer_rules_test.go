@@ -0,0 +1,140 @@
+package tts
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestRules(t *testing.T) *SanitizerRules {
+	t.Helper()
+	db, _ := openTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	rules, err := NewSanitizerRules(db, filepath.Join("..", SanitizerDataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseSanitizerRules(rules)
+	t.Cleanup(func() { UseSanitizerRules(nil) })
+	return rules
+}
+
+func TestSanitizerRulesSeedAndPersist(t *testing.T) {
+	rules := newTestRules(t)
+
+	counts := make(map[string]int)
+	for _, list := range rules.Lists() {
+		counts[list.Kind+"/"+list.Lang] = list.Rules
+	}
+	if counts["replacements/all"] != len(defaultReplacements) || counts["slang/id"] == 0 || counts["blocked/id"] == 0 {
+		t.Fatalf("shipped lists not imported: %v", counts)
+	}
+
+	if _, err := rules.Save(RuleSlang, "xx1", SanitizerRule{Match: "a", Replacement: "b"}); err == nil {
+		t.Error("invalid language accepted")
+	}
+	if _, err := rules.Save(RuleSlang, LangEnglish, SanitizerRule{Replacement: "b"}); err == nil {
+		t.Error("rule without match accepted")
+	}
+	saved, err := rules.Save(RuleSlang, LangEnglish, SanitizerRule{Match: " BRB ", Replacement: "be right back"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Match != "brb" {
+		t.Errorf("slang match stored as %q", saved.Match)
+	}
+	if err := rules.Delete(RuleReplacements, RulesAllLanguages, "~"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rules.Delete(RuleReplacements, RulesAllLanguages, "~"); !errors.Is(err, ErrRuleNotFound) {
+		t.Errorf("deleting twice gave %v", err)
+	}
+
+	// A restart keeps the edits and does not import the shipped lists again
+	reopened, err := NewSanitizerRules(rules.db, filepath.Join("..", SanitizerDataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, _ := reopened.List(RuleSlang, LangEnglish)
+	if len(list) != 1 || list[0] != saved {
+		t.Errorf("restarted store has en slang %v", list)
+	}
+	if list, _ := reopened.List(RuleReplacements, RulesAllLanguages); len(list) != len(defaultReplacements)-1 {
+		t.Errorf("restarted store has %d replacements", len(list))
+	}
+}
+
+func TestSanitizerRulesImportExport(t *testing.T) {
+	rules := newTestRules(t)
+
+	const slang = "gpp,gak apa apa\n\"btw\",\"by the way, anyway\"\n"
+	n, err := rules.Import(RuleSlang, "ms", strings.NewReader(slang), false)
+	if err != nil || n != 2 {
+		t.Fatalf("Import = %d, %v", n, err)
+	}
+	var out strings.Builder
+	if err := rules.Export(RuleSlang, "ms", &out); err != nil {
+		t.Fatal(err)
+	}
+	if want := "btw,\"by the way, anyway\"\ngpp,gak apa apa\n"; out.String() != want {
+		t.Errorf("Export = %q, want %q", out.String(), want)
+	}
+
+	if _, err := rules.Import(RuleSlang, "ms", strings.NewReader("wkwk\n"), false); err == nil {
+		t.Error("slang row without replacement accepted")
+	}
+	if _, err := rules.Import(RuleBlocked, "ms", strings.NewReader("Bodoh\ngoblok\n"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rules.Import(RuleBlocked, "ms", strings.NewReader("tolol\nkontol,substring\n"), true); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	rules.Export(RuleBlocked, "ms", &out)
+	if want := "kontol,substring\ntolol\n"; out.String() != want {
+		t.Errorf("replacing import left %q, want %q", out.String(), want)
+	}
+	if _, err := rules.Import(RuleBlocked, "ms", strings.NewReader("bodoh,prefix\n"), false); err == nil {
+		t.Error("unknown blocked word mode accepted")
+	}
+}
+
+func TestSanitizerRulesHotReload(t *testing.T) {
+	// Created before the rules are in use, and used before any edit
+	s := NewTextSanitizer()
+	rules := newTestRules(t)
+	if got := s.Sanitize("brb & hi", "en_us_001"); got != "brb and hi" {
+		t.Fatalf("Sanitize = %q", got)
+	}
+
+	if _, err := rules.Save(RuleSlang, LangEnglish, SanitizerRule{Match: "brb", Replacement: "be right back"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rules.Save(RuleReplacements, RulesAllLanguages, SanitizerRule{Match: "&", Replacement: "n"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rules.Save(RuleReplacements, LangEnglish, SanitizerRule{Match: "w/", Replacement: "with"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Sanitize("brb & hi w/ you", "en_us_001"); got != "be right back n hi with you" {
+		t.Errorf("after saving, Sanitize = %q", got)
+	}
+	// Language lists only apply to their voices
+	if got := s.Sanitize("w/", "id_male_darma"); got != "w/" {
+		t.Errorf("en replacement applied to id voice: %q", got)
+	}
+
+	if blocked, _ := s.ContainsBlockedWords("what a dweeb", "en_us_001"); blocked {
+		t.Fatal("blocked before the word was added")
+	}
+	if _, err := rules.Save(RuleBlocked, RulesAllLanguages, SanitizerRule{Match: "Dweeb"}); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, word := s.ContainsBlockedWords("what a dweeb", "en_us_001"); !blocked || word != "dweeb" {
+		t.Errorf("ContainsBlockedWords = %v, %q", blocked, word)
+	}
+	if blocked, _ := s.ContainsBlockedWords("anjing", "id_male_darma"); !blocked {
+		t.Error("shipped blocked word not found")
+	}
+}
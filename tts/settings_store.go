@@ -0,0 +1,54 @@
+package tts
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"go.etcd.io/bbolt"
+)
+
+// storeSetting persists a setting as JSON under key in bucket. It does
+// nothing without a database.
+func storeSetting(db *bbolt.DB, bucket, key string, value interface{}) error {
+	if db == nil {
+		return nil
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("marshal %s settings: %w", key, err)
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.Put([]byte(key), data)
+	})
+}
+
+// loadSetting reads the setting stored under key in bucket into value. It
+// reports false when none is stored or the stored one does not decode.
+func loadSetting(db *bbolt.DB, bucket, key string, value interface{}) (bool, error) {
+	if db == nil {
+		return false, nil
+	}
+	found := false
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		data := b.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		if err := json.Unmarshal(data, value); err != nil {
+			log.Printf("Ignoring invalid stored %s settings %s", key, string(data))
+			return nil
+		}
+		found = true
+		return nil
+	})
+	return found, err
+}
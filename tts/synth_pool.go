@@ -0,0 +1,115 @@
+package tts
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// synthJob is a tts update waiting for its audio
+type synthJob struct {
+	id       string // job and queue item ID
+	seq      uint64
+	ctx      context.Context // cancelled by clear_tts
+	data     map[string]interface{}
+	text     string
+	avatarID string
+	priority int
+	queuedAt time.Time
+	admitted *admission // what moderation recorded, revoked if synthesis fails
+
+	item *TTSQueueItem // set once synthesis succeeded
+}
+
+// synthPool bounds how many messages are synthesized at once, overall and
+// per provider, and hands finished jobs on in the order they were added so
+// a quick message never overtakes a slow one that arrived before it
+type synthPool struct {
+	slots       chan struct{} // one per job synthesizing
+	perProvider int
+	maxPending  int
+
+	mu        sync.Mutex
+	providers map[string]chan struct{}
+	next      uint64 // sequence number of the next job added
+	release   uint64 // sequence number of the next job handed on
+	done      map[uint64]*synthJob
+}
+
+func newSynthPool(workers, perProvider, maxPending int) *synthPool {
+	return &synthPool{
+		slots:       make(chan struct{}, workers),
+		perProvider: perProvider,
+		maxPending:  maxPending,
+		providers:   make(map[string]chan struct{}),
+		done:        make(map[uint64]*synthJob),
+	}
+}
+
+// add numbers a new job. It reports false when maxPending jobs are already
+// waiting to be handed on.
+func (p *synthPool) add(job *synthJob) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if int(p.next-p.release) >= p.maxPending {
+		return false
+	}
+	job.seq = p.next
+	p.next++
+	return true
+}
+
+// pending returns how many added jobs have not been handed on yet
+func (p *synthPool) pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int(p.next - p.release)
+}
+
+// acquire waits for a free slot for provider and returns the function that
+// gives it back. Waiting for the provider comes first, so jobs held up by a
+// busy provider do not keep other providers from running.
+func (p *synthPool) acquire(ctx context.Context, provider string) (func(), error) {
+	p.mu.Lock()
+	sem, ok := p.providers[provider]
+	if !ok {
+		sem = make(chan struct{}, p.perProvider)
+		p.providers[provider] = sem
+	}
+	p.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	select {
+	case p.slots <- struct{}{}:
+	case <-ctx.Done():
+		<-sem
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-p.slots
+		<-sem
+	}, nil
+}
+
+// finish records a finished job, successful or not, and passes every job
+// that is now next in line to deliver, in the order they were added
+func (p *synthPool) finish(job *synthJob, deliver func(*synthJob)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done[job.seq] = job
+	for {
+		next, ok := p.done[p.release]
+		if !ok {
+			return
+		}
+		delete(p.done, p.release)
+		p.release++
+		deliver(next)
+	}
+}
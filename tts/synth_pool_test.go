@@ -0,0 +1,144 @@
+package tts
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oristarium/orionchat/types"
+)
+
+func TestSynthesisRunsAheadInArrivalOrder(t *testing.T) {
+	var mu sync.Mutex
+	active, peak := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		peak = max(peak, active)
+		mu.Unlock()
+
+		// The first message is by far the slowest to synthesize
+		if r.URL.Query().Get("text") == "satu" {
+			time.Sleep(300 * time.Millisecond)
+		} else {
+			time.Sleep(30 * time.Millisecond)
+		}
+
+		mu.Lock()
+		active--
+		mu.Unlock()
+		w.Write(testWAV(1600))
+	}))
+	defer server.Close()
+
+	db, _ := openTestDB(t)
+	defer db.Close()
+	registry, err := NewProviderRegistry(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = registry.Save(ProviderConfig{Name: "piper", Type: ProviderHTTP, Enabled: true,
+		HTTP: &HTTPProviderConfig{URL: server.URL + "/?text={{.Text | urlquery}}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm, err := NewTTSMiddleware(NewTTSService(registry, nil), db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.SetPaused(true) // keep items in the queue, no avatar is listening
+	tm.clients[&websocket.Conn{}] = "avatar"
+	tm.avatarVoices = func(string) ([]types.TTSVoice, error) {
+		return []types.TTSVoice{{VoiceID: "any", Provider: "piper"}}, nil
+	}
+
+	texts := []string{"satu", "dua", "tiga", "empat", "lima"}
+	var jobIDs []string
+	for _, text := range texts {
+		start := time.Now()
+		jobID, _ := tm.InterceptTTS("tts", map[string]interface{}{
+			"content": map[string]interface{}{"sanitized": text},
+		}, nil, "")
+		jobIDs = append(jobIDs, jobID)
+		if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+			t.Errorf("InterceptTTS blocked for %v", elapsed)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for tm.synth.pending() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var queued []string
+	tm.queueMux.Lock()
+	for _, item := range tm.queue {
+		queued = append(queued, item.view(0).Text)
+	}
+	tm.queueMux.Unlock()
+	if got := strings.Join(queued, ","); got != strings.Join(texts, ",") {
+		t.Errorf("queued %s, want %s", got, strings.Join(texts, ","))
+	}
+	if peak > SynthesisPerProvider {
+		t.Errorf("%d requests ran at once, the limit is %d", peak, SynthesisPerProvider)
+	}
+
+	job, err := tm.Job(jobIDs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.State != JobQueued || job.AvatarID != "avatar" || job.VoiceID != "any" || job.SynthesizedAt == nil {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	if err := tm.RemoveQueueItem(jobIDs[1]); err != nil {
+		t.Fatal(err)
+	}
+	tm.InterceptTTS("clear_tts", nil, nil, "")
+	for i, want := range []TTSJobState{JobCancelled, JobCancelled} {
+		job, _ := tm.Job(jobIDs[i+1])
+		if job.State != want || job.Error == "" || job.FinishedAt == nil {
+			t.Errorf("job %d: %+v, want %s with a reason", i+1, job, want)
+		}
+	}
+
+	// Updates rejected up front still get a job that says why
+	jobID, _ := tm.InterceptTTS("tts", map[string]interface{}{}, nil, "")
+	if job, _ := tm.Job(jobID); job.State != JobFailed || job.Error != "message has no text" {
+		t.Errorf("empty message: %+v", job)
+	}
+	if _, err := tm.Job("unknown"); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("unknown job: %v", err)
+	}
+}
+
+func TestSynthPoolLimitsPending(t *testing.T) {
+	pool := newSynthPool(1, 1, 2)
+	first, second := &synthJob{}, &synthJob{}
+	if !pool.add(first) || !pool.add(second) {
+		t.Fatal("jobs within the limit were refused")
+	}
+	if pool.add(&synthJob{}) {
+		t.Error("a job past the limit was accepted")
+	}
+
+	var delivered []uint64
+	deliver := func(job *synthJob) { delivered = append(delivered, job.seq) }
+	pool.finish(second, deliver)
+	if len(delivered) != 0 {
+		t.Fatalf("job %v was handed on before the one added earlier", delivered)
+	}
+	pool.finish(first, deliver)
+	if len(delivered) != 2 || delivered[0] != 0 || delivered[1] != 1 || pool.pending() != 0 {
+		t.Errorf("delivered %v, %d pending", delivered, pool.pending())
+	}
+	if !pool.add(&synthJob{}) {
+		t.Error("no room after jobs were handed on")
+	}
+}
@@ -1,7 +1,9 @@
 package tts
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -11,73 +13,124 @@ import (
 )
 
 const (
-	tikTokAPIBaseURL = "https://api16-normal-v6.tiktokv.com/media/api/text/speech/invoke/"
-	tikTokUserAgent  = "com.zhiliaoapp.musically/2022600030 (Linux; U; Android 7.1.2; es_ES; SM-G988N; Build/NRD90M;tt-ok/3.12.13.1)"
+	tikTokDefaultHost           = "https://api16-normal-v6.tiktokv.com"
+	tikTokAPIPath               = "/media/api/text/speech/invoke/"
+	tikTokInvalidSessionMessage = "Couldn't load speech. Try again."
+	tikTokValidationText        = "test"
+	tikTokValidationVoice       = "en_us_002"
+	tikTokUserAgent             = "com.zhiliaoapp.musically/2022600030 (Linux; U; Android 7.1.2; es_ES; SM-G988N; Build/NRD90M;tt-ok/3.12.13.1)"
 )
 
 // TikTokProvider implements the Provider interface for TikTok TTS
 type TikTokProvider struct {
+	name      string
+	catalog   *VoiceCatalog
+	host      string
+	sessions  *TikTokSessionPool
 	client *http.Client
-	sanitizer *TextSanitizer
 }
 
 // NewTikTokProvider creates a new TikTok provider instance
-func NewTikTokProvider() Provider {
-	log.Printf("Initializing %s provider", ProviderTikTok)
-	return &TikTokProvider{
-		client: &http.Client{},
-		sanitizer: NewTextSanitizer(),
+func NewTikTokProvider(config ProviderConfig, env *ProviderEnv) (Provider, error) {
+	log.Printf("Initializing %s provider %s", ProviderTikTok, config.Name)
+	if env == nil || env.TikTokSessions == nil {
+		return nil, fmt.Errorf("no TikTok session pool available")
 	}
+	return &TikTokProvider{
+		name:      config.Name,
+		catalog:   env.catalog(),
+		host:      strings.TrimRight(config.HostOr(tikTokDefaultHost), "/"),
+		sessions:  env.TikTokSessions,
+		client: &http.Client{Timeout: config.Timeout()},
+	}, nil
 }
 
-// GetAudioBase64 converts text to speech using TikTok's API
-func (p *TikTokProvider) GetAudioBase64(text string, voiceID string, options map[string]interface{}) (string, error) {
+// Synthesize converts text to speech using TikTok's API.
+// TikTok has no speed, pitch or volume parameters, so those are ignored here.
+// Session IDs are taken from the pool in turn; one that TikTok rejects is
+// invalidated and the next one is tried straight away.
+func (p *TikTokProvider) Synthesize(ctx context.Context, request SynthesisRequest) (*SynthesisResponse, error) {
+	text, voiceID := request.Text, request.VoiceID
 	log.Printf("TikTok TTS Request - Text: %q, Voice: %q", text, voiceID)
 
+	if err := checkFormat(request); err != nil {
+		return nil, err
+	}
+
 	// Basic voice ID validation
 	if voiceID == "" {
-		return "", fmt.Errorf("voice ID cannot be empty")
+		return nil, fmt.Errorf("voice ID cannot be empty")
 	}
 
-	// Sanitize text
-	text = p.sanitizer.Sanitize(text, ProviderTikTok)
-
-	// Ensure text is not empty after sanitization
+	// Ensure text is not empty
 	if strings.TrimSpace(text) == "" {
-		return "", fmt.Errorf("text cannot be empty")
+		return nil, fmt.Errorf("text cannot be empty")
+	}
+
+	attempts := len(p.sessions.List())
+	if attempts == 0 {
+		return nil, ErrNoTikTokSessionConfigured
+	}
+	for ; attempts > 0; attempts-- {
+		sessionID, err := p.sessions.Next()
+		if err != nil {
+			return nil, err
+		}
+
+		audio, err := p.request(ctx, sessionID, text, voiceID)
+		if errors.Is(err, errTikTokSessionInvalid) {
+			if _, err := p.sessions.SetValid(sessionID, err); err != nil {
+				log.Printf("TikTok sessions: Failed to store invalidation - %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return newMP3Response(audio), nil
 	}
 
+	return nil, ErrNoTikTokSession
+}
+
+// request performs a single TikTok API call with the given session ID
+func (p *TikTokProvider) request(ctx context.Context, sessionID, text, voiceID string) ([]byte, error) {
 	// Construct request URL with query parameters
-	reqURL := fmt.Sprintf("%s?text_speaker=%s&req_text=%s&speaker_map_type=0&aid=1233",
-		tikTokAPIBaseURL,
+	reqURL := fmt.Sprintf("%s%s?text_speaker=%s&req_text=%s&speaker_map_type=0&aid=1233",
+		p.host, tikTokAPIPath,
 		url.QueryEscape(voiceID),
 		url.QueryEscape(strings.TrimSpace(text)),
 	)
 
 	// Create request
-	req, err := http.NewRequest("POST", reqURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
 	if err != nil {
 		log.Printf("Failed to create request: %v", err)
-		return "", fmt.Errorf("failed to create request: %v", err)
+		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
 	// Set headers
 	req.Header.Set("User-Agent", tikTokUserAgent)
-	req.Header.Set("Cookie", fmt.Sprintf("sessionid=%s", TikTokSessionID))
+	req.Header.Set("Cookie", fmt.Sprintf("sessionid=%s", sessionID))
 
 	// Send request
 	resp, err := p.client.Do(req)
 	if err != nil {
 		log.Printf("Request failed: %v", err)
-		return "", fmt.Errorf("request failed: %v", err)
+		return nil, requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
+	if err := checkStatus(resp); err != nil {
+		log.Printf("Request failed: %v", err)
+		return nil, err
+	}
+
 	// Read response
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("Failed to read response: %v", err)
-		return "", fmt.Errorf("failed to read response: %v", err)
+		return nil, transientf("failed to read response: %v", err)
 	}
 
 	// Parse response
@@ -97,13 +150,13 @@ func (p *TikTokProvider) GetAudioBase64(text string, voiceID string, options map
 	if err := json.Unmarshal(body, &result); err != nil {
 		log.Printf("Failed to parse response: %v", err)
 		log.Printf("Response body: %s", string(body))
-		return "", fmt.Errorf("failed to parse response: %v", err)
+		return nil, fmt.Errorf("failed to parse response: %v", err)
 	}
 
 	// Check for error message
-	if result.Message == "Couldn't load speech. Try again." {
+	if result.Message == tikTokInvalidSessionMessage {
 		log.Printf("TikTok error: Session ID is invalid")
-		return "", fmt.Errorf("session ID is invalid")
+		return nil, errTikTokSessionInvalid
 	}
 
 	// Convert duration to int for logging if needed
@@ -117,17 +170,26 @@ func (p *TikTokProvider) GetAudioBase64(text string, voiceID string, options map
 	log.Printf("TikTok response - Status: %s, Code: %d, Duration: %s, Speaker: %s, LogID: %s",
 		result.Message, result.StatusCode, result.Data.Duration, result.Data.Speaker, result.Extra.LogID)
 
-	return result.Data.VStr, nil
+	audio, err := DecodeAudioBase64(result.Data.VStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode audio: %v", err)
+	}
+	return audio, nil
 }
 
-// GetVoiceIDs returns available voice IDs for TikTok
+// GetVoiceIDs returns the TikTok voices in the catalog
 func (p *TikTokProvider) GetVoiceIDs() []string {
-	// We'll implement this later with the complete list of voice IDs
-	return []string{"en_us_001", "en_us_002", "en_us_006", "en_us_007", "en_us_009", "en_us_010"}
+	if p.catalog == nil {
+		return nil
+	}
+	return p.catalog.VoiceIDs(p.name, ProviderTikTok)
 }
 
-// ValidateVoiceID checks if a voice ID is valid for TikTok
+// ValidateVoiceID checks a voice ID against the catalog. Voices TikTok adds
+// later can be allowed by adding them to the catalog as custom entries.
 func (p *TikTokProvider) ValidateVoiceID(voiceID string) bool {
-	// For now, accept any voice ID since we'll handle validation in the frontend
-	return true
-} 
\ No newline at end of file
+	if p.catalog == nil {
+		return voiceID != ""
+	}
+	return p.catalog.Has(voiceID, p.name, ProviderTikTok)
+}
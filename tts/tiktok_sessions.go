@@ -0,0 +1,300 @@
+package tts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+// ErrNoTikTokSession is returned when every stored session ID is invalid
+var ErrNoTikTokSession = errors.New("no valid TikTok session ID")
+
+// ErrNoTikTokSessionConfigured is returned when no session ID is stored
+var ErrNoTikTokSessionConfigured = errors.New("no TikTok session configured")
+
+// errTikTokSessionInvalid is what TikTok's "Couldn't load speech" means
+var errTikTokSessionInvalid = errors.New("session ID is invalid")
+
+// TikTokSession is a stored TikTok session ID and its health
+type TikTokSession struct {
+	Key           string `json:"key"`
+	SessionID     string `json:"session_id"`
+	Valid         bool   `json:"valid"`
+	AddedAt       int64  `json:"added_at"`
+	InvalidatedAt int64  `json:"invalidated_at,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+}
+
+// Masked returns a copy safe to send to clients, showing only the ends of
+// the session ID
+func (s TikTokSession) Masked() TikTokSession {
+	if len(s.SessionID) > 8 {
+		s.SessionID = s.SessionID[:4] + "…" + s.SessionID[len(s.SessionID)-4:]
+	} else {
+		s.SessionID = maskedCredential
+	}
+	return s
+}
+
+// tikTokSessionKey derives the public key of a session ID, so the ID itself
+// never has to appear in URLs
+func tikTokSessionKey(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+// TikTokSessionPool hands out stored session IDs round-robin, skipping the
+// ones TikTok has rejected
+type TikTokSessionPool struct {
+	db *bbolt.DB
+
+	mu       sync.Mutex
+	sessions []*TikTokSession // in the order they were added
+	next     int
+}
+
+// NewTikTokSessionPool loads stored session IDs from db. The pool starts
+// empty until session IDs are added.
+func NewTikTokSessionPool(db *bbolt.DB) (*TikTokSessionPool, error) {
+	p := &TikTokSessionPool{db: db}
+
+	err := db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(TikTokSessionsBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.ForEach(func(k, v []byte) error {
+			var session TikTokSession
+			if err := json.Unmarshal(v, &session); err != nil {
+				log.Printf("Error unmarshaling TikTok session %q: %v", string(k), err)
+				return nil
+			}
+			p.sessions = append(p.sessions, &session)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(p.sessions, func(i, j int) bool {
+		return p.sessions[i].AddedAt < p.sessions[j].AddedAt
+	})
+
+	log.Printf("TikTok sessions: Loaded %d session ID(s)", len(p.sessions))
+	return p, nil
+}
+
+// Next returns the next valid session ID in round-robin order
+func (p *TikTokSessionPool) Next() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.sessions) == 0 {
+		return "", ErrNoTikTokSessionConfigured
+	}
+
+	for i := 0; i < len(p.sessions); i++ {
+		session := p.sessions[(p.next+i)%len(p.sessions)]
+		if session.Valid {
+			p.next = (p.next + i + 1) % len(p.sessions)
+			return session.SessionID, nil
+		}
+	}
+	return "", ErrNoTikTokSession
+}
+
+// Add stores a new session ID, or re-enables it if it is already stored
+func (p *TikTokSessionPool) Add(sessionID string) (TikTokSession, error) {
+	sessionID = strings.TrimSpace(sessionID)
+	if sessionID == "" {
+		return TikTokSession{}, fmt.Errorf("session ID cannot be empty")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := tikTokSessionKey(sessionID)
+	if session := p.findLocked(key); session != nil {
+		updated := *session
+		updated.Valid = true
+		updated.InvalidatedAt = 0
+		updated.LastError = ""
+		return updated, p.saveLocked(session, updated)
+	}
+
+	session := &TikTokSession{
+		Key:       key,
+		SessionID: sessionID,
+		Valid:     true,
+		AddedAt:   time.Now().UnixNano(),
+	}
+	if err := p.persist(*session); err != nil {
+		return TikTokSession{}, err
+	}
+	p.sessions = append(p.sessions, session)
+	log.Printf("TikTok sessions: Added %s", key)
+	return *session, nil
+}
+
+// Remove deletes a stored session ID by key
+func (p *TikTokSessionPool) Remove(key string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, session := range p.sessions {
+		if session.Key != key {
+			continue
+		}
+		err := p.db.Update(func(tx *bbolt.Tx) error {
+			b := tx.Bucket([]byte(TikTokSessionsBucket))
+			if b == nil {
+				return fmt.Errorf("bucket not found")
+			}
+			return b.Delete([]byte(key))
+		})
+		if err != nil {
+			return err
+		}
+		p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+		if p.next >= len(p.sessions) {
+			p.next = 0
+		}
+		log.Printf("TikTok sessions: Removed %s", key)
+		return nil
+	}
+	return fmt.Errorf("session %s not found", key)
+}
+
+// Get returns a stored session by key
+func (p *TikTokSessionPool) Get(key string) (TikTokSession, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if session := p.findLocked(key); session != nil {
+		return *session, true
+	}
+	return TikTokSession{}, false
+}
+
+// List returns every stored session in the order they are rotated through
+func (p *TikTokSessionPool) List() []TikTokSession {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	sessions := make([]TikTokSession, 0, len(p.sessions))
+	for _, session := range p.sessions {
+		sessions = append(sessions, *session)
+	}
+	return sessions
+}
+
+// SetValid records the outcome of using or validating a session ID. A nil
+// err marks it valid again.
+func (p *TikTokSessionPool) SetValid(sessionID string, err error) (TikTokSession, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	session := p.findLocked(tikTokSessionKey(sessionID))
+	if session == nil {
+		return TikTokSession{}, fmt.Errorf("session not found")
+	}
+
+	updated := *session
+	updated.Valid = err == nil
+	updated.InvalidatedAt = 0
+	updated.LastError = ""
+	if err != nil {
+		updated.InvalidatedAt = time.Now().Unix()
+		updated.LastError = err.Error()
+		log.Printf("TikTok sessions: Invalidated %s - %v", session.Key, err)
+	}
+	return updated, p.saveLocked(session, updated)
+}
+
+func (p *TikTokSessionPool) findLocked(key string) *TikTokSession {
+	for _, session := range p.sessions {
+		if session.Key == key {
+			return session
+		}
+	}
+	return nil
+}
+
+// saveLocked persists updated and applies it to session in memory
+func (p *TikTokSessionPool) saveLocked(session *TikTokSession, updated TikTokSession) error {
+	if err := p.persist(updated); err != nil {
+		return err
+	}
+	*session = updated
+	return nil
+}
+
+func (p *TikTokSessionPool) persist(session TikTokSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return fmt.Errorf("marshal session: %w", err)
+	}
+	return p.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(TikTokSessionsBucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %w", err)
+		}
+		return b.Put([]byte(session.Key), data)
+	})
+}
+
+// ValidateTikTokSession checks a session ID by synthesizing a short phrase
+// through the TikTok provider config, and records the outcome in the pool
+func ValidateTikTokSession(ctx context.Context, pool *TikTokSessionPool, config ProviderConfig, key string) (TikTokSession, error) {
+	session, ok := pool.Get(key)
+	if !ok {
+		return TikTokSession{}, fmt.Errorf("session %s not found", key)
+	}
+
+	p := &TikTokProvider{
+		host:   strings.TrimRight(config.HostOr(tikTokDefaultHost), "/"),
+		client: &http.Client{Timeout: config.Timeout()},
+	}
+	_, err := p.request(ctx, session.SessionID, tikTokValidationText, tikTokValidationVoice)
+
+	// Network trouble says nothing about the session itself
+	if err != nil && !errors.Is(err, errTikTokSessionInvalid) {
+		return session, err
+	}
+	return pool.SetValid(session.SessionID, err)
+}
+
+// TikTokSessionCheck is the outcome of validating one stored session ID
+type TikTokSessionCheck struct {
+	TikTokSession
+	Error string `json:"error,omitempty"` // set when TikTok could not be reached
+}
+
+// ValidateTikTokSessions validates every stored session ID in turn. A
+// session TikTok could not be checked against is left unchanged and carries
+// the error.
+func ValidateTikTokSessions(ctx context.Context, pool *TikTokSessionPool, config ProviderConfig) []TikTokSessionCheck {
+	sessions := pool.List()
+	checks := make([]TikTokSessionCheck, 0, len(sessions))
+	for _, session := range sessions {
+		checked, err := ValidateTikTokSession(ctx, pool, config, session.Key)
+		check := TikTokSessionCheck{TikTokSession: checked}
+		if err != nil {
+			check.TikTokSession = session
+			check.Error = err.Error()
+		}
+		checks = append(checks, check)
+	}
+	return checks
+}
@@ -0,0 +1,182 @@
+package tts
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestSessionPool opens a session pool holding sessionIDs
+func newTestSessionPool(t *testing.T, sessionIDs ...string) *TikTokSessionPool {
+	t.Helper()
+	db, _ := openTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	pool, err := NewTikTokSessionPool(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sessionID := range sessionIDs {
+		if _, err := pool.Add(sessionID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return pool
+}
+
+// fakeTikTok answers like TikTok, rejecting the session IDs in invalid
+func fakeTikTok(t *testing.T, invalid ...string) (*httptest.Server, *[]string) {
+	t.Helper()
+	frame := make([]byte, 96)
+	copy(frame, headerMPEG2Mono)
+	audio := base64.StdEncoding.EncodeToString(append(frame, frame...))
+
+	var mu sync.Mutex
+	var used []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionID := strings.TrimPrefix(r.Header.Get("Cookie"), "sessionid=")
+		mu.Lock()
+		used = append(used, sessionID)
+		mu.Unlock()
+
+		for _, id := range invalid {
+			if id == sessionID {
+				json.NewEncoder(w).Encode(map[string]interface{}{"message": tikTokInvalidSessionMessage})
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"message": "success",
+			"data":    map[string]string{"v_str": audio},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &used
+}
+
+func TestTikTokSessionPoolRoundRobin(t *testing.T) {
+	pool := newTestSessionPool(t, "aaaa", "bbbb", "cccc")
+	if _, err := pool.Add(" "); err == nil {
+		t.Error("empty session ID accepted")
+	}
+
+	var got []string
+	for i := 0; i < 4; i++ {
+		id, err := pool.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, id)
+	}
+	if strings.Join(got, ",") != "aaaa,bbbb,cccc,aaaa" {
+		t.Errorf("rotation = %v", got)
+	}
+
+	// A reloaded pool keeps the stored session IDs and their order
+	pool.Remove(tikTokSessionKey("aaaa"))
+	pool, err := NewTikTokSessionPool(pool.db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions := pool.List(); len(sessions) != 2 || sessions[0].SessionID != "bbbb" {
+		t.Errorf("sessions after reload = %+v", sessions)
+	}
+}
+
+func TestTikTokSessionPoolStartsEmpty(t *testing.T) {
+	pool := newTestSessionPool(t)
+	if sessions := pool.List(); len(sessions) != 0 {
+		t.Errorf("new pool holds %+v", sessions)
+	}
+	if _, err := pool.Next(); err != ErrNoTikTokSessionConfigured {
+		t.Errorf("Next = %v, want %v", err, ErrNoTikTokSessionConfigured)
+	}
+
+	p, err := NewTikTokProvider(ProviderConfig{Name: ProviderTikTok}, &ProviderEnv{TikTokSessions: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Synthesize(context.Background(), NewSynthesisRequest("hello", "en_us_002"))
+	if err != ErrNoTikTokSessionConfigured || IsTransient(err) {
+		t.Errorf("err = %v, want %v", err, ErrNoTikTokSessionConfigured)
+	}
+}
+
+func TestTikTokProviderInvalidatesAndRotates(t *testing.T) {
+	server, used := fakeTikTok(t, "bad-session")
+
+	pool := newTestSessionPool(t, "bad-session", "good-session")
+
+	p, err := NewTikTokProvider(ProviderConfig{Name: ProviderTikTok, Host: server.URL}, &ProviderEnv{TikTokSessions: pool})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := p.Synthesize(context.Background(), NewSynthesisRequest("hello", "en_us_002")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if strings.Join(*used, ",") != "bad-session,good-session,good-session" {
+		t.Errorf("sessions used = %v", *used)
+	}
+
+	bad, _ := pool.Get(tikTokSessionKey("bad-session"))
+	if bad.Valid || bad.LastError == "" {
+		t.Errorf("bad session not invalidated: %+v", bad)
+	}
+
+	// With every session invalid the provider fails without retrying
+	pool.SetValid("good-session", errTikTokSessionInvalid)
+	_, err = p.Synthesize(context.Background(), NewSynthesisRequest("hello", "en_us_002"))
+	if err != ErrNoTikTokSession || IsTransient(err) {
+		t.Errorf("err = %v, want %v", err, ErrNoTikTokSession)
+	}
+}
+
+func TestValidateTikTokSession(t *testing.T) {
+	server, _ := fakeTikTok(t, "bad-session")
+
+	pool := newTestSessionPool(t, "bad-session", "revived-session")
+	pool.SetValid("revived-session", errTikTokSessionInvalid)
+
+	config := ProviderConfig{Host: server.URL}
+	session, err := ValidateTikTokSession(context.Background(), pool, config, tikTokSessionKey("bad-session"))
+	if err != nil || session.Valid {
+		t.Errorf("bad session: valid=%v err=%v", session.Valid, err)
+	}
+	session, err = ValidateTikTokSession(context.Background(), pool, config, tikTokSessionKey("revived-session"))
+	if err != nil || !session.Valid {
+		t.Errorf("revived session: valid=%v err=%v", session.Valid, err)
+	}
+}
+
+func TestValidateTikTokSessions(t *testing.T) {
+	server, _ := fakeTikTok(t, "bad-session")
+	pool := newTestSessionPool(t, "bad-session", "revived-session")
+	pool.SetValid("revived-session", errTikTokSessionInvalid)
+
+	checks := ValidateTikTokSessions(context.Background(), pool, ProviderConfig{Host: server.URL})
+	if len(checks) != 2 {
+		t.Fatalf("got %d checks", len(checks))
+	}
+	if checks[0].SessionID != "bad-session" || checks[0].Valid || checks[0].Error != "" {
+		t.Errorf("bad session: %+v", checks[0])
+	}
+	if checks[1].SessionID != "revived-session" || !checks[1].Valid || checks[1].Error != "" {
+		t.Errorf("revived session: %+v", checks[1])
+	}
+
+	// Unreachable, the sessions keep their state and report the error
+	server.Close()
+	checks = ValidateTikTokSessions(context.Background(), pool, ProviderConfig{Host: server.URL})
+	for _, check := range checks {
+		if check.Error == "" || check.Valid != (check.SessionID == "revived-session") {
+			t.Errorf("unreachable check: %+v", check)
+		}
+	}
+}
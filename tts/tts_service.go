@@ -2,66 +2,406 @@ package tts
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"time"
+
+	"github.com/oristarium/orionchat/types"
 )
 
 // TTSService handles text-to-speech conversion
 type TTSService struct {
-	providers map[string]Provider
+	providers *ProviderRegistry
 	sanitizer *TextSanitizer
+	cache     *AudioCache
+	retry     retryPolicy
 }
 
 // NewTTSService creates a new TTS service instance
-func NewTTSService() *TTSService {
-	return &TTSService{
-		providers: make(map[string]Provider),
+func NewTTSService(providers *ProviderRegistry, cache *AudioCache) *TTSService {
+	s := &TTSService{
+		providers: providers,
 		sanitizer: NewTextSanitizer(),
+		cache:     cache,
+		retry:     defaultRetryPolicy,
+	}
+	if err := s.loadEmojiSettings(); err != nil {
+		log.Printf("Error loading emoji settings: %v", err)
+	}
+	return s
+}
+
+// Providers returns the provider registry used by the service
+func (s *TTSService) Providers() *ProviderRegistry {
+	return s.providers
+}
+
+// Cache returns the audio cache used by the service
+func (s *TTSService) Cache() *AudioCache {
+	return s.cache
+}
+
+// SynthesizeChunk converts a single chunk of text to speech, using the audio
+// cache when possible. The text is sent as is, so it must already be
+// sanitized for the voice and within MaxTextLength, see voiceChunks.
+func (s *TTSService) SynthesizeChunk(ctx context.Context, request SynthesisRequest, provider Provider, providerName string) (*SynthesisResponse, error) {
+	voiceID := request.VoiceID
+
+	if !provider.ValidateVoiceID(voiceID) {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidVoice, voiceID)
+	}
+
+	var fingerprint string
+	if s.providers != nil {
+		if config, ok := s.providers.Config(providerName); ok {
+			fingerprint = config.Fingerprint()
+		}
+	}
+	cacheKey := CacheKey(providerName, fingerprint, voiceID, request.Text, request.Options())
+	if s.cache != nil {
+		if audio, mimeType, ok := s.cache.Get(cacheKey); ok {
+			log.Printf("TTS cache hit - Provider: %s, Voice: %s", providerName, voiceID)
+			duration, _ := AudioDuration(mimeType, audio)
+			return &SynthesisResponse{Audio: audio, MIMEType: mimeType, Duration: duration}, nil
+		}
+	}
+
+	response, err := provider.Synthesize(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cache != nil {
+		if err := s.cache.Put(cacheKey, response.Audio, response.MIMEType); err != nil {
+			log.Printf("TTS cache: Failed to store audio - %v", err)
+		}
+	}
+
+	return response, nil
+}
+
+// SynthesisResult holds stitched audio and the voice that actually produced it
+type SynthesisResult struct {
+	Audio    []byte
+	MIMEType string
+	Duration time.Duration
+	Voice    types.TTSVoice
+}
+
+// BlockedWordError reports text rejected by a blocklist
+type BlockedWordError struct {
+	Word      string
+	Sanitized bool // found only after sanitization
+}
+
+func (e *BlockedWordError) Error() string {
+	if e.Sanitized {
+		return fmt.Sprintf("sanitized text contains blocked word: %s", e.Word)
+	}
+	return fmt.Sprintf("text contains blocked word: %s", e.Word)
+}
+
+// AudioFormatError reports provider output that could not be parsed or
+// stitched
+type AudioFormatError struct {
+	Provider string
+	Err      error
+}
+
+func (e *AudioFormatError) Error() string {
+	return fmt.Sprintf("%s returned unreadable audio: %v", e.Provider, e.Err)
+}
+
+func (e *AudioFormatError) Unwrap() error {
+	return e.Err
+}
+
+// VoiceChain returns a voice followed by its fallbacks, in the order they
+// should be tried
+func VoiceChain(voice types.TTSVoice) []types.TTSVoice {
+	primary := voice
+	primary.Fallbacks = nil
+	return append([]types.TTSVoice{primary}, voice.Fallbacks...)
+}
+
+// ValidateVoiceChain checks that a voice's fallbacks are flat and that the
+// speed, pitch and gain of every voice in the chain are in range. Fallbacks
+// of fallbacks are rejected rather than silently ignored.
+func ValidateVoiceChain(voice types.TTSVoice) error {
+	if err := TuningOf(voice).Validate(); err != nil {
+		return fmt.Errorf("voice %s (%s): %v", voice.VoiceID, voice.Provider, err)
+	}
+	for _, fallback := range voice.Fallbacks {
+		if len(fallback.Fallbacks) > 0 {
+			return fmt.Errorf("fallback voice %s (%s) of %s cannot have its own fallbacks",
+				fallback.VoiceID, fallback.Provider, voice.VoiceID)
+		}
+		if err := TuningOf(fallback).Validate(); err != nil {
+			return fmt.Errorf("fallback voice %s (%s): %v", fallback.VoiceID, fallback.Provider, err)
+		}
+	}
+	return nil
+}
+
+// SynthesizeText splits text as needed and synthesizes it through the
+// fallback chain
+func (s *TTSService) SynthesizeText(ctx context.Context, text string, voices []types.TTSVoice) (SynthesisResult, error) {
+	chunks, err := s.SplitLongText(text, "")
+	if err != nil {
+		return SynthesisResult{}, fmt.Errorf("text splitting error: %w", err)
+	}
+
+	// If text is short enough to not need splitting, put it in a single chunk
+	if len(chunks) == 0 {
+		chunks = []string{text}
+	}
+
+	return s.SynthesizeWithFallback(ctx, chunks, voices)
+}
+
+// ErrInvalidVoice is returned when a provider does not accept a voice ID
+var ErrInvalidVoice = errors.New("invalid voice ID")
+
+// Preview synthesizes a single voice without fallbacks. Without text the
+// voice's sample sentence from the catalog is spoken, or PreviewDefaultText
+// when it has none. The text spoken is returned along with the audio.
+// Previews go through the audio cache like any other clip, so replaying one
+// costs no provider request.
+func (s *TTSService) Preview(ctx context.Context, voice types.TTSVoice, text string) (SynthesisResult, string, error) {
+	provider, err := s.providers.Get(voice.Provider)
+	if err != nil {
+		return SynthesisResult{}, "", err
+	}
+	if !provider.ValidateVoiceID(voice.VoiceID) {
+		return SynthesisResult{}, "", fmt.Errorf("%w: %s (%s)", ErrInvalidVoice, voice.VoiceID, voice.Provider)
+	}
+
+	text = strings.TrimSpace(text)
+	if text == "" {
+		text = PreviewDefaultText
+		if catalog := s.providers.Catalog(); catalog != nil {
+			if entry, ok := catalog.Get(voice.Provider, voice.VoiceID); ok && entry.SampleSentence != "" {
+				text = entry.SampleSentence
+			}
+		}
+	}
+
+	voice.Fallbacks = nil
+	result, err := s.SynthesizeText(ctx, text, []types.TTSVoice{voice})
+	return result, text, err
+}
+
+// SynthesizeWithFallback converts text chunks to a single clip, trying each
+// voice in order until one succeeds. Transient failures are retried per
+// chunk with exponential backoff before moving on to the next voice, and the
+// whole chain gives up once FallbackChainTimeout has passed or ctx is
+// cancelled.
+//
+// The blocklist of the primary voice is checked once up front. A blocked
+// word stops the chain, since a fallback voice in another language would
+// only be checked against a different list.
+func (s *TTSService) SynthesizeWithFallback(ctx context.Context, chunks []string, voices []types.TTSVoice) (SynthesisResult, error) {
+	if len(voices) == 0 {
+		return SynthesisResult{}, fmt.Errorf("no voices to synthesize with")
+	}
+
+	for _, chunk := range chunks {
+		if err := s.checkBlockedWords(chunk, voices[0].VoiceID); err != nil {
+			return SynthesisResult{}, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.retry.chainTimeout)
+	defer cancel()
+
+	var lastErr error
+	for i, voice := range voices {
+		if ctx.Err() != nil {
+			log.Printf("TTS fallback: Chain stopped (%v), skipping %d remaining voice(s)",
+				ctx.Err(), len(voices)-i)
+			if lastErr == nil {
+				lastErr = ctx.Err()
+			}
+			break
+		}
+
+		result, err := s.synthesize(ctx, chunks, voice)
+		if err == nil {
+			if i > 0 {
+				log.Printf("TTS fallback: Voice %s (%s) produced the clip after %d failed voice(s)",
+					voice.VoiceID, voice.Provider, i)
+			}
+			return result, nil
+		}
+
+		log.Printf("TTS fallback: Voice %s (%s) failed - %v", voice.VoiceID, voice.Provider, err)
+		lastErr = err
+
+		var blockedErr *BlockedWordError
+		if errors.As(err, &blockedErr) || errors.Is(err, context.Canceled) {
+			return SynthesisResult{}, err
+		}
 	}
+
+	return SynthesisResult{}, fmt.Errorf("all %d voice(s) failed, last error: %w", len(voices), lastErr)
 }
 
-// GetAudioBase64WithProvider converts text to speech and returns base64 encoded audio
-func (s *TTSService) GetAudioBase64WithProvider(text, voiceID string, provider Provider, slow bool) (string, error) {
-	// Check for blocked words before processing
+// checkBlockedWords checks text against a voice's blocklist, both as given
+// and after sanitization
+func (s *TTSService) checkBlockedWords(text, voiceID string) error {
 	if blocked, word := s.sanitizer.ContainsBlockedWords(text, voiceID); blocked {
-		return "", fmt.Errorf("text contains blocked word: %s", word)
+		return &BlockedWordError{Word: word}
 	}
+	if blocked, word := s.sanitizer.ContainsBlockedWords(s.sanitizer.Sanitize(text, voiceID), voiceID); blocked {
+		return &BlockedWordError{Word: word, Sanitized: true}
+	}
+	return nil
+}
+
+// synthesizeChunkWithRetry converts a single chunk, retrying transient
+// failures until RetryMaxAttempts or the context deadline is reached
+func (s *TTSService) synthesizeChunkWithRetry(ctx context.Context, request SynthesisRequest, provider Provider, providerName string) (*SynthesisResponse, error) {
+	backoff := s.retry.initialBackoff
+	for attempt := 1; ; attempt++ {
+		response, err := s.SynthesizeChunk(ctx, request, provider, providerName)
+		if err == nil || !IsTransient(err) || attempt >= s.retry.maxAttempts {
+			return response, err
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("giving up after attempt %d, chain deadline reached: %w", attempt, err)
+		}
+
+		log.Printf("TTS retry: Attempt %d/%d with %s (%s) failed, retrying in %v - %v",
+			attempt, s.retry.maxAttempts, request.VoiceID, providerName, backoff, err)
 
-	options := map[string]interface{}{
-		"slow": slow,
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > s.retry.maxBackoff {
+			backoff = s.retry.maxBackoff
+		}
 	}
-	
-	if !provider.ValidateVoiceID(voiceID) {
-		return "", fmt.Errorf("invalid voice ID: %s", voiceID)
+}
+
+// synthesize converts every chunk with a single voice and stitches the
+// result. The voice's speed, pitch and gain are passed to the provider where
+// it supports them and applied to the finished clip otherwise.
+func (s *TTSService) synthesize(ctx context.Context, chunks []string, voice types.TTSVoice) (SynthesisResult, error) {
+	provider, err := s.providers.Get(voice.Provider)
+	if err != nil {
+		return SynthesisResult{}, err
 	}
+	native, rest := splitTuning(provider, TuningOf(voice))
 
-	// Sanitize the text before sending to provider
-	sanitizedText := s.sanitizer.Sanitize(text, voiceID)
-	
-	// Check for blocked words after sanitization
-	if blocked, word := s.sanitizer.ContainsBlockedWords(sanitizedText, voiceID); blocked {
-		return "", fmt.Errorf("sanitized text contains blocked word: %s", word)
+	chunks, err = s.voiceChunks(chunks, voice.VoiceID)
+	if err != nil {
+		return SynthesisResult{}, err
 	}
-	
-	return provider.GetAudioBase64(sanitizedText, voiceID, options)
+
+	responses := make([]*SynthesisResponse, 0, len(chunks))
+	for _, chunk := range chunks {
+		request := NewSynthesisRequest(chunk, voice.VoiceID)
+		request.Speed, request.Pitch, request.Volume = native.Speed, native.Pitch, native.Gain
+		response, err := s.synthesizeChunkWithRetry(ctx, request, provider, voice.Provider)
+		if err != nil {
+			return SynthesisResult{}, err
+		}
+		responses = append(responses, response)
+	}
+
+	voice.Fallbacks = nil
+	result := SynthesisResult{
+		MIMEType: responses[0].MIMEType,
+		Voice:    voice,
+	}
+
+	// A single chunk needs no stitching, so pass it through untouched
+	if len(responses) == 1 {
+		result.Audio = responses[0].Audio
+		result.Duration = responses[0].Duration
+		if result.Duration == 0 {
+			log.Printf("TTS: Could not determine duration of %s audio from %s", result.MIMEType, voice.Provider)
+		}
+		s.tune(ctx, &result, rest)
+		return result, nil
+	}
+
+	audioChunks := make([][]byte, 0, len(responses))
+	for i, response := range responses {
+		if response.MIMEType != result.MIMEType {
+			return SynthesisResult{}, &AudioFormatError{Provider: voice.Provider,
+				Err: fmt.Errorf("chunk %d is %s, chunk 0 is %s", i, response.MIMEType, result.MIMEType)}
+		}
+		audioChunks = append(audioChunks, response.Audio)
+	}
+
+	// Stitch the chunks into a single stream
+	audio, duration, err := StitchAudio(result.MIMEType, audioChunks)
+	if err != nil {
+		return SynthesisResult{}, &AudioFormatError{Provider: voice.Provider, Err: err}
+	}
+	result.Audio = audio
+	result.Duration = duration
+	s.tune(ctx, &result, rest)
+
+	return result, nil
 }
 
-// SplitLongText splits text into chunks that are less than maxTextLength
-func (s *TTSService) SplitLongText(text string, splitPunct string) ([]string, error) {
-	// Check for blocked words before processing
-	if blocked, word := s.sanitizer.ContainsBlockedWords(text, ""); blocked {
-		return nil, fmt.Errorf("text contains blocked word: %s", word)
+// voiceChunks sanitizes chunks for voiceID and splits them again, since
+// spelled out numbers and emoji names can take a chunk past MaxTextLength
+func (s *TTSService) voiceChunks(chunks []string, voiceID string) ([]string, error) {
+	var sanitized []string
+	for _, chunk := range chunks {
+		if blocked, word := s.sanitizer.ContainsBlockedWords(chunk, voiceID); blocked {
+			return nil, &BlockedWordError{Word: word}
+		}
+		text := s.sanitizer.Sanitize(chunk, voiceID)
+		if blocked, word := s.sanitizer.ContainsBlockedWords(text, voiceID); blocked {
+			return nil, &BlockedWordError{Word: word, Sanitized: true}
+		}
+		parts, err := s.SplitLongText(text, "")
+		if err != nil {
+			return nil, err
+		}
+		sanitized = append(sanitized, parts...)
+	}
+	if len(sanitized) == 0 {
+		return nil, fmt.Errorf("nothing left to speak after sanitizing")
 	}
+	return sanitized, nil
+}
 
-	// Sanitize the text before splitting
-	text = s.sanitizer.Sanitize(text, "")
-	
-	// Check for blocked words after sanitization
-	if blocked, word := s.sanitizer.ContainsBlockedWords(text, ""); blocked {
-		return nil, fmt.Errorf("sanitized text contains blocked word: %s", word)
+// tune applies the settings the provider could not apply itself. A clip
+// that cannot be tuned is still played, with as many of its settings as
+// possible.
+func (s *TTSService) tune(ctx context.Context, result *SynthesisResult, t Tuning) {
+	if t.IsNeutral() {
+		return
 	}
-	
+
+	audio, mimeType, err := ApplyTuning(ctx, result.MIMEType, result.Audio, t)
+	if err != nil {
+		log.Printf("TTS tuning: Voice %s (%s) - %v", result.Voice.VoiceID, result.Voice.Provider, err)
+	}
+	result.Audio = audio
+	result.MIMEType = mimeType
+	if duration, err := AudioDuration(mimeType, audio); err == nil {
+		result.Duration = duration
+	}
+}
+
+// SplitLongText splits text into chunks that are less than maxTextLength.
+// Text is sanitized per voice once split, and split again where that made
+// a chunk longer. Blocked words are checked against the voice's lists by
+// SynthesizeWithFallback.
+func (s *TTSService) SplitLongText(text string, splitPunct string) ([]string, error) {
 	log.Printf("Splitting text (length: %d): %q", len(text), text)
 	var chunks []string
 	words := strings.Fields(text)
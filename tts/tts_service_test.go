@@ -0,0 +1,354 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/oristarium/orionchat/types"
+)
+
+func TestPreviewUsesSampleSentenceAndCache(t *testing.T) {
+	var texts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		texts = append(texts, r.URL.Query().Get("text"))
+		w.Write(testWAV(1600))
+	}))
+	defer server.Close()
+
+	catalog := newTestCatalog(t)
+	registry, err := NewProviderRegistry(catalog.db, catalog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = registry.Save(ProviderConfig{
+		Name:    "piper",
+		Type:    ProviderHTTP,
+		Enabled: true,
+		HTTP:    &HTTPProviderConfig{URL: server.URL + "/?text={{.Text | urlquery}}", Voices: []string{"id_ID-news"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := catalog.SaveCustom(CatalogVoice{Provider: "piper", VoiceID: "id_ID-news", SampleSentence: "Selamat datang"}); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewAudioCache(t.TempDir(), DefaultCacheMaxBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service := NewTTSService(registry, cache)
+	voice := types.TTSVoice{VoiceID: "id_ID-news", Provider: "piper"}
+
+	for i := 0; i < 2; i++ {
+		result, text, err := service.Preview(context.Background(), voice, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if text != "Selamat datang" || result.MIMEType != MIMETypeWAV {
+			t.Errorf("got %q as %s", text, result.MIMEType)
+		}
+	}
+	if len(texts) != 1 || texts[0] != "Selamat datang" {
+		t.Errorf("engine received %q, want a single request for the sample sentence", texts)
+	}
+
+	if _, text, err := service.Preview(context.Background(), voice, "Halo semua"); err != nil || text != "Halo semua" {
+		t.Errorf("custom text: got %q, %v", text, err)
+	}
+
+	_, _, err = service.Preview(context.Background(), types.TTSVoice{VoiceID: "en_US-amy", Provider: "piper"}, "")
+	if !errors.Is(err, ErrInvalidVoice) {
+		t.Errorf("err = %v, want ErrInvalidVoice", err)
+	}
+}
+
+func TestSynthesizeAppliesVoiceTuning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testWAV(16000))
+	}))
+	defer server.Close()
+
+	db, _ := openTestDB(t)
+	defer db.Close()
+	registry, err := NewProviderRegistry(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = registry.Save(ProviderConfig{Name: "piper", Type: ProviderHTTP, Enabled: true,
+		HTTP: &HTTPProviderConfig{URL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	service := NewTTSService(registry, nil)
+	voice := types.TTSVoice{VoiceID: "any", Provider: "piper", Speed: 2}
+	result, err := service.SynthesizeText(context.Background(), "halo", VoiceChain(voice))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Duration < 490*time.Millisecond || result.Duration > 510*time.Millisecond {
+		t.Errorf("duration = %v, want about 500ms", result.Duration)
+	}
+	if result.Voice.Speed != 2 {
+		t.Errorf("result voice lost its settings: %+v", result.Voice)
+	}
+}
+
+// fakeProvider fails with errs in order, then returns a short WAV clip.
+// With hang set it instead waits for the request to be cancelled.
+type fakeProvider struct {
+	mu    sync.Mutex
+	errs  []error
+	hang  bool
+	calls int
+	texts []string // text of every request
+}
+
+func (p *fakeProvider) Synthesize(ctx context.Context, req SynthesisRequest) (*SynthesisResponse, error) {
+	p.mu.Lock()
+	call := p.calls
+	p.calls++
+	p.texts = append(p.texts, req.Text)
+	p.mu.Unlock()
+	if p.hang {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if call < len(p.errs) {
+		return nil, p.errs[call]
+	}
+	return &SynthesisResponse{Audio: testWAV(160), MIMEType: MIMETypeWAV}, nil
+}
+
+func (p *fakeProvider) GetVoiceIDs() []string               { return nil }
+func (p *fakeProvider) ValidateVoiceID(voiceID string) bool { return true }
+
+func (p *fakeProvider) Calls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+// newFakeService registers providers under their names and returns a
+// service retrying without delay
+func newFakeService(t *testing.T, providers map[string]*fakeProvider) *TTSService {
+	t.Helper()
+	ProviderFactory["fake"] = func(config ProviderConfig, env *ProviderEnv) (Provider, error) {
+		return providers[config.Name], nil
+	}
+	t.Cleanup(func() { delete(ProviderFactory, "fake") })
+
+	db, _ := openTestDB(t)
+	t.Cleanup(func() { db.Close() })
+	registry, err := NewProviderRegistry(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name := range providers {
+		if err := registry.Save(ProviderConfig{Name: name, Type: "fake", Enabled: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := NewTTSService(registry, nil)
+	service.retry = retryPolicy{maxAttempts: 3, initialBackoff: time.Millisecond, maxBackoff: time.Millisecond, chainTimeout: 5 * time.Second}
+	return service
+}
+
+func fakeChain(providers ...string) []types.TTSVoice {
+	var voices []types.TTSVoice
+	for _, provider := range providers {
+		voices = append(voices, types.TTSVoice{VoiceID: "en_fake", Provider: provider})
+	}
+	return voices
+}
+
+func TestFallbackRetriesTransientErrors(t *testing.T) {
+	flaky := &fakeProvider{errs: []error{transientf("503"), transientf("503")}}
+	backup := &fakeProvider{}
+	service := newFakeService(t, map[string]*fakeProvider{"flaky": flaky, "backup": backup})
+
+	result, err := service.SynthesizeWithFallback(context.Background(), []string{"halo"}, fakeChain("flaky", "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flaky.Calls() != 3 || backup.Calls() != 0 || result.Voice.Provider != "flaky" {
+		t.Errorf("flaky called %d times, backup %d, clip from %s", flaky.Calls(), backup.Calls(), result.Voice.Provider)
+	}
+
+	// A third transient failure uses up the attempts and moves on
+	flaky.calls, flaky.errs = 0, []error{transientf("503"), transientf("503"), transientf("503")}
+	result, err = service.SynthesizeWithFallback(context.Background(), []string{"halo"}, fakeChain("flaky", "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flaky.Calls() != 3 || backup.Calls() != 1 || result.Voice.Provider != "backup" {
+		t.Errorf("flaky called %d times, backup %d, clip from %s", flaky.Calls(), backup.Calls(), result.Voice.Provider)
+	}
+}
+
+func TestFallbackDoesNotRetryPermanentErrors(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		http.Error(w, "unknown voice", http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	backup := &fakeProvider{}
+	service := newFakeService(t, map[string]*fakeProvider{"backup": backup})
+	err := service.providers.Save(ProviderConfig{Name: "piper", Type: ProviderHTTP, Enabled: true,
+		HTTP: &HTTPProviderConfig{URL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := service.SynthesizeWithFallback(context.Background(), []string{"halo"}, fakeChain("piper", "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 || result.Voice.Provider != "backup" {
+		t.Errorf("4xx requested %d times, clip from %s", requests, result.Voice.Provider)
+	}
+}
+
+func TestFallbackStopsOnBlockedWords(t *testing.T) {
+	primary, backup := &fakeProvider{}, &fakeProvider{}
+	service := newFakeService(t, map[string]*fakeProvider{"primary": primary, "backup": backup})
+	service.sanitizer.shippedOnce.Do(func() {})
+	service.sanitizer.shipped = newRuleSet(ruleLists{RuleBlocked: {
+		LangEnglish: {"dweeb": {Match: "dweeb", Mode: BlockWholeWord}},
+	}})
+
+	_, err := service.SynthesizeWithFallback(context.Background(), []string{"hi", "what a dweeb"}, fakeChain("primary", "backup"))
+	var blocked *BlockedWordError
+	if !errors.As(err, &blocked) || blocked.Word != "dweeb" {
+		t.Fatalf("err = %v, want a BlockedWordError", err)
+	}
+	if primary.Calls() != 0 || backup.Calls() != 0 {
+		t.Errorf("blocked text reached providers: %d, %d call(s)", primary.Calls(), backup.Calls())
+	}
+}
+
+func TestFallbackGivesUpAtChainTimeout(t *testing.T) {
+	slow, backup := &fakeProvider{hang: true}, &fakeProvider{}
+	service := newFakeService(t, map[string]*fakeProvider{"slow": slow, "backup": backup})
+	service.retry.chainTimeout = 100 * time.Millisecond
+
+	start := time.Now()
+	_, err := service.SynthesizeWithFallback(context.Background(), []string{"halo"}, fakeChain("slow", "backup"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want the chain deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("gave up after %v", elapsed)
+	}
+	if backup.Calls() != 0 {
+		t.Error("fallback tried after the chain deadline")
+	}
+
+	// Retries that would outlast the deadline are not waited for
+	flaky := &fakeProvider{errs: []error{transientf("503"), transientf("503"), transientf("503")}}
+	service = newFakeService(t, map[string]*fakeProvider{"flaky": flaky})
+	service.retry.initialBackoff, service.retry.maxBackoff = time.Minute, time.Minute
+	start = time.Now()
+	if _, err := service.SynthesizeWithFallback(context.Background(), []string{"halo"}, fakeChain("flaky")); err == nil {
+		t.Fatal("flaky voice succeeded")
+	}
+	if flaky.Calls() != 1 || time.Since(start) > time.Second {
+		t.Errorf("flaky called %d times in %v", flaky.Calls(), time.Since(start))
+	}
+}
+
+func TestChunksStayShortAfterSpellingOutNumbers(t *testing.T) {
+	provider := &fakeProvider{}
+	service := newFakeService(t, map[string]*fakeProvider{"fake": provider})
+
+	// Just under the limit as typed, far over it once the numbers are read
+	// out
+	text := strings.Repeat("7 ", 90) + "and 1500"
+	if len(text) > MaxTextLength {
+		t.Fatalf("test text is %d characters", len(text))
+	}
+	if _, err := service.SynthesizeText(context.Background(), text, fakeChain("fake")); err != nil {
+		t.Fatal(err)
+	}
+
+	spoken := strings.Join(provider.texts, " ")
+	if strings.ContainsAny(spoken, "0123456789") || !strings.HasSuffix(spoken, "one thousand five hundred") {
+		t.Errorf("text not expanded: %q", spoken)
+	}
+	for _, chunk := range provider.texts {
+		if len(chunk) > MaxTextLength {
+			t.Errorf("chunk of %d characters sent: %q", len(chunk), chunk)
+		}
+	}
+}
+
+func TestChunksStayShortAfterReadingEmoji(t *testing.T) {
+	provider := &fakeProvider{}
+	service := newFakeService(t, map[string]*fakeProvider{"fake": provider})
+	service.sanitizer = newTestSanitizer(EmojiSettings{Mode: EmojiDescribe})
+
+	// Just under the limit as typed, over it once the emoji are read out
+	text := strings.Repeat("ok ", 60) + "🤦 🙆 🤷"
+	if len(text) > MaxTextLength {
+		t.Fatalf("test text is %d characters", len(text))
+	}
+	if _, err := service.SynthesizeText(context.Background(), text, fakeChain("fake")); err != nil {
+		t.Fatal(err)
+	}
+
+	spoken := strings.Join(provider.texts, " ")
+	if strings.ContainsAny(spoken, "🤦🙆🤷") || !strings.Contains(spoken, "facepalming") {
+		t.Errorf("emoji not read: %q", spoken)
+	}
+	for _, chunk := range provider.texts {
+		if len(chunk) > MaxTextLength {
+			t.Errorf("chunk of %d characters sent: %q", len(chunk), chunk)
+		}
+	}
+}
+
+func TestBlockedWordForEveryVoiceBlocksJob(t *testing.T) {
+	provider := &fakeProvider{}
+	service := newFakeService(t, map[string]*fakeProvider{"fake": provider})
+	service.sanitizer.shippedOnce.Do(func() {})
+	service.sanitizer.shipped = newRuleSet(ruleLists{RuleBlocked: {
+		RulesAllLanguages: {"dweeb": {Match: "dweeb", Mode: BlockWholeWord}},
+	}})
+
+	db, _ := openTestDB(t)
+	defer db.Close()
+	tm, err := NewTTSMiddleware(service, db, t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm.clients[&websocket.Conn{}] = "avatar"
+	tm.avatarVoices = func(string) ([]types.TTSVoice, error) {
+		return fakeChain("fake"), nil
+	}
+
+	jobID, _ := tm.InterceptTTS("tts", map[string]interface{}{
+		"content": map[string]interface{}{"sanitized": "what a dweeb"},
+	}, nil, "")
+	deadline := time.Now().Add(2 * time.Second)
+	job, _ := tm.Job(jobID)
+	for (job.State == JobPending || job.State == JobQueued) && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+		job, _ = tm.Job(jobID)
+	}
+	if job.State != JobBlocked {
+		t.Errorf("job = %+v, want it blocked", job)
+	}
+	if provider.Calls() != 0 {
+		t.Errorf("blocked text reached the provider %d time(s)", provider.Calls())
+	}
+}
@@ -0,0 +1,122 @@
+package tts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/oristarium/orionchat/types"
+)
+
+// ErrTuningUnsupported is returned when speed or pitch of MP3 audio has to
+// be changed but ffmpeg is not installed
+var ErrTuningUnsupported = errors.New("changing speed or pitch of MP3 audio needs ffmpeg")
+
+// tuningEpsilon is how close to neutral a setting must be to be skipped
+const tuningEpsilon = 0.01
+
+// Tuning is how the audio of a voice is adjusted
+type Tuning struct {
+	Speed float64 // playback rate, 1 is normal
+	Pitch float64 // semitones, 0 is normal
+	Gain  float64 // dB, 0 is normal
+}
+
+// neutralTuning leaves audio as the provider made it
+var neutralTuning = Tuning{Speed: 1}
+
+// TuningOf returns the settings of a voice, treating an unset speed as normal
+func TuningOf(voice types.TTSVoice) Tuning {
+	t := Tuning{Speed: voice.Speed, Pitch: voice.Pitch, Gain: voice.Gain}
+	if t.Speed == 0 {
+		t.Speed = 1
+	}
+	return t
+}
+
+// Validate checks that every setting is within the accepted range
+func (t Tuning) Validate() error {
+	if t.Speed < MinVoiceSpeed || t.Speed > MaxVoiceSpeed {
+		return fmt.Errorf("speed %.2f is outside %.1f-%.1f", t.Speed, MinVoiceSpeed, MaxVoiceSpeed)
+	}
+	if math.Abs(t.Pitch) > MaxVoicePitch {
+		return fmt.Errorf("pitch %.1f is outside ±%d semitones", t.Pitch, MaxVoicePitch)
+	}
+	if math.Abs(t.Gain) > MaxVoiceGain {
+		return fmt.Errorf("gain %.1f is outside ±%d dB", t.Gain, MaxVoiceGain)
+	}
+	return nil
+}
+
+// IsNeutral reports whether t leaves audio unchanged
+func (t Tuning) IsNeutral() bool {
+	return !t.changesSpeed() && !t.changesPitch() && math.Abs(t.Gain) < tuningEpsilon
+}
+
+func (t Tuning) changesSpeed() bool {
+	return math.Abs(t.Speed-1) >= tuningEpsilon
+}
+
+func (t Tuning) changesPitch() bool {
+	return math.Abs(t.Pitch) >= tuningEpsilon
+}
+
+// NativeTuner is implemented by providers that can apply some settings
+// themselves, such as Google's slow flag
+type NativeTuner interface {
+	// SplitTuning returns the part of t the provider applies and the part
+	// left to apply to its audio afterwards
+	SplitTuning(t Tuning) (native, rest Tuning)
+}
+
+// splitTuning divides t between the provider and post-processing
+func splitTuning(provider Provider, t Tuning) (native, rest Tuning) {
+	if tuner, ok := provider.(NativeTuner); ok {
+		return tuner.SplitTuning(t)
+	}
+	return neutralTuning, t
+}
+
+// ApplyTuning changes the speed, pitch and gain of a clip and returns the
+// result with its MIME type. WAV is processed directly. For MP3 the gain is
+// adjusted without re-encoding, while speed and pitch are handed to ffmpeg
+// when it is installed.
+//
+// On error the returned audio is still playable: either the input, or the
+// input with the settings that could be applied.
+func ApplyTuning(ctx context.Context, mimeType string, audio []byte, t Tuning) ([]byte, string, error) {
+	if t.IsNeutral() {
+		return audio, mimeType, nil
+	}
+
+	switch NormalizeMIMEType(mimeType) {
+	case MIMETypeWAV:
+		out, err := tuneWAV(audio, t)
+		if err != nil {
+			return audio, mimeType, err
+		}
+		return out, MIMETypeWAV, nil
+	case MIMETypeMP3:
+		if t.changesSpeed() || t.changesPitch() {
+			if path := ffmpegPath(); path != "" {
+				out, err := tuneWithFFmpeg(ctx, path, audio, t)
+				if err != nil {
+					return audio, mimeType, err
+				}
+				return out, MIMETypeMP3, nil
+			}
+		}
+
+		out, err := adjustMP3Gain(audio, t.Gain)
+		if err != nil {
+			return audio, mimeType, err
+		}
+		if t.changesSpeed() || t.changesPitch() {
+			return out, MIMETypeMP3, ErrTuningUnsupported
+		}
+		return out, MIMETypeMP3, nil
+	default:
+		return audio, mimeType, fmt.Errorf("cannot tune %s audio", mimeType)
+	}
+}
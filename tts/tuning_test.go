@@ -0,0 +1,190 @@
+package tts
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/oristarium/orionchat/types"
+)
+
+// sineWAV builds a 16 kHz mono 16-bit WAV of a sine wave
+func sineWAV(freq float64, d time.Duration) []byte {
+	const rate = 16000
+	samples := int(d.Seconds() * rate)
+	pcm := make([]byte, 2*samples)
+	for i := 0; i < samples; i++ {
+		v := 0.5 * math.Sin(2*math.Pi*freq*float64(i)/rate)
+		binary.LittleEndian.PutUint16(pcm[2*i:], uint16(int16(v*32767)))
+	}
+	return encodeWAV(testWAV(0)[20:36], pcm)
+}
+
+// zeroCrossingRate estimates the frequency of a mono 16-bit WAV sine
+func zeroCrossingRate(t *testing.T, audio []byte) float64 {
+	t.Helper()
+	w, err := parseWAV(audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := decodePCM16(w.data, 1)[0]
+	crossings := 0
+	for i := 1; i < len(samples); i++ {
+		if (samples[i-1] < 0) != (samples[i] < 0) {
+			crossings++
+		}
+	}
+	return float64(crossings) / 2 / (float64(len(samples)) / float64(w.sampleRate()))
+}
+
+func TestTuneWAV(t *testing.T) {
+	input := sineWAV(200, time.Second)
+
+	tests := []struct {
+		name     string
+		tuning   Tuning
+		duration time.Duration
+		freq     float64
+	}{
+		{"faster", Tuning{Speed: 2}, 500 * time.Millisecond, 200},
+		{"slower", Tuning{Speed: 0.5}, 2 * time.Second, 200},
+		{"higher", Tuning{Speed: 1, Pitch: 12}, time.Second, 400},
+		{"lower and faster", Tuning{Speed: 1.5, Pitch: -12}, 667 * time.Millisecond, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, mimeType, err := ApplyTuning(context.Background(), MIMETypeWAV, input, tt.tuning)
+			if err != nil || mimeType != MIMETypeWAV {
+				t.Fatalf("got %s, %v", mimeType, err)
+			}
+			duration, _ := WAVDuration(out)
+			if diff := duration - tt.duration; diff < -10*time.Millisecond || diff > 10*time.Millisecond {
+				t.Errorf("duration = %v, want %v", duration, tt.duration)
+			}
+			if freq := zeroCrossingRate(t, out); math.Abs(freq-tt.freq) > tt.freq*0.05 {
+				t.Errorf("frequency = %.0f Hz, want %.0f Hz", freq, tt.freq)
+			}
+		})
+	}
+}
+
+func TestTuneWAVGainClips(t *testing.T) {
+	out, _, err := ApplyTuning(context.Background(), MIMETypeWAV, sineWAV(200, 100*time.Millisecond), Tuning{Speed: 1, Gain: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w, _ := parseWAV(out)
+	peak := 0.0
+	for _, v := range decodePCM16(w.data, 1)[0] {
+		peak = math.Max(peak, math.Abs(v))
+	}
+	if peak < 0.99 || peak > 1 {
+		t.Errorf("peak = %.3f, want clipped at full scale", peak)
+	}
+}
+
+func TestAdjustMP3Gain(t *testing.T) {
+	stereo := mp3Frame(t, headerMPEG1)
+	mono := mp3Frame(t, headerMPEG2Mono)
+	for _, frame := range [][]byte{stereo, mono} {
+		h, _ := parseMP3FrameHeader(frame)
+		for _, bit := range globalGainOffsets(h) {
+			writeBits(frame, bit, 8, 250)
+		}
+	}
+	input := append(append([]byte(nil), stereo...), mono...)
+
+	out, err := adjustMP3Gain(input, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(out, input) {
+		t.Fatal("audio was not changed")
+	}
+
+	var gains []uint32
+	walkMP3Frames(out, func(h mp3FrameHeader, frame []byte) {
+		for _, bit := range globalGainOffsets(h) {
+			gains = append(gains, readBits(frame, bit, 8))
+		}
+	})
+	// 6 dB is 4 steps, clamped at 255
+	want := []uint32{254, 254, 254, 254, 254}
+	if len(gains) != len(want) {
+		t.Fatalf("found %d granules, want %d", len(gains), len(want))
+	}
+	for i := range want {
+		if gains[i] != want[i] {
+			t.Errorf("granule %d gain = %d, want %d", i, gains[i], want[i])
+		}
+	}
+
+	if out, _ := adjustMP3Gain(out, 3); readBits(out, globalGainOffsets(mustHeader(t, out))[0], 8) != 255 {
+		t.Errorf("gain was not clamped at 255")
+	}
+}
+
+func mustHeader(t *testing.T, frame []byte) mp3FrameHeader {
+	t.Helper()
+	h, ok := parseMP3FrameHeader(frame)
+	if !ok {
+		t.Fatal("invalid frame header")
+	}
+	return h
+}
+
+func TestFFmpegFilters(t *testing.T) {
+	tests := []struct {
+		tuning Tuning
+		want   string
+	}{
+		{Tuning{Speed: 1.25}, "atempo=1.2500"},
+		{Tuning{Speed: 1, Pitch: 12, Gain: -3}, "asetrate=48000,aresample=24000,atempo=0.5000,volume=-3.00dB"},
+		{Tuning{Speed: 0.5, Pitch: 12}, "asetrate=48000,aresample=24000,atempo=0.5,atempo=0.5000"},
+	}
+	for _, tt := range tests {
+		if got := ffmpegFilters(tt.tuning, 24000); got != tt.want {
+			t.Errorf("%+v: got %q, want %q", tt.tuning, got, tt.want)
+		}
+	}
+}
+
+func TestSplitTuning(t *testing.T) {
+	google := &GoogleTranslateProvider{}
+	native, rest := google.SplitTuning(Tuning{Speed: 0.6, Gain: 3})
+	if native.Speed != googleSlowRate || rest.changesSpeed() || rest.Gain != 3 {
+		t.Errorf("slow: native %+v, rest %+v", native, rest)
+	}
+	if native, _ := google.SplitTuning(Tuning{Speed: 0.9}); native.changesSpeed() {
+		t.Errorf("slow flag used for speed 0.9")
+	}
+
+	engine, err := newHTTPProvider(ProviderConfig{HTTP: &HTTPProviderConfig{
+		URL:  "http://localhost/",
+		Body: `{"speed":{{.Speed}}}`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	native, rest = engine.SplitTuning(Tuning{Speed: 1.5, Pitch: 2})
+	if native != (Tuning{Speed: 1.5}) || rest != (Tuning{Speed: 1, Pitch: 2}) {
+		t.Errorf("http: native %+v, rest %+v", native, rest)
+	}
+}
+
+func TestValidateVoiceChainRejectsBadTuning(t *testing.T) {
+	voice := types.TTSVoice{VoiceID: "id", Provider: ProviderGoogle, Speed: 1.2, Pitch: -3}
+	if err := ValidateVoiceChain(voice); err != nil {
+		t.Errorf("valid voice rejected: %v", err)
+	}
+	voice.Fallbacks = []types.TTSVoice{{VoiceID: "en", Provider: ProviderGoogle, Gain: 40}}
+	if err := ValidateVoiceChain(voice); err == nil {
+		t.Errorf("fallback gain of 40 dB accepted")
+	}
+	if err := ValidateVoiceChain(types.TTSVoice{Speed: 3}); err == nil {
+		t.Errorf("speed 3 accepted")
+	}
+}
@@ -0,0 +1,415 @@
+package tts
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// numeral matches a number as typed in chat: digits, optionally grouped or
+// with a decimal part, such as 150000, 150.000, 1,500.75 or 2,5
+const numeral = `\d+(?:[.,]\d+)*`
+
+var (
+	timePattern       = regexp.MustCompile(`(?i)\b(\d{1,2}):(\d{2})(?:\s?([ap])\.?m\b\.?|\b)`)
+	currencyPattern   = regexp.MustCompile(`(?i)(rp\.?|idr|us\$|\$|€|£|¥)\s?(` + numeral + `)(k|rb|jt)?\b`)
+	percentPattern    = regexp.MustCompile(`(` + numeral + `)\s?%`)
+	multiplierPattern = regexp.MustCompile(`(?i)\b(` + numeral + `)(k|rb|jt)\b`)
+	numberPattern     = regexp.MustCompile(numeral)
+	digitsPattern     = regexp.MustCompile(`\d+`)
+
+	// numberTokenPattern matches numbers with the symbols read as part of
+	// them, see Sanitize
+	numberTokenPattern = regexp.MustCompile(`[$€£¥]?` + numeral + `%?`)
+)
+
+// multipliers are the shorthands for thousands and millions typed after
+// amounts, as in 50k, 50rb (ribu) or 2jt (juta)
+var multipliers = map[string]uint64{"k": 1e3, "rb": 1e3, "jt": 1e6}
+
+// maxSpelledDigits is the longest whole number spelled as an amount; longer
+// ones, like phone numbers, are read digit by digit
+const maxSpelledDigits = 15
+
+// currencyName is how a currency is read after an amount
+type currencyName struct {
+	one, many  string
+	cents      string // name of a hundredth, "" for currencies without
+	separators string // thousands and decimal separators always used with it, if any
+}
+
+// numberSpeller spells numbers the way they are read aloud in a language
+type numberSpeller struct {
+	cardinal       func(n uint64) string
+	ordinal        func(n uint64) string
+	ordinalPattern *regexp.Regexp // first group is the number
+	thousandsSep   byte
+	decimalSep     byte
+	point          string // read between whole and decimal digits
+	percent        string
+	and            string // read between an amount and its cents
+	currencies     map[string]currencyName
+	time           func(hour, minute uint64, meridiem string) string
+}
+
+var spellers = map[string]*numberSpeller{
+	LangEnglish: {
+		cardinal:       spellEnglish,
+		ordinal:        ordinalEnglish,
+		ordinalPattern: regexp.MustCompile(`(?i)\b(\d+)(?:st|nd|rd|th)\b`),
+		thousandsSep:   ',',
+		decimalSep:     '.',
+		point:          "point",
+		percent:        "percent",
+		and:            "and",
+		currencies: map[string]currencyName{
+			"$":   {"dollar", "dollars", "cents", ""},
+			"us$": {"dollar", "dollars", "cents", ""},
+			"€":   {"euro", "euros", "cents", ""},
+			"£":   {"pound", "pounds", "pence", ""},
+			"¥":   {"yen", "yen", "", ""},
+			"rp":  {"rupiah", "rupiah", "", ".,"},
+			"idr": {"rupiah", "rupiah", "", ".,"},
+		},
+		time: func(hour, minute uint64, meridiem string) string {
+			words := spellEnglish(hour)
+			switch {
+			case minute == 0 && meridiem == "":
+				words += " o'clock"
+			case minute == 0:
+			case minute < 10:
+				words += " oh " + spellEnglish(minute)
+			default:
+				words += " " + spellEnglish(minute)
+			}
+			if meridiem != "" {
+				words += " " + strings.ToUpper(meridiem) + "M"
+			}
+			return words
+		},
+	},
+	LangIndonesian: {
+		cardinal:       spellIndonesian,
+		ordinal:        ordinalIndonesian,
+		ordinalPattern: regexp.MustCompile(`(?i)\bke-?(\d+)\b`),
+		thousandsSep:   '.',
+		decimalSep:     ',',
+		point:          "koma",
+		percent:        "persen",
+		currencies: map[string]currencyName{
+			"$":   {"dolar", "dolar", "sen", ""},
+			"us$": {"dolar", "dolar", "sen", ""},
+			"€":   {"euro", "euro", "sen", ""},
+			"£":   {"pound", "pound", "pence", ""},
+			"¥":   {"yen", "yen", "", ""},
+			"rp":  {"rupiah", "rupiah", "", ".,"},
+			"idr": {"rupiah", "rupiah", "", ".,"},
+		},
+		time: func(hour, minute uint64, meridiem string) string {
+			words := spellIndonesian(hour)
+			switch {
+			case minute == 0:
+			case minute < 10:
+				words += " nol " + spellIndonesian(minute)
+			default:
+				words += " " + spellIndonesian(minute)
+			}
+			if meridiem != "" {
+				words += " " + strings.ToUpper(meridiem) + "M"
+			}
+			return words
+		},
+	},
+}
+
+// Verbalize spells out the numbers in text, including decimals, thousands
+// separators, percentages, times, ordinals and currency amounts, the way
+// they are read aloud in lang. Languages other than English and Indonesian
+// are returned unchanged.
+func Verbalize(text, lang string) string {
+	sp, ok := spellers[lang]
+	if !ok {
+		return text
+	}
+
+	text = replaceNumbers(text, timePattern, func(m []string) (string, bool) {
+		hour, _ := strconv.ParseUint(m[1], 10, 64)
+		minute, _ := strconv.ParseUint(m[2], 10, 64)
+		if hour > 23 || minute > 59 || (m[3] != "" && (hour == 0 || hour > 12)) {
+			return "", false
+		}
+		return sp.time(hour, minute, strings.ToLower(m[3])), true
+	})
+	text = replaceNumbers(text, currencyPattern, func(m []string) (string, bool) {
+		symbol := strings.TrimSuffix(strings.ToLower(m[1]), ".")
+		return sp.amount(m[2], strings.ToLower(m[3]), sp.currencies[symbol])
+	})
+	text = replaceNumbers(text, percentPattern, func(m []string) (string, bool) {
+		words, ok := sp.number(m[1])
+		return words + " " + sp.percent, ok
+	})
+	text = replaceNumbers(text, sp.ordinalPattern, func(m []string) (string, bool) {
+		n, err := strconv.ParseUint(m[1], 10, 64)
+		if err != nil || len(m[1]) > maxSpelledDigits {
+			return "", false
+		}
+		return sp.ordinal(n), true
+	})
+	text = replaceNumbers(text, multiplierPattern, func(m []string) (string, bool) {
+		return sp.amount(m[1], strings.ToLower(m[2]), currencyName{})
+	})
+	return replaceNumbers(text, numberPattern, func(m []string) (string, bool) {
+		return sp.number(m[0])
+	})
+}
+
+// replaceNumbers replaces each match of re with what spell returns for its
+// groups, leaving matches it reports false for. Replacements next to a
+// letter or digit are set off with spaces.
+func replaceNumbers(text string, re *regexp.Regexp, spell func(m []string) (string, bool)) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(text, -1) {
+		groups := make([]string, len(loc)/2)
+		for i := range groups {
+			if loc[2*i] >= 0 {
+				groups[i] = text[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		words, ok := spell(groups)
+		if !ok {
+			continue
+		}
+		b.WriteString(text[last:loc[0]])
+		if r, _ := utf8.DecodeLastRuneInString(text[:loc[0]]); isWordRune(r) {
+			b.WriteByte(' ')
+		}
+		b.WriteString(words)
+		if r, _ := utf8.DecodeRuneInString(text[loc[1]:]); isWordRune(r) {
+			b.WriteByte(' ')
+		}
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// splitNumeral splits a typed number into its whole digits, without
+// separators, and decimal digits. It reports false when the separators do
+// not form a single number, as in 1,2,3 or 192.168.1.1.
+func (sp *numberSpeller) splitNumeral(s string) (whole, frac string, ok bool) {
+	whole = s
+	if i := strings.LastIndexAny(s, ".,"); i >= 0 {
+		// A decimal separator, or a lone separator not followed by a
+		// group of three, as in 2.5 typed in Indonesian
+		lone := strings.Count(s, ".")+strings.Count(s, ",") == 1
+		if s[i] == sp.decimalSep || (lone && len(s)-i-1 != 3) {
+			whole, frac = s[:i], s[i+1:]
+		}
+	}
+
+	groups := strings.Split(whole, string(sp.thousandsSep))
+	for i, group := range groups {
+		if group == "" || strings.Trim(group, "0123456789") != "" ||
+			(i == 0 && len(group) > 3 && len(groups) > 1) || (i > 0 && len(group) != 3) {
+			return "", "", false
+		}
+	}
+	return strings.Join(groups, ""), frac, true
+}
+
+// number spells a typed number. Numbers with leading zeros or too many
+// digits to say as an amount are read digit by digit.
+func (sp *numberSpeller) number(s string) (string, bool) {
+	whole, frac, ok := sp.splitNumeral(s)
+	if !ok {
+		// Several numbers, each read on its own
+		separators := strings.NewReplacer(",", ", ", ".", " "+sp.point+" ")
+		return separators.Replace(digitsPattern.ReplaceAllStringFunc(s, sp.whole)), true
+	}
+	words := sp.whole(whole)
+	if frac != "" {
+		words += " " + sp.point + " " + sp.digitByDigit(frac)
+	}
+	return words, true
+}
+
+// whole spells a run of digits as an amount where it can
+func (sp *numberSpeller) whole(digits string) string {
+	if len(digits) > maxSpelledDigits || (len(digits) > 1 && digits[0] == '0') {
+		return sp.digitByDigit(digits)
+	}
+	n, _ := strconv.ParseUint(digits, 10, 64)
+	return sp.cardinal(n)
+}
+
+// digitByDigit spells each digit on its own
+func (sp *numberSpeller) digitByDigit(digits string) string {
+	words := make([]string, 0, len(digits))
+	for _, d := range digits {
+		words = append(words, sp.cardinal(uint64(d-'0')))
+	}
+	return strings.Join(words, " ")
+}
+
+// amount spells an amount with an optional multiplier shorthand and
+// currency, as in Rp50.000, $5.50 or 1.5k
+func (sp *numberSpeller) amount(s, multiplier string, currency currencyName) (string, bool) {
+	if currency.separators != "" {
+		// Rp50.000 is fifty thousand rupiah in English too
+		local := *sp
+		local.thousandsSep, local.decimalSep = currency.separators[0], currency.separators[1]
+		sp = &local
+	}
+	whole, frac, ok := sp.splitNumeral(s)
+	if !ok || len(whole) > maxSpelledDigits {
+		return "", false
+	}
+	n, _ := strconv.ParseUint(whole, 10, 64)
+
+	var words string
+	switch {
+	case multiplier != "":
+		// 1.5k is 1500, read without its decimal part when it has none
+		scale := multipliers[multiplier]
+		fracValue, _ := strconv.ParseUint(frac, 10, 64)
+		pow := uint64(1)
+		for range frac {
+			pow *= 10
+		}
+		if frac != "" && (fracValue*scale)%pow != 0 {
+			return "", false
+		}
+		n = n*scale + fracValue*scale/pow
+		words = sp.cardinal(n)
+	case frac != "" && currency.cents != "" && len(frac) == 2:
+		words = sp.cardinal(n)
+		if cents, _ := strconv.ParseUint(frac, 10, 64); cents > 0 {
+			name := currency.many
+			if n == 1 {
+				name = currency.one
+			}
+			if sp.and != "" {
+				return words + " " + name + " " + sp.and + " " + sp.cardinal(cents) + " " + currency.cents, true
+			}
+			return words + " " + name + " " + sp.cardinal(cents) + " " + currency.cents, true
+		}
+	case frac != "" && strings.Trim(frac, "0") == "" && currency.many != "":
+		// Rp50.000,00
+		words = sp.cardinal(n)
+	default:
+		words, _ = sp.number(s)
+	}
+
+	switch {
+	case currency.many == "":
+		return words, true
+	case n == 1 && (frac == "" || strings.Trim(frac, "0") == "") && multiplier == "":
+		return words + " " + currency.one, true
+	default:
+		return words + " " + currency.many, true
+	}
+}
+
+var (
+	englishOnes = []string{"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen", "seventeen", "eighteen", "nineteen"}
+	englishTens   = []string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+	englishScales = []struct {
+		value uint64
+		name  string
+	}{{1e12, "trillion"}, {1e9, "billion"}, {1e6, "million"}, {1e3, "thousand"}, {100, "hundred"}}
+	englishOrdinals = map[string]string{
+		"one": "first", "two": "second", "three": "third", "five": "fifth",
+		"eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+	}
+)
+
+// spellEnglish spells n in English words, such as "one hundred fifty
+// thousand" or "twenty-one"
+func spellEnglish(n uint64) string {
+	switch {
+	case n < 20:
+		return englishOnes[n]
+	case n < 100:
+		if n%10 == 0 {
+			return englishTens[n/10]
+		}
+		return englishTens[n/10] + "-" + englishOnes[n%10]
+	}
+	for _, scale := range englishScales {
+		if n >= scale.value {
+			words := spellEnglish(n/scale.value) + " " + scale.name
+			if rest := n % scale.value; rest > 0 {
+				words += " " + spellEnglish(rest)
+			}
+			return words
+		}
+	}
+	return ""
+}
+
+// ordinalEnglish spells n as an English ordinal, such as "twenty-first"
+func ordinalEnglish(n uint64) string {
+	words := spellEnglish(n)
+	i := strings.LastIndexAny(words, " -") + 1
+	last := words[i:]
+	switch {
+	case englishOrdinals[last] != "":
+		last = englishOrdinals[last]
+	case strings.HasSuffix(last, "y"):
+		last = strings.TrimSuffix(last, "y") + "ieth"
+	default:
+		last += "th"
+	}
+	return words[:i] + last
+}
+
+var indonesianOnes = []string{"nol", "satu", "dua", "tiga", "empat", "lima", "enam", "tujuh", "delapan", "sembilan",
+	"sepuluh", "sebelas"}
+
+// spellIndonesian spells n in Indonesian words, such as "seratus lima puluh
+// ribu" or "dua puluh satu"
+func spellIndonesian(n uint64) string {
+	rest := func(r uint64) string {
+		if r == 0 {
+			return ""
+		}
+		return " " + spellIndonesian(r)
+	}
+	switch {
+	case n < 12:
+		return indonesianOnes[n]
+	case n < 20:
+		return indonesianOnes[n-10] + " belas"
+	case n < 100:
+		return indonesianOnes[n/10] + " puluh" + rest(n%10)
+	case n < 200:
+		return "seratus" + rest(n-100)
+	case n < 1000:
+		return indonesianOnes[n/100] + " ratus" + rest(n%100)
+	case n < 2000:
+		return "seribu" + rest(n-1000)
+	case n < 1e6:
+		return spellIndonesian(n/1e3) + " ribu" + rest(n%1e3)
+	case n < 1e9:
+		return spellIndonesian(n/1e6) + " juta" + rest(n%1e6)
+	case n < 1e12:
+		return spellIndonesian(n/1e9) + " miliar" + rest(n%1e9)
+	}
+	return spellIndonesian(n/1e12) + " triliun" + rest(n%1e12)
+}
+
+// ordinalIndonesian spells n as an Indonesian ordinal, such as "kedua"
+func ordinalIndonesian(n uint64) string {
+	if n == 1 {
+		return "pertama"
+	}
+	return "ke" + spellIndonesian(n)
+}
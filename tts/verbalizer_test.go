@@ -0,0 +1,73 @@
+package tts
+
+import "testing"
+
+func TestVerbalize(t *testing.T) {
+	tests := []struct {
+		lang, text, want string
+	}{
+		{LangEnglish, "150000", "one hundred fifty thousand"},
+		{LangEnglish, "1,250,000 views", "one million two hundred fifty thousand views"},
+		{LangEnglish, "pi is 3.14", "pi is three point one four"},
+		{LangEnglish, "50% off", "fifty percent off"},
+		{LangEnglish, "2.5%", "two point five percent"},
+		{LangEnglish, "see you at 9:05pm", "see you at nine oh five PM"},
+		{LangEnglish, "stream starts 19:00", "stream starts nineteen o'clock"},
+		{LangEnglish, "1st 2nd 3rd 11th 21st 40th", "first second third eleventh twenty-first fortieth"},
+		{LangEnglish, "$5", "five dollars"},
+		{LangEnglish, "$1", "one dollar"},
+		{LangEnglish, "$5.50 each", "five dollars and fifty cents each"},
+		{LangEnglish, "$5k", "five thousand dollars"},
+		{LangEnglish, "Rp50.000", "fifty thousand rupiah"},
+		{LangEnglish, "1.5k subs", "one thousand five hundred subs"},
+		{LangEnglish, "call 0812345", "call zero eight one two three four five"},
+		{LangEnglish, "top10", "top ten"},
+		{LangEnglish, "1,2,3", "one, two, three"},
+
+		{LangIndonesian, "150000", "seratus lima puluh ribu"},
+		{LangIndonesian, "Rp50.000", "lima puluh ribu rupiah"},
+		{LangIndonesian, "Rp 1.500.000,00", "satu juta lima ratus ribu rupiah"},
+		{LangIndonesian, "donasi 50rb", "donasi lima puluh ribu"},
+		{LangIndonesian, "2jt", "dua juta"},
+		{LangIndonesian, "$5", "lima dolar"},
+		{LangIndonesian, "1,5 kg", "satu koma lima kg"},
+		{LangIndonesian, "1.5 kg", "satu koma lima kg"},
+		{LangIndonesian, "diskon 25%", "diskon dua puluh lima persen"},
+		{LangIndonesian, "jam 14:30", "jam empat belas tiga puluh"},
+		{LangIndonesian, "juara ke-1 dan ke2", "juara pertama dan kedua"},
+		{LangIndonesian, "11 12 19 100 1000 1111", "sebelas dua belas sembilan belas seratus seribu seribu seratus sebelas"},
+		{LangIndonesian, "2024", "dua ribu dua puluh empat"},
+
+		// Languages without a speller are left alone
+		{LangJapanese, "$5", "$5"},
+		{"", "150000", "150000"},
+	}
+	for _, tt := range tests {
+		if got := Verbalize(tt.text, tt.lang); got != tt.want {
+			t.Errorf("Verbalize(%q, %q) = %q, want %q", tt.text, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeVerbalizesForVoice(t *testing.T) {
+	s := NewTextSanitizer()
+	tests := []struct {
+		voiceID, text, want string
+	}{
+		{"en_us_001", "$5 for 2 https://example.com/123", "five dollars for two link"},
+		{"id_male_darma", "Rp50.000 & 10%", "lima puluh ribu rupiah and sepuluh persen"},
+		// Before the voice is known, numbers and their symbols are kept
+		{"", "$5 & 10% @ 9:30", "$5 and 10% at 9:30"},
+		{"jp_001", "$5", "dollar5"},
+	}
+	for _, tt := range tests {
+		if got := s.Sanitize(tt.text, tt.voiceID); got != tt.want {
+			t.Errorf("Sanitize(%q, %q) = %q, want %q", tt.text, tt.voiceID, got, tt.want)
+		}
+	}
+
+	// The first pass does not get in the way of the second
+	if got := s.Sanitize(s.Sanitize("$5.50", ""), "en_us_001"); got != "five dollars and fifty cents" {
+		t.Errorf("two passes gave %q", got)
+	}
+}
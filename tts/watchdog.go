@@ -0,0 +1,107 @@
+package tts
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// Why the watchdog moved the queue on
+const (
+	WatchdogTimeout      = "timeout"      // no avatar_finished in time
+	WatchdogDisconnected = "disconnected" // the speaking avatar's last client left
+)
+
+// TTSWatchdogEvent reports an item the queue gave up waiting for
+type TTSWatchdogEvent struct {
+	ID       string `json:"id"`
+	AvatarID string `json:"avatar_id"`
+	Reason   string `json:"reason"`
+	Message  string `json:"message"`
+}
+
+// OnWatchdog registers fn to be told whenever the watchdog moves the queue
+// on without an avatar_finished signal
+func (tm *TTSMiddleware) OnWatchdog(fn func(TTSWatchdogEvent)) {
+	tm.queueMux.Lock()
+	defer tm.queueMux.Unlock()
+	tm.onWatchdog = fn
+}
+
+// speakingTimeout is how long an avatar may take to report an item
+// finished: the clip's length, or UnknownClipDuration when that is not
+// known, plus a grace period for loading and latency
+func (tm *TTSMiddleware) speakingTimeout(item TTSQueueItem) time.Duration {
+	duration := item.Duration
+	if duration <= 0 {
+		duration = UnknownClipDuration
+	}
+	return duration + tm.speakingGrace
+}
+
+// watchItem advances the queue if item is still being spoken after its
+// timeout
+func (tm *TTSMiddleware) watchItem(item TTSQueueItem) {
+	timeout := tm.speakingTimeout(item)
+	time.AfterFunc(timeout, func() {
+		tm.forceAdvance(item.ID, WatchdogTimeout,
+			fmt.Errorf("avatar %s did not finish within %v", item.AvatarID, timeout.Round(time.Millisecond)))
+	})
+}
+
+// avatarLeft advances the queue if the avatar whose last client just
+// disconnected was speaking
+func (tm *TTSMiddleware) avatarLeft(avatarID string) {
+	tm.queueMux.Lock()
+	current := tm.speaking[avatarID]
+	tm.queueMux.Unlock()
+
+	if current != nil {
+		tm.forceAdvance(current.ID, WatchdogDisconnected,
+			fmt.Errorf("avatar %s disconnected while speaking", avatarID))
+	}
+}
+
+// forceAdvance drops the item with id if it is still being spoken, and
+// moves on to the next one
+func (tm *TTSMiddleware) forceAdvance(id, reason string, err error) {
+	tm.queueMux.Lock()
+	var current *TTSQueueItem
+	for _, item := range tm.speaking {
+		if item.ID == id {
+			current = item
+		}
+	}
+	if current == nil {
+		tm.queueMux.Unlock()
+		return
+	}
+	delete(tm.speaking, current.AvatarID)
+	tm.unstoreQueueItems(*current)
+	tm.jobs.finish(current.ID, JobFailed, err)
+	notify := tm.onWatchdog
+	tm.queueMux.Unlock()
+	tm.flushQueueStore()
+
+	log.Printf("Queue: Watchdog moved past item %s - %v", current.ID, err)
+
+	// A page that is merely slow must not keep playing over the next item
+	if reason == WatchdogTimeout {
+		tm.sendToAvatar(current.AvatarID, map[string]interface{}{
+			"signal":       "avatar_stop",
+			"avatar_audio": current.BlobURL,
+		})
+	}
+	tm.queueCleanup(tm.blobPath(*current), 0)
+
+	if notify != nil {
+		notify(TTSWatchdogEvent{
+			ID:       current.ID,
+			AvatarID: current.AvatarID,
+			Reason:   reason,
+			Message:  err.Error(),
+		})
+	}
+	tm.queueUpdated()
+	tm.processNextInQueue()
+}
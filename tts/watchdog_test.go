@@ -0,0 +1,71 @@
+package tts
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatchdogAdvancesStuckQueue(t *testing.T) {
+	now := time.Now()
+	tm := newTestQueue(t,
+		TTSQueueItem{ID: "a", AvatarID: "avatar", BlobURL: "/tts-blob/a.mp3", QueuedAt: now.Add(-time.Second), Duration: 20 * time.Millisecond},
+		TTSQueueItem{ID: "b", AvatarID: "avatar", BlobURL: "/tts-blob/b.mp3", QueuedAt: now},
+	)
+	tm.speakingGrace = 50 * time.Millisecond
+	tm.jobs.add(TTSJob{ID: "a", State: JobQueued})
+
+	var mu sync.Mutex
+	var events []TTSWatchdogEvent
+	tm.OnWatchdog(func(event TTSWatchdogEvent) {
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(tm.HandleWebSocket))
+	defer server.Close()
+	conn := dialAvatar(t, tm, server, "avatar")
+	tm.processNextInQueue()
+
+	// The page never reports "a" finished, so it is stopped and "b" starts
+	var signals []string
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for len(signals) < 3 {
+		var msg map[string]interface{}
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("after %v: %v", signals, err)
+		}
+		signals = append(signals, msg["signal"].(string)+" "+msg["avatar_audio"].(string))
+	}
+	want := "avatar_speak /tts-blob/a.mp3,avatar_stop /tts-blob/a.mp3,avatar_speak /tts-blob/b.mp3"
+	if got := strings.Join(signals, ","); got != want {
+		t.Errorf("signals = %s, want %s", got, want)
+	}
+	if job, _ := tm.Job("a"); job.State != JobFailed || job.Error == "" {
+		t.Errorf("timed out job: %+v", job)
+	}
+
+	// "b" has no known length, but its avatar leaving ends it right away
+	conn.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		done := len(events) == 2
+		mu.Unlock()
+		if done || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 || events[0].ID != "a" || events[0].Reason != WatchdogTimeout ||
+		events[1].ID != "b" || events[1].Reason != WatchdogDisconnected {
+		t.Errorf("events = %+v", events)
+	}
+}
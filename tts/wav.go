@@ -0,0 +1,130 @@
+package tts
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// wavFile holds the parts of a RIFF/WAVE file needed to measure and join it
+type wavFile struct {
+	format []byte // raw "fmt " chunk payload
+	data   []byte // raw "data" chunk payload
+}
+
+// byteRate returns the number of audio bytes per second
+func (w wavFile) byteRate() int {
+	return int(binary.LittleEndian.Uint32(w.format[8:12]))
+}
+
+// audioFormat returns the format tag, 1 for integer PCM
+func (w wavFile) audioFormat() int {
+	return int(binary.LittleEndian.Uint16(w.format[0:2]))
+}
+
+// channels returns the number of interleaved channels
+func (w wavFile) channels() int {
+	return int(binary.LittleEndian.Uint16(w.format[2:4]))
+}
+
+// sampleRate returns the number of samples per second and channel
+func (w wavFile) sampleRate() int {
+	return int(binary.LittleEndian.Uint32(w.format[4:8]))
+}
+
+// bitsPerSample returns the size of a single sample
+func (w wavFile) bitsPerSample() int {
+	return int(binary.LittleEndian.Uint16(w.format[14:16]))
+}
+
+// parseWAV extracts the fmt and data chunks of a WAV file
+func parseWAV(data []byte) (wavFile, error) {
+	var w wavFile
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WAVE" {
+		return w, fmt.Errorf("not a WAV file")
+	}
+
+	for i := 12; i+8 <= len(data); {
+		id := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4 : i+8]))
+		start := i + 8
+		end := start + size
+		if end > len(data) {
+			// Streaming encoders (Piper among them) write a placeholder size
+			// for the data chunk, so take whatever is left
+			end = len(data)
+		}
+
+		switch id {
+		case "fmt ":
+			if end-start < 16 {
+				return w, fmt.Errorf("fmt chunk too short")
+			}
+			w.format = data[start:end]
+		case "data":
+			w.data = data[start:end]
+		}
+
+		// Chunks are padded to an even length
+		i = end + size%2
+	}
+
+	if w.format == nil || w.data == nil {
+		return w, fmt.Errorf("WAV file is missing its fmt or data chunk")
+	}
+	if w.byteRate() == 0 {
+		return w, fmt.Errorf("WAV file has a zero byte rate")
+	}
+	return w, nil
+}
+
+// WAVDuration returns the playback length of a WAV file
+func WAVDuration(data []byte) (time.Duration, error) {
+	w, err := parseWAV(data)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(len(w.data)) * time.Second / time.Duration(w.byteRate()), nil
+}
+
+// StitchWAV joins WAV files that share the same sample format into one file
+// and returns it with the combined duration
+func StitchWAV(chunks [][]byte) ([]byte, time.Duration, error) {
+	var format []byte
+	var pcm bytes.Buffer
+
+	for i, chunk := range chunks {
+		w, err := parseWAV(chunk)
+		if err != nil {
+			return nil, 0, fmt.Errorf("chunk %d: %v", i, err)
+		}
+		if format == nil {
+			format = w.format
+		} else if !bytes.Equal(format, w.format) {
+			return nil, 0, fmt.Errorf("chunk %d: sample format differs from chunk 0", i)
+		}
+		pcm.Write(w.data)
+	}
+	if format == nil {
+		return nil, 0, fmt.Errorf("no WAV chunks to stitch")
+	}
+
+	rate := binary.LittleEndian.Uint32(format[8:12])
+	return encodeWAV(format, pcm.Bytes()), time.Duration(pcm.Len()) * time.Second / time.Duration(rate), nil
+}
+
+// encodeWAV builds a WAV file from a fmt chunk payload and PCM data
+func encodeWAV(format, pcm []byte) []byte {
+	var out bytes.Buffer
+	out.WriteString("RIFF")
+	binary.Write(&out, binary.LittleEndian, uint32(4+8+len(format)+8+len(pcm)))
+	out.WriteString("WAVE")
+	out.WriteString("fmt ")
+	binary.Write(&out, binary.LittleEndian, uint32(len(format)))
+	out.Write(format)
+	out.WriteString("data")
+	binary.Write(&out, binary.LittleEndian, uint32(len(pcm)))
+	out.Write(pcm)
+	return out.Bytes()
+}
@@ -123,6 +123,10 @@ type FileStorage interface {
 } 
 
 type TTSVoice struct {
-    VoiceID  string `json:"voice_id"`
-    Provider string `json:"provider"` // "google" or "tiktok"
+    VoiceID   string     `json:"voice_id"`
+    Provider  string     `json:"provider"`            // "google" or "tiktok"
+    Speed     float64    `json:"speed,omitempty"`     // playback rate, 1 (or unset) is normal
+    Pitch     float64    `json:"pitch,omitempty"`     // semitones, 0 is normal
+    Gain      float64    `json:"gain,omitempty"`      // dB, 0 is normal
+    Fallbacks []TTSVoice `json:"fallbacks,omitempty"` // tried in order when this voice fails
 } 
\ No newline at end of file